@@ -0,0 +1,356 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	winStreakTarget = 10
+	quickWinMoves   = 20
+	// maxStreak bounds how far back streaks are counted.
+	maxStreak = 100
+)
+
+// AchievementDefinition describes an achievement. Game achievements have a
+// check run for each player when one of their games completes; puzzle
+// achievements unlock at a run of puzzles solved in a row.
+type AchievementDefinition struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	game         func(as *AchievementService, e *gameEvent) (bool, error)
+	puzzleStreak int
+}
+
+// achievements is the registry of every achievement, in display order.
+var achievements = []AchievementDefinition{
+	{
+		ID:          "first-win",
+		Name:        "First Victory",
+		Description: "Win your first game",
+		game:        func(as *AchievementService, e *gameEvent) (bool, error) { return e.won, nil },
+	},
+	{
+		ID:          "win-streak-10",
+		Name:        "Unstoppable",
+		Description: "Win 10 games in a row",
+		game:        (*AchievementService).checkWinStreak,
+	},
+	{
+		ID:          "knight-mate",
+		Name:        "Knight Rider",
+		Description: "Deliver checkmate with a knight",
+		game:        (*AchievementService).checkKnightMate,
+	},
+	{
+		ID:          "quick-win",
+		Name:        "Blitzkrieg",
+		Description: "Win a game in under 20 moves",
+		game:        (*AchievementService).checkQuickWin,
+	},
+	{
+		ID:           "puzzle-streak-5",
+		Name:         "Puzzle Solver",
+		Description:  "Solve 5 puzzles in a row",
+		puzzleStreak: 5,
+	},
+	{
+		ID:           "puzzle-streak-25",
+		Name:         "Puzzle Master",
+		Description:  "Solve 25 puzzles in a row",
+		puzzleStreak: 25,
+	},
+}
+
+// gameEvent is a completed game seen from one of its players.
+type gameEvent struct {
+	game   *Game
+	moves  []GameMove
+	userID int
+	won    bool
+}
+
+// AchievementService unlocks achievements from completed games and puzzle
+// attempts and tells the user about each new one.
+type AchievementService struct {
+	db            *sql.DB
+	gameService   *GameService
+	notifications *NotificationService
+}
+
+func NewAchievementService(db *sql.DB, gameService *GameService, notifications *NotificationService) *AchievementService {
+	return &AchievementService{
+		db:            db,
+		gameService:   gameService,
+		notifications: notifications,
+	}
+}
+
+// GameCompleted is the game completion hook. It runs the game achievement
+// checks for both players.
+func (as *AchievementService) GameCompleted(game *Game) {
+	if game.WhitePlayerID == nil || game.BlackPlayerID == nil {
+		return
+	}
+	moves, err := as.gameService.GetGameMoves(game.ID)
+	if err != nil {
+		log.Println("Error fetching moves for achievements:", err)
+		return
+	}
+
+	winner := ""
+	if game.Winner != nil {
+		winner = *game.Winner
+	}
+	players := map[string]int{"white": *game.WhitePlayerID, "black": *game.BlackPlayerID}
+	for color, userID := range players {
+		e := &gameEvent{game: game, moves: moves, userID: userID, won: winner == color}
+		as.check(userID, func(def AchievementDefinition) (bool, error) {
+			if def.game == nil {
+				return false, nil
+			}
+			return def.game(as, e)
+		})
+	}
+}
+
+// PuzzleAttempted unlocks the puzzle streak achievements userID has reached
+// and returns their current streak.
+func (as *AchievementService) PuzzleAttempted(userID int) int {
+	streak, err := as.puzzleStreak(userID)
+	if err != nil {
+		log.Println("Error counting puzzle streak:", err)
+		return 0
+	}
+	as.check(userID, func(def AchievementDefinition) (bool, error) {
+		return def.puzzleStreak > 0 && streak >= def.puzzleStreak, nil
+	})
+	return streak
+}
+
+// check unlocks every achievement userID does not have yet for which
+// earned reports true.
+func (as *AchievementService) check(userID int, earned func(AchievementDefinition) (bool, error)) {
+	unlocked, err := as.unlocked(userID)
+	if err != nil {
+		log.Println("Error fetching achievements:", err)
+		return
+	}
+	for _, def := range achievements {
+		if _, ok := unlocked[def.ID]; ok {
+			continue
+		}
+		ok, err := earned(def)
+		if err != nil {
+			log.Println("Error checking achievement "+def.ID+":", err)
+			continue
+		}
+		if ok {
+			as.unlock(userID, def)
+		}
+	}
+}
+
+// unlock records def for userID and notifies them the first time.
+func (as *AchievementService) unlock(userID int, def AchievementDefinition) {
+	result, err := as.db.Exec(`
+        INSERT INTO user_achievements (user_id, achievement_id)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id, achievement_id) DO NOTHING
+    `, userID, def.ID)
+	if err != nil {
+		log.Println("Error unlocking achievement:", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+	as.notifications.Notify(userID, NotifyAchievement, map[string]interface{}{
+		"achievement": def,
+	})
+}
+
+// unlocked returns when userID unlocked each of their achievements.
+func (as *AchievementService) unlocked(userID int) (map[string]time.Time, error) {
+	rows, err := as.db.Query(`SELECT achievement_id, unlocked_at FROM user_achievements WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unlocked := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		unlocked[id] = at
+	}
+	return unlocked, rows.Err()
+}
+
+func (as *AchievementService) checkWinStreak(e *gameEvent) (bool, error) {
+	if !e.won {
+		return false, nil
+	}
+	streak, err := as.winStreak(e.userID)
+	return streak >= winStreakTarget, err
+}
+
+// checkKnightMate reports whether the player won by mating with a knight.
+func (as *AchievementService) checkKnightMate(e *gameEvent) (bool, error) {
+	if !e.won || len(e.moves) == 0 {
+		return false, nil
+	}
+	last := e.moves[len(e.moves)-1]
+	if last.PlayerID != e.userID || !strings.EqualFold(last.Piece, "n") {
+		return false, nil
+	}
+	pos, err := NewGamePosition(e.game.Variant, last.FENAfter)
+	if err != nil {
+		return false, err
+	}
+	return pos.IsCheckmate(), nil
+}
+
+// checkQuickWin reports whether the player won having made fewer than
+// quickWinMoves moves.
+func (as *AchievementService) checkQuickWin(e *gameEvent) (bool, error) {
+	if !e.won {
+		return false, nil
+	}
+	played := 0
+	for _, m := range e.moves {
+		if m.PlayerID == e.userID {
+			played++
+		}
+	}
+	return played > 0 && played < quickWinMoves, nil
+}
+
+// winStreak counts userID's wins in a row in their latest completed games.
+func (as *AchievementService) winStreak(userID int) (int, error) {
+	rows, err := as.db.Query(`
+        SELECT (winner = 'white' AND white_player_id = $1) OR (winner = 'black' AND black_player_id = $1)
+        FROM games
+        WHERE status = 'completed' AND winner IN ('white', 'black', 'draw')
+          AND (white_player_id = $1 OR black_player_id = $1) AND black_player_id IS NOT NULL
+        ORDER BY updated_at DESC
+        LIMIT $2
+    `, userID, maxStreak)
+	if err != nil {
+		return 0, err
+	}
+	return countStreak(rows)
+}
+
+// puzzleStreak counts userID's puzzles solved in a row in their latest
+// attempts. Only the first attempt at each puzzle is stored.
+func (as *AchievementService) puzzleStreak(userID int) (int, error) {
+	rows, err := as.db.Query(`
+        SELECT solved FROM puzzle_attempts
+        WHERE user_id = $1
+        ORDER BY id DESC
+        LIMIT $2
+    `, userID, maxStreak)
+	if err != nil {
+		return 0, err
+	}
+	return countStreak(rows)
+}
+
+// countStreak counts the leading true values of a single boolean column.
+func countStreak(rows *sql.Rows) (int, error) {
+	defer rows.Close()
+	streak := 0
+	for rows.Next() {
+		var ok bool
+		if err := rows.Scan(&ok); err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		streak++
+	}
+	return streak, rows.Err()
+}
+
+// userAchievements lists every achievement with userID's unlock time, or
+// only the unlocked ones.
+func (as *AchievementService) userAchievements(userID int, unlockedOnly bool) ([]UserAchievement, error) {
+	unlocked, err := as.unlocked(userID)
+	if err != nil {
+		return nil, err
+	}
+	list := []UserAchievement{}
+	for _, def := range achievements {
+		ua := UserAchievement{ID: def.ID, Name: def.Name, Description: def.Description}
+		if at, ok := unlocked[def.ID]; ok {
+			ua.UnlockedAt = &at
+		} else if unlockedOnly {
+			continue
+		}
+		list = append(list, ua)
+	}
+	return list, nil
+}
+
+// GetAchievements returns every achievement with the user's progress and
+// current streaks.
+func (as *AchievementService) GetAchievements(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	list, err := as.userAchievements(user.ID, false)
+	if err != nil {
+		log.Println("Error fetching achievements:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	wins, err := as.winStreak(user.ID)
+	if err != nil {
+		log.Println("Error counting win streak:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	puzzles, err := as.puzzleStreak(user.ID)
+	if err != nil {
+		log.Println("Error counting puzzle streak:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"achievements": list,
+		"streaks":      map[string]int{"wins": wins, "puzzles": puzzles},
+	})
+}
+
+// GetUserAchievements lists the achievements another user has unlocked.
+func (as *AchievementService) GetUserAchievements(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	list, err := as.userAchievements(userID, true)
+	if err != nil {
+		log.Println("Error fetching achievements:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
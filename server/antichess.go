@@ -0,0 +1,76 @@
+package main
+
+// antichess reverses the goal: captures are compulsory, the king is an
+// ordinary piece with no check or castling, pawns may promote to a king,
+// and a side wins by losing all its pieces or having no legal move.
+type antichess struct {
+	standardRules
+}
+
+const antichessStartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1"
+
+func (antichess) Name() string {
+	return VariantAntichess
+}
+
+func (antichess) PGNName() string {
+	return "Antichess"
+}
+
+func (antichess) StartFEN(index int) (string, error) {
+	return antichessStartFEN, nil
+}
+
+func (antichess) CheckSetup(pos *Position) error {
+	pos.CastlingRooks = [2][2]Square{{NoSquare, NoSquare}, {NoSquare, NoSquare}}
+	return nil
+}
+
+func (antichess) AddMoves(pos *Position, moves []Move) []Move {
+	var all, captures []Move
+	for _, m := range moves {
+		if m.Flags&FlagCastle != 0 {
+			continue
+		}
+		all = append(all, m)
+		if m.Promotion == Queen {
+			king := m
+			king.Promotion = King
+			all = append(all, king)
+		}
+	}
+	for _, m := range all {
+		if m.IsCapture() {
+			captures = append(captures, m)
+		}
+	}
+	if len(captures) > 0 {
+		return captures
+	}
+	return all
+}
+
+func (antichess) KingInCheck(pos *Position, c Color) bool {
+	return false
+}
+
+func (antichess) Legal(before, after *Position, m Move) bool {
+	return true
+}
+
+func (antichess) Outcome(pos *Position) (bool, string) {
+	if pos.pieceCount(pos.Turn) == 0 || len(pos.LegalMoves()) == 0 {
+		return true, pos.Turn.String()
+	}
+	return false, ""
+}
+
+func (p *Position) pieceCount(c Color) int {
+	count := 0
+	for _, piece := range p.Board {
+		if piece != NoPiece && piece.Color() == c {
+			count++
+		}
+	}
+	return count
+}
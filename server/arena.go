@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Arena scoring: a win is worth 2, a draw 1. After two wins in a row a
+// player is on fire and scores double until they fail to win. A berserked
+// win earns one extra point.
+const (
+	arenaWinPoints  = 2
+	arenaDrawPoints = 1
+	arenaFireStreak = 2
+)
+
+// startArena opens the arena for t.Duration minutes and pairs whoever is
+// already waiting.
+func (ts *TournamentService) startArena(t *Tournament) error {
+	result, err := ts.db.Exec(`
+        UPDATE tournaments
+        SET status = $1, ends_at = CURRENT_TIMESTAMP + make_interval(mins => duration), updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status = $3
+    `, TournamentRunning, t.ID, TournamentRegistering)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	started, err := ts.getTournament(t.ID)
+	if err != nil {
+		return err
+	}
+	*t = *started
+	if err := ts.pairArena(t); err != nil {
+		return err
+	}
+	return ts.pushLeaderboard(t)
+}
+
+// advanceArena ends the arena once its time is up, and otherwise pairs the
+// players who are free again. The leaderboard is pushed when results came in.
+func (ts *TournamentService) advanceArena(t *Tournament, changed bool) error {
+	if t.EndsAt != nil && !time.Now().Before(*t.EndsAt) {
+		if err := ts.finish(t); err != nil {
+			return err
+		}
+		return ts.pushLeaderboard(t)
+	}
+	if err := ts.pairArena(t); err != nil {
+		return err
+	}
+	if changed {
+		return ts.pushLeaderboard(t)
+	}
+	return nil
+}
+
+// pairArena pairs every connected player without a game in progress.
+// Players are matched with their neighbours on the leaderboard, avoiding an
+// immediate rematch where possible. Each batch of pairings is stored as a
+// new round.
+func (ts *TournamentService) pairArena(t *Tournament) error {
+	standings, err := ts.standings(t)
+	if err != nil {
+		return err
+	}
+	pairings, err := ts.pairings(t.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	busy := make(map[int]bool)
+	lastOpponent := make(map[int]int)
+	for _, p := range pairings {
+		if p.BlackID == nil {
+			continue
+		}
+		if p.Result == nil {
+			busy[p.WhiteID] = true
+			busy[*p.BlackID] = true
+		}
+		lastOpponent[p.WhiteID] = *p.BlackID
+		lastOpponent[*p.BlackID] = p.WhiteID
+	}
+
+	var waiting []int
+	for _, s := range standings {
+		if s.Withdrawn || busy[s.UserID] || ts.presence.Get(s.UserID).State == PresenceOffline {
+			continue
+		}
+		waiting = append(waiting, s.UserID)
+	}
+	if len(waiting) < 2 {
+		return nil
+	}
+
+	// Swap in the next player when neighbours just played each other.
+	for i := 0; i+1 < len(waiting); i += 2 {
+		if lastOpponent[waiting[i]] == waiting[i+1] && i+2 < len(waiting) {
+			waiting[i+1], waiting[i+2] = waiting[i+2], waiting[i+1]
+		}
+	}
+	if len(waiting)%2 == 1 {
+		waiting = waiting[:len(waiting)-1]
+	}
+	if len(waiting) == 2 && lastOpponent[waiting[0]] == waiting[1] {
+		// Nobody else is free; wait for someone rather than a rematch.
+		return nil
+	}
+
+	round := t.CurrentRound + 1
+	if claimed, err := claimRound(ts.db, t, round); err != nil || !claimed {
+		return err
+	}
+
+	for i := 0; i < len(waiting); i += 2 {
+		// The player who has had black more recently gets white.
+		white, black := waiting[i], waiting[i+1]
+		if lastColor(pairings, white) == "white" && lastColor(pairings, black) != "white" {
+			white, black = black, white
+		}
+		gameID, err := ts.createPairingGame(t, white, black)
+		if err != nil {
+			return err
+		}
+		pairing := TournamentPairing{TournamentID: t.ID, Round: round, Board: i/2 + 1, WhiteID: white, BlackID: &black, GameID: &gameID}
+		if err := insertPairing(ts.db, &pairing); err != nil {
+			return err
+		}
+		// Arena games come too often for the inbox, so they are only pushed.
+		event := map[string]interface{}{
+			"type": NotifyTournamentRound,
+			"data": map[string]interface{}{"tournament": t, "pairing": pairing},
+		}
+		ts.userHub.Send(white, event)
+		ts.userHub.Send(black, event)
+	}
+	return nil
+}
+
+// lastColor returns the colour userID played most recently, or "".
+func lastColor(pairings []TournamentPairing, userID int) string {
+	for i := len(pairings) - 1; i >= 0; i-- {
+		p := pairings[i]
+		if p.BlackID == nil {
+			continue
+		}
+		if p.WhiteID == userID {
+			return "white"
+		}
+		if *p.BlackID == userID {
+			return "black"
+		}
+	}
+	return ""
+}
+
+// pushLeaderboard sends the current arena standings to every player.
+func (ts *TournamentService) pushLeaderboard(t *Tournament) error {
+	standings, err := ts.standings(t)
+	if err != nil {
+		return err
+	}
+	event := map[string]interface{}{
+		"type": NotifyArenaLeaderboard,
+		"data": map[string]interface{}{
+			"tournament": t,
+			"standings":  standings,
+		},
+	}
+	for _, s := range standings {
+		if !s.Withdrawn {
+			ts.userHub.Send(s.UserID, event)
+		}
+	}
+	return nil
+}
+
+// computeArenaStandings scores every player from the finished pairings in
+// the order they were played and ranks them by score, then seed.
+func computeArenaStandings(players []TournamentStanding, pairings []TournamentPairing) []TournamentStanding {
+	index := make(map[int]int, len(players))
+	for i := range players {
+		index[players[i].UserID] = i
+		players[i].Score = 0
+		players[i].Played = 0
+		players[i].OnFire = false
+	}
+
+	streak := make(map[int]int)
+	score := func(userID int, points float64, berserk bool) {
+		i, ok := index[userID]
+		if !ok {
+			return
+		}
+		won := points == 1
+		base := 0
+		switch points {
+		case 1:
+			base = arenaWinPoints
+		case 0.5:
+			base = arenaDrawPoints
+		}
+		if streak[userID] >= arenaFireStreak {
+			base *= 2
+		}
+		if won && berserk {
+			base++
+		}
+		if won {
+			streak[userID]++
+		} else {
+			streak[userID] = 0
+		}
+		players[i].Score += float64(base)
+		players[i].Played++
+		players[i].OnFire = streak[userID] >= arenaFireStreak
+	}
+
+	for _, p := range pairings {
+		if p.Result == nil || p.BlackID == nil {
+			continue
+		}
+		white, black := resultPoints(*p.Result)
+		score(p.WhiteID, white, p.WhiteBerserk)
+		score(*p.BlackID, black, p.BlackBerserk)
+	}
+
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].Score != players[j].Score {
+			return players[i].Score > players[j].Score
+		}
+		return players[i].Seed < players[j].Seed
+	})
+	for i := range players {
+		players[i].Rank = i + 1
+	}
+	return players
+}
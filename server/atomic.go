@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+// atomic makes every capture explode: the capturing piece and all non-pawn
+// pieces next to the capture square leave the board. Blowing up the enemy
+// king wins, kings cannot capture, and touching kings cannot give check.
+type atomic struct {
+	standardRules
+}
+
+func (atomic) Name() string {
+	return VariantAtomic
+}
+
+func (atomic) PGNName() string {
+	return "Atomic"
+}
+
+func (atomic) AfterMove(before, after *Position, m Move) {
+	if !m.IsCapture() {
+		return
+	}
+
+	after.Board[m.To] = NoPiece
+	for _, o := range kingOffsets {
+		if sq, ok := m.To.offset(o[0], o[1]); ok && after.Board[sq].Type() != Pawn {
+			after.Board[sq] = NoPiece
+		}
+	}
+
+	for c := range after.CastlingRooks {
+		for side, rook := range after.CastlingRooks[c] {
+			if rook != NoSquare && after.Board[rook] == NoPiece {
+				after.CastlingRooks[c][side] = NoSquare
+			}
+		}
+	}
+}
+
+func (atomic) KingInCheck(pos *Position, c Color) bool {
+	king, enemy := pos.kingSquare(c), pos.kingSquare(c.Other())
+	if king == NoSquare || enemy == NoSquare || kingsTouch(king, enemy) {
+		return false
+	}
+	return pos.Attacked(king, c.Other())
+}
+
+// SquareAttacked ignores the enemy king, since a king next to it cannot be
+// checked.
+func (atomic) SquareAttacked(pos *Position, sq Square, by Color) bool {
+	enemy := pos.kingSquare(by)
+	if enemy != NoSquare && kingsTouch(sq, enemy) {
+		return false
+	}
+	return pos.Attacked(sq, by)
+}
+
+func (v atomic) Legal(before, after *Position, m Move) bool {
+	us := before.Turn
+	if m.Piece.Type() == King && m.IsCapture() {
+		return false
+	}
+	if after.kingSquare(us) == NoSquare {
+		return false
+	}
+	if after.kingSquare(us.Other()) == NoSquare {
+		return true
+	}
+	return !v.KingInCheck(after, us)
+}
+
+func (v atomic) Outcome(pos *Position) (bool, string) {
+	for _, c := range []Color{White, Black} {
+		if pos.kingSquare(c) == NoSquare {
+			return true, c.Other().String()
+		}
+	}
+	return v.standardRules.Outcome(pos)
+}
+
+func (atomic) CheckSetup(pos *Position) error {
+	if pos.kingSquare(White) == NoSquare && pos.kingSquare(Black) == NoSquare {
+		return fmt.Errorf("at least one king is required")
+	}
+	return nil
+}
+
+func kingsTouch(a, b Square) bool {
+	df, dr := a.File()-b.File(), a.Rank()-b.Rank()
+	return df >= -1 && df <= 1 && dr >= -1 && dr <= 1
+}
@@ -46,6 +46,10 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type contextKey string
+
+const userContextKey contextKey = "user"
+
 type AuthResponse struct {
 	User    *User  `json:"user"`
 	Message string `json:"message"`
@@ -372,10 +376,28 @@ func (a *AuthService) RequireAuth(handler http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		handler(w, r)
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		handler(w, r.WithContext(ctx))
 	}
 }
 
+// RequireRole is RequireAuth for users holding role or a higher one.
+func (a *AuthService) RequireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return a.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).HasRole(role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// currentUser returns the user stored on the request by RequireAuth.
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
 func (a *AuthService) getUserFromContext(r *http.Request) *User {
 	// Try to get token from cookie first
 	cookie, err := r.Cookie("token")
@@ -404,11 +426,13 @@ func (a *AuthService) getUserFromContext(r *http.Request) *User {
 
 	user := &User{}
 	err = a.db.QueryRow(`
-        SELECT id, google_id, email, name, avatar_url, created_at, updated_at, active_game
+        SELECT id, google_id, email, name, avatar_url, created_at, updated_at, active_game,
+               COALESCE(role, 'user'), banned_until, COALESCE(ban_permanent, FALSE), muted_until
         FROM users WHERE id = $1
     `, claims.UserID).Scan(
 		&user.ID, &user.GoogleID, &user.Email, &user.Name,
 		&user.AvatarURL, &user.CreatedAt, &user.UpdatedAt, &user.ActiveGame,
+		&user.Role, &user.BannedUntil, &user.BanPermanent, &user.MutedUntil,
 	)
 
 	if err != nil {
@@ -416,5 +440,10 @@ func (a *AuthService) getUserFromContext(r *http.Request) *User {
 		return nil
 	}
 
+	// A banned account's tokens stay valid but are refused everywhere.
+	if user.IsBanned() {
+		return nil
+	}
+
 	return user
 }
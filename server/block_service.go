@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxReportLength = 2000
+
+// BlockService keeps user blocklists and the moderation report queue. A
+// block works both ways: neither user can challenge, chat to or be seated
+// against the other.
+type BlockService struct {
+	db *sql.DB
+}
+
+func NewBlockService(db *sql.DB) *BlockService {
+	return &BlockService{db: db}
+}
+
+type ReportRequest struct {
+	ReportedID  int     `json:"reported_id"`
+	Reason      string  `json:"reason"`
+	GameID      *string `json:"game_id"`
+	ChatExcerpt string  `json:"chat_excerpt"`
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (bs *BlockService) IsBlocked(a, b int) bool {
+	var blocked bool
+	err := bs.db.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM user_blocks
+            WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+        )
+    `, a, b).Scan(&blocked)
+	if err != nil {
+		log.Println("Error checking block:", err)
+	}
+	return blocked
+}
+
+// BlockedIDs returns the users userID has blocked.
+func (bs *BlockService) BlockedIDs(userID int) map[int]bool {
+	blocked := make(map[int]bool)
+	rows, err := bs.db.Query(`SELECT blocked_id FROM user_blocks WHERE blocker_id = $1`, userID)
+	if err != nil {
+		log.Println("Error fetching blocks:", err)
+		return blocked
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			blocked[id] = true
+		}
+	}
+	return blocked
+}
+
+func (bs *BlockService) GetBlocks(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	rows, err := bs.db.Query(`
+        SELECT u.id, u.name, u.email, u.avatar_url
+        FROM user_blocks b
+        JOIN users u ON u.id = b.blocked_id
+        WHERE b.blocker_id = $1
+        ORDER BY b.created_at DESC
+    `, user.ID)
+	if err != nil {
+		log.Println("Error fetching blocks:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.AvatarURL); err != nil {
+			log.Println("Error scanning block:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		users = append(users, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// BlockUser blocks the user in the path and ends any friendship with them.
+func (bs *BlockService) BlockUser(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	blockedID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || blockedID == user.ID {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := bs.db.Exec(`
+        INSERT INTO user_blocks (blocker_id, blocked_id)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING
+    `, user.ID, blockedID); err != nil {
+		log.Println("Error blocking user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := bs.db.Exec(`
+        DELETE FROM friendships
+        WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
+    `, user.ID, blockedID); err != nil {
+		log.Println("Error removing friendship of blocked user:", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (bs *BlockService) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	blockedID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := bs.db.Exec(`DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`, user.ID, blockedID); err != nil {
+		log.Println("Error unblocking user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReportUser files a report into the moderation queue.
+func (bs *BlockService) ReportUser(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var req ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case req.ReportedID == 0 || req.ReportedID == user.ID:
+		http.Error(w, "Invalid reported user", http.StatusBadRequest)
+		return
+	case req.Reason == "":
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	case len(req.Reason) > maxReportLength || len(req.ChatExcerpt) > maxReportLength:
+		http.Error(w, "Report is too long", http.StatusBadRequest)
+		return
+	}
+
+	report := Report{
+		ReporterID:  user.ID,
+		ReportedID:  req.ReportedID,
+		Reason:      req.Reason,
+		GameID:      req.GameID,
+		ChatExcerpt: req.ChatExcerpt,
+		Status:      "open",
+	}
+	err := bs.db.QueryRow(`
+        INSERT INTO reports (reporter_id, reported_id, reason, game_id, chat_excerpt)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+    `, report.ReporterID, report.ReportedID, report.Reason, report.GameID, report.ChatExcerpt).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		log.Println("Error filing report:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// bughouse is played by two teams of two on a pair of crazyhouse boards.
+// Captured pieces go to the partner on the other board rather than to the
+// capturer, so a single board never credits its own pockets.
+type bughouse struct {
+	crazyhouse
+}
+
+func (bughouse) Name() string {
+	return VariantBughouse
+}
+
+func (bughouse) PGNName() string {
+	return "Bughouse"
+}
+
+func (bughouse) AfterMove(before, after *Position, m Move) {
+	trackPromotions(before, after, m)
+}
+
+// bughouseBoardID is the room and game ID of board 0 (a) or 1 (b) of a match.
+func bughouseBoardID(matchID string, board int) string {
+	if board == 1 {
+		return matchID + "-b"
+	}
+	return matchID + "-a"
+}
+
+// BughouseMatch links the two rooms of a bughouse game. Team 1 plays white
+// on board a and black on board b; team 2 the other two colours. Rooms talk
+// to each other only through their Pocket and Terminate channels.
+type BughouseMatch struct {
+	ID          string
+	gameService *GameService
+
+	mu       sync.Mutex
+	Boards   [2]*Room
+	finished bool
+}
+
+func NewBughouseMatch(id string, gameService *GameService) *BughouseMatch {
+	return &BughouseMatch{ID: id, gameService: gameService}
+}
+
+func (bm *BughouseMatch) link(board int, room *Room) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.Boards[board] = room
+	room.match = bm
+}
+
+// unlink detaches room and reports whether the match has no boards left.
+func (bm *BughouseMatch) unlink(room *Room) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for i, board := range bm.Boards {
+		if board == room {
+			bm.Boards[i] = nil
+		}
+	}
+	return bm.Boards[0] == nil && bm.Boards[1] == nil
+}
+
+func (bm *BughouseMatch) other(room *Room) *Room {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if bm.Boards[0] == room {
+		return bm.Boards[1]
+	}
+	return bm.Boards[0]
+}
+
+// passCapture hands a piece captured on room's board to the partner on the
+// other board. The piece keeps its colour, which is the partner's colour.
+func (bm *BughouseMatch) passCapture(room *Room, piece Piece) {
+	if partner := bm.other(room); partner != nil {
+		partner.Pocket <- piece
+	}
+}
+
+// finish ends the match when one board is decided. The winning colour on
+// room's board is mapped to its team and the other board ends with that
+// team's colour there winning.
+func (bm *BughouseMatch) finish(room *Room, winner string) {
+	bm.mu.Lock()
+	if bm.finished {
+		bm.mu.Unlock()
+		return
+	}
+	bm.finished = true
+	onBoardA := bm.Boards[0] == room
+	bm.mu.Unlock()
+
+	team, partnerWinner := 0, "draw"
+	switch {
+	case winner == "white" && onBoardA, winner == "black" && !onBoardA:
+		team = 1
+	case winner == "black" && onBoardA, winner == "white" && !onBoardA:
+		team = 2
+	}
+	if winner != "draw" {
+		partnerWinner = "white"
+		if winner == "white" {
+			partnerWinner = "black"
+		}
+	}
+
+	if err := bm.gameService.FinishBughouseMatch(bm.ID, team); err != nil {
+		log.Println("Failed to finish bughouse match:", err)
+	}
+	if partner := bm.other(room); partner != nil {
+		partner.Terminate <- GameResult{GameID: partner.ID, Winner: partnerWinner, Reason: "The partner board has finished"}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// challengeTTL is how long a challenge waits for an answer.
+const challengeTTL = 24 * time.Hour
+
+var errNotClubMember = errors.New("not allowed to accept this club challenge")
+
+type ChallengeService struct {
+	db            *sql.DB
+	gameService   *GameService
+	notifications *NotificationService
+	blocks        *BlockService
+	clubs         *ClubService
+}
+
+func NewChallengeService(db *sql.DB, gameService *GameService, notifications *NotificationService, blocks *BlockService, clubs *ClubService) *ChallengeService {
+	return &ChallengeService{
+		db:            db,
+		gameService:   gameService,
+		notifications: notifications,
+		blocks:        blocks,
+		clubs:         clubs,
+	}
+}
+
+// CreateChallengeRequest challenges TargetID, or with ClubID and no target
+// posts an open challenge any member of the club may accept.
+type CreateChallengeRequest struct {
+	TargetID       int    `json:"target_id"`
+	ClubID         *int   `json:"club_id"`
+	Variant        string `json:"variant"`
+	Color          string `json:"color"`
+	Rated          bool   `json:"rated"`
+	ClockLimit     int    `json:"clock_limit"`
+	ClockIncrement int    `json:"clock_increment"`
+	DaysPerMove    int    `json:"days_per_move"`
+}
+
+const challengeColumns = `
+	id, challenger_id, COALESCE(target_id, 0), club_id, variant, color, rated, clock_limit, clock_increment,
+	days_per_move, status, game_id, expires_at, created_at, updated_at
+`
+
+func scanChallenge(row rowScanner) (*Challenge, error) {
+	c := &Challenge{}
+	err := row.Scan(&c.ID, &c.ChallengerID, &c.TargetID, &c.ClubID, &c.Variant, &c.Color, &c.Rated,
+		&c.ClockLimit, &c.ClockIncrement, &c.DaysPerMove, &c.Status, &c.GameID,
+		&c.ExpiresAt, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (cs *ChallengeService) CreateChallenge(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var req CreateChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Variant == "" {
+		req.Variant = VariantStandard
+	}
+	if req.Color == "" {
+		req.Color = "random"
+	}
+
+	switch {
+	case req.TargetID == user.ID:
+		http.Error(w, "You cannot challenge yourself", http.StatusBadRequest)
+		return
+	case req.Color != "white" && req.Color != "black" && req.Color != "random":
+		http.Error(w, "Color must be white, black or random", http.StatusBadRequest)
+		return
+	case req.ClockLimit < 0 || req.ClockIncrement < 0:
+		http.Error(w, "Invalid time control", http.StatusBadRequest)
+		return
+	case req.DaysPerMove < 0 || req.DaysPerMove > MaxDaysPerMove:
+		http.Error(w, "Invalid days per move", http.StatusBadRequest)
+		return
+	case req.Variant == VariantBughouse:
+		http.Error(w, "Bughouse cannot be played as a challenge", http.StatusBadRequest)
+		return
+	}
+	if _, err := LookupVariant(req.Variant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.ClubID != nil && !cs.clubs.IsMember(*req.ClubID, user.ID) {
+		http.Error(w, "Not a member of this club", http.StatusForbidden)
+		return
+	}
+	if req.TargetID == 0 && req.ClubID == nil {
+		http.Error(w, "A target or a club is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.TargetID != 0 {
+		var exists bool
+		if err := cs.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, req.TargetID).Scan(&exists); err != nil || !exists {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if cs.blocks.IsBlocked(user.ID, req.TargetID) {
+			http.Error(w, "You cannot challenge this user", http.StatusForbidden)
+			return
+		}
+		if req.ClubID != nil && !cs.clubs.IsMember(*req.ClubID, req.TargetID) {
+			http.Error(w, "This user is not a member of the club", http.StatusBadRequest)
+			return
+		}
+	}
+
+	challenge, err := scanChallenge(cs.db.QueryRow(`
+        INSERT INTO challenges (challenger_id, target_id, club_id, variant, color, rated, clock_limit, clock_increment, days_per_move, expires_at)
+        VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING `+challengeColumns,
+		user.ID, req.TargetID, req.ClubID, req.Variant, req.Color, req.Rated, req.ClockLimit, req.ClockIncrement,
+		req.DaysPerMove, time.Now().Add(challengeTTL)))
+	if err != nil {
+		log.Println("Error creating challenge:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if req.TargetID != 0 {
+		cs.notifications.Notify(req.TargetID, NotifyChallenge, map[string]interface{}{
+			"challenge": challenge,
+			"from":      user,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// ListChallenges returns the user's pending incoming and outgoing challenges,
+// and the open challenges posted in their clubs by others.
+func (cs *ChallengeService) ListChallenges(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	rows, err := cs.db.Query(`
+        SELECT `+challengeColumns+`
+        FROM challenges
+        WHERE (challenger_id = $1 OR target_id = $1
+               OR (target_id IS NULL AND club_id IN (SELECT club_id FROM club_members WHERE user_id = $1)))
+          AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP
+        ORDER BY created_at DESC
+    `, user.ID)
+	if err != nil {
+		log.Println("Error fetching challenges:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	result := map[string][]Challenge{"incoming": {}, "outgoing": {}, "club": {}}
+	for rows.Next() {
+		c, err := scanChallenge(rows)
+		if err != nil {
+			log.Println("Error scanning challenge:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case c.TargetID == user.ID:
+			result["incoming"] = append(result["incoming"], *c)
+		case c.ChallengerID != user.ID:
+			result["club"] = append(result["club"], *c)
+		default:
+			result["outgoing"] = append(result["outgoing"], *c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// AcceptChallenge creates the game and tells both players its ID.
+func (cs *ChallengeService) AcceptChallenge(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid challenge ID", http.StatusBadRequest)
+		return
+	}
+
+	challenge, claimed, err := cs.accept(id, user.ID)
+	if err == errNotClubMember {
+		http.Error(w, "You cannot accept this challenge", http.StatusForbidden)
+		return
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Challenge not found or no longer pending", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error accepting challenge:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	game, err := cs.createGame(challenge)
+	if err != nil {
+		log.Println("Error creating challenge game:", err)
+		if err := cs.reopen(challenge.ID, claimed); err != nil {
+			log.Println("Error reopening challenge:", err)
+		}
+		http.Error(w, "Failed to create game", http.StatusInternalServerError)
+		return
+	}
+	challenge.GameID = &game.ID
+
+	for _, userID := range []int{challenge.ChallengerID, challenge.TargetID} {
+		cs.notifications.Notify(userID, NotifyChallengeAccepted, map[string]interface{}{
+			"challenge": challenge,
+			"game":      game,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(game)
+}
+
+func (cs *ChallengeService) DeclineChallenge(w http.ResponseWriter, r *http.Request) {
+	cs.closeChallenge(w, r, "target_id", "declined")
+}
+
+func (cs *ChallengeService) CancelChallenge(w http.ResponseWriter, r *http.Request) {
+	cs.closeChallenge(w, r, "challenger_id", "cancelled")
+}
+
+// closeChallenge moves a pending challenge to status on behalf of the user in
+// column and notifies the other side.
+func (cs *ChallengeService) closeChallenge(w http.ResponseWriter, r *http.Request, column, status string) {
+	user := currentUser(r)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid challenge ID", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := cs.updateStatus(id, column, user.ID, status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Challenge not found or no longer pending", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error updating challenge:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	other := challenge.ChallengerID
+	if other == user.ID {
+		other = challenge.TargetID
+	}
+	if other != 0 {
+		cs.notifications.Notify(other, "challenge-"+status, map[string]interface{}{
+			"challenge": challenge,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// updateStatus moves a pending, unexpired challenge owned through column by
+// userID to status. column is a trusted identifier, never user input.
+func (cs *ChallengeService) updateStatus(id int, column string, userID int, status string) (*Challenge, error) {
+	return scanChallenge(cs.db.QueryRow(`
+        UPDATE challenges
+        SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND `+column+` = $3 AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP
+        RETURNING `+challengeColumns,
+		status, id, userID))
+}
+
+// accept accepts a pending challenge for userID. An open club challenge is
+// claimed by the first member other than the challenger to accept it, which
+// claimed reports.
+func (cs *ChallengeService) accept(id, userID int) (c *Challenge, claimed bool, err error) {
+	c, err = scanChallenge(cs.db.QueryRow(`SELECT `+challengeColumns+` FROM challenges WHERE id = $1`, id))
+	if err != nil {
+		return nil, false, err
+	}
+	if c.TargetID != 0 || c.ClubID == nil {
+		c, err = cs.updateStatus(id, "target_id", userID, "accepted")
+		return c, false, err
+	}
+	if c.ChallengerID == userID || !cs.clubs.IsMember(*c.ClubID, userID) || cs.blocks.IsBlocked(c.ChallengerID, userID) {
+		return nil, false, errNotClubMember
+	}
+	c, err = scanChallenge(cs.db.QueryRow(`
+        UPDATE challenges
+        SET status = 'accepted', target_id = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND target_id IS NULL AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP
+        RETURNING `+challengeColumns,
+		userID, id))
+	return c, true, err
+}
+
+// reopen puts an accepted challenge whose game could not be created back to
+// pending, releasing a claimed open club challenge for any member.
+func (cs *ChallengeService) reopen(id int, claimed bool) error {
+	_, err := cs.db.Exec(`
+        UPDATE challenges
+        SET status = 'pending', game_id = NULL, updated_at = CURRENT_TIMESTAMP,
+            target_id = CASE WHEN $2 THEN NULL ELSE target_id END
+        WHERE id = $1 AND status = 'accepted'
+    `, id, claimed)
+	return err
+}
+
+func (cs *ChallengeService) createGame(c *Challenge) (*Game, error) {
+	fen, err := startFEN(c.Variant, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	white, black := c.ChallengerID, c.TargetID
+	if c.Color == "black" || (c.Color == "random" && rand.Intn(2) == 0) {
+		white, black = black, white
+	}
+
+	game, err := cs.gameService.CreateGame(white, newGameID(), GameOptions{
+		Variant:        c.Variant,
+		StartFEN:       fen,
+		DaysPerMove:    c.DaysPerMove,
+		ClockLimit:     c.ClockLimit,
+		ClockIncrement: c.ClockIncrement,
+		Rated:          c.Rated,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.gameService.JoinGame(game.ID, black); err != nil {
+		cs.discardGame(game.ID)
+		return nil, err
+	}
+	if _, err := cs.db.Exec(`UPDATE challenges SET game_id = $1 WHERE id = $2`, game.ID, c.ID); err != nil {
+		cs.discardGame(game.ID)
+		return nil, err
+	}
+
+	return cs.gameService.GetGame(game.ID)
+}
+
+// discardGame deletes a game created for a challenge that could not be
+// started, so it is not left waiting for a player.
+func (cs *ChallengeService) discardGame(gameID string) {
+	if err := cs.gameService.DeleteGame(gameID); err != nil {
+		log.Println("Error deleting challenge game:", err)
+	}
+}
+
+// RunExpiry expires unanswered challenges every interval.
+func (cs *ChallengeService) RunExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := cs.expireChallenges(); err != nil {
+			log.Println("Challenge expiry:", err)
+		}
+	}
+}
+
+func (cs *ChallengeService) expireChallenges() error {
+	rows, err := cs.db.Query(`
+        UPDATE challenges
+        SET status = 'expired', updated_at = CURRENT_TIMESTAMP
+        WHERE status = 'pending' AND expires_at <= CURRENT_TIMESTAMP
+        RETURNING ` + challengeColumns)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var expired []*Challenge
+	for rows.Next() {
+		c, err := scanChallenge(rows)
+		if err != nil {
+			return err
+		}
+		expired = append(expired, c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range expired {
+		for _, userID := range []int{c.ChallengerID, c.TargetID} {
+			if userID == 0 {
+				continue
+			}
+			cs.notifications.Notify(userID, NotifyChallengeExpired, map[string]interface{}{
+				"challenge": c,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxChatLength = 500
+	// chatHistoryLimit is how many messages are replayed on joining a room.
+	chatHistoryLimit = 100
+)
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|gg|tv|ru|xyz|me|ly)\b`)
+
+// ChatConfig sets the chat filters. Words are matched case-insensitively
+// as whole words and masked; links reject the whole message.
+type ChatConfig struct {
+	BannedWords []string
+	BlockLinks  bool
+	RateLimit   int
+	RateWindow  time.Duration
+}
+
+// chatConfigFromEnv reads CHAT_BANNED_WORDS (comma separated),
+// CHAT_BLOCK_LINKS and CHAT_RATE_LIMIT (messages per 10 seconds).
+func chatConfigFromEnv() ChatConfig {
+	config := ChatConfig{
+		BlockLinks: os.Getenv("CHAT_BLOCK_LINKS") != "false",
+		RateLimit:  5,
+		RateWindow: 10 * time.Second,
+	}
+	for _, word := range strings.Split(os.Getenv("CHAT_BANNED_WORDS"), ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			config.BannedWords = append(config.BannedWords, word)
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("CHAT_RATE_LIMIT")); err == nil && n > 0 {
+		config.RateLimit = n
+	}
+	return config
+}
+
+// ChatService stores game chat and applies the filters. It is shared by
+// every room, so the rate limit holds across games.
+type ChatService struct {
+	db     *sql.DB
+	config ChatConfig
+	words  *regexp.Regexp
+
+	mu    sync.Mutex
+	sent  map[int][]time.Time
+	swept time.Time
+}
+
+func NewChatService(db *sql.DB, config ChatConfig) *ChatService {
+	cs := &ChatService{
+		db:     db,
+		config: config,
+		sent:   make(map[int][]time.Time),
+	}
+	if len(config.BannedWords) > 0 {
+		quoted := make([]string, len(config.BannedWords))
+		for i, word := range config.BannedWords {
+			quoted[i] = regexp.QuoteMeta(word)
+		}
+		cs.words = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return cs
+}
+
+// Post filters text from user and stores it for gameID. Errors are meant
+// for the sender.
+func (cs *ChatService) Post(gameID string, user *User, text string) (*ChatMessage, error) {
+	return cs.post(&ChatMessage{GameID: gameID}, user, text)
+}
+
+// PostClub is Post for a club's chat room.
+func (cs *ChatService) PostClub(clubID int, user *User, text string) (*ChatMessage, error) {
+	return cs.post(&ChatMessage{ClubID: clubID}, user, text)
+}
+
+// post stores a message for the game or club set in msg.
+func (cs *ChatService) post(msg *ChatMessage, user *User, text string) (*ChatMessage, error) {
+	text, err := cs.Filter(user, text)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.UserID, msg.Name, msg.Message = user.ID, user.Name, text
+	err = cs.db.QueryRow(`
+        INSERT INTO chat_messages (game_id, club_id, user_id, message)
+        VALUES (NULLIF($1, ''), NULLIF($2, 0), $3, $4)
+        RETURNING id, created_at
+    `, msg.GameID, msg.ClubID, user.ID, text).Scan(&msg.ID, &msg.CreatedAt)
+	if err != nil {
+		log.Println("Failed to store chat message:", err)
+		return nil, fmt.Errorf("failed to send message")
+	}
+	return msg, nil
+}
+
+// Filter checks a message from user against the mute, length, link and rate
+// limits and masks banned words. Direct messages go through it as well.
+func (cs *ChatService) Filter(user *User, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case user.IsMuted():
+		return "", fmt.Errorf("you are muted")
+	case text == "":
+		return "", fmt.Errorf("message is empty")
+	case len(text) > maxChatLength:
+		return "", fmt.Errorf("message is too long")
+	case cs.config.BlockLinks && linkPattern.MatchString(text):
+		return "", fmt.Errorf("links are not allowed in chat")
+	case !cs.allow(user.ID):
+		return "", fmt.Errorf("you are sending messages too quickly")
+	}
+	if cs.words != nil {
+		text = cs.words.ReplaceAllStringFunc(text, func(word string) string {
+			return strings.Repeat("*", len(word))
+		})
+	}
+	return text, nil
+}
+
+// allow records a message from userID and reports whether it is within the
+// rate limit. Once per window it forgets users with no message inside it.
+func (cs *ChatService) allow(userID int) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cs.swept) >= cs.config.RateWindow {
+		for id, times := range cs.sent {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) >= cs.config.RateWindow {
+				delete(cs.sent, id)
+			}
+		}
+		cs.swept = now
+	}
+
+	recent := cs.sent[userID][:0]
+	for _, t := range cs.sent[userID] {
+		if now.Sub(t) < cs.config.RateWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= cs.config.RateLimit {
+		cs.sent[userID] = recent
+		return false
+	}
+	cs.sent[userID] = append(recent, now)
+	return true
+}
+
+// History returns the latest messages of gameID, oldest first.
+func (cs *ChatService) History(gameID string) ([]ChatMessage, error) {
+	return cs.history("c.game_id", gameID)
+}
+
+// ClubHistory returns the latest messages of a club's chat room.
+func (cs *ChatService) ClubHistory(clubID int) ([]ChatMessage, error) {
+	return cs.history("c.club_id", clubID)
+}
+
+// history loads the messages where column equals key. column is a trusted
+// identifier, never user input.
+func (cs *ChatService) history(column string, key interface{}) ([]ChatMessage, error) {
+	rows, err := cs.db.Query(`
+        SELECT id, game_id, club_id, user_id, name, message, created_at FROM (
+            SELECT c.id, COALESCE(c.game_id, '') AS game_id, COALESCE(c.club_id, 0) AS club_id,
+                   c.user_id, u.name, c.message, c.created_at
+            FROM chat_messages c
+            JOIN users u ON u.id = c.user_id
+            WHERE `+column+` = $1
+            ORDER BY c.id DESC
+            LIMIT $2
+        ) latest
+        ORDER BY id
+    `, key, chatHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var m ChatMessage
+		if err := rows.Scan(&m.ID, &m.GameID, &m.ClubID, &m.UserID, &m.Name, &m.Message, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
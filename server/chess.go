@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const StartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+type Color int8
+
+const (
+	White Color = iota
+	Black
+)
+
+func (c Color) Other() Color {
+	return c ^ 1
+}
+
+func (c Color) String() string {
+	if c == White {
+		return "white"
+	}
+	return "black"
+}
+
+type PieceType int8
+
+const (
+	NoPieceType PieceType = iota
+	Pawn
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+var pieceValues = [...]int{0, 1, 3, 3, 5, 9, 0}
+
+// Value is the conventional material value of the piece type (king = 0).
+func (t PieceType) Value() int {
+	return pieceValues[t]
+}
+
+// Piece packs a PieceType in the low three bits and the Color above them.
+type Piece int8
+
+const NoPiece Piece = 0
+
+const pieceChars = " pnbrqk"
+
+func NewPiece(t PieceType, c Color) Piece {
+	return Piece(int8(t) | int8(c)<<3)
+}
+
+func (p Piece) Type() PieceType {
+	return PieceType(p & 7)
+}
+
+func (p Piece) Color() Color {
+	return Color(p >> 3)
+}
+
+func (p Piece) String() string {
+	if p == NoPiece {
+		return ""
+	}
+	ch := pieceChars[p.Type()]
+	if p.Color() == White {
+		ch -= 'a' - 'A'
+	}
+	return string(ch)
+}
+
+func pieceFromChar(ch byte) (Piece, bool) {
+	color := White
+	if ch >= 'a' && ch <= 'z' {
+		color = Black
+	} else {
+		ch += 'a' - 'A'
+	}
+	idx := strings.IndexByte(pieceChars, ch)
+	if idx <= 0 {
+		return NoPiece, false
+	}
+	return NewPiece(PieceType(idx), color), true
+}
+
+// Square indexes the board from a1 = 0 to h8 = 63.
+type Square int8
+
+const NoSquare Square = -1
+
+func SquareAt(file, rank int) Square {
+	return Square(rank*8 + file)
+}
+
+func (s Square) File() int {
+	return int(s) & 7
+}
+
+func (s Square) Rank() int {
+	return int(s) >> 3
+}
+
+func (s Square) String() string {
+	if s == NoSquare {
+		return "-"
+	}
+	return string([]byte{byte('a' + s.File()), byte('1' + s.Rank())})
+}
+
+// offset returns the square df files and dr ranks away, if it is on the board.
+func (s Square) offset(df, dr int) (Square, bool) {
+	f, r := s.File()+df, s.Rank()+dr
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return NoSquare, false
+	}
+	return SquareAt(f, r), true
+}
+
+func ParseSquare(s string) (Square, error) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return NoSquare, fmt.Errorf("invalid square %q", s)
+	}
+	return SquareAt(int(s[0]-'a'), int(s[1]-'1')), nil
+}
+
+const (
+	Kingside = iota
+	Queenside
+)
+
+type Position struct {
+	Board [64]Piece
+	Turn  Color
+	// CastlingRooks holds the starting square of each rook that may still
+	// castle, indexed by colour and then Kingside/Queenside.
+	CastlingRooks [2][2]Square
+	EnPassant     Square
+	HalfMove      int
+	FullMove      int
+	// Chess960 switches castling moves to king-takes-rook UCI notation.
+	Chess960 bool
+	// Checks counts the checks given by each side, for variants that score
+	// them.
+	Checks [2]int
+	// Pockets counts captured pieces available to drop, indexed by colour
+	// and PieceType. Promoted marks squares holding promoted pieces, which
+	// return to the pocket as pawns.
+	Pockets  [2][7]int
+	Promoted uint64
+	Variant  Variant
+}
+
+// ParseFEN reads a standard chess position.
+func ParseFEN(fen string) (*Position, error) {
+	return ParseVariantFEN(standardRules{}, fen)
+}
+
+func ParseVariantFEN(v Variant, fen string) (*Position, error) {
+	pos := &Position{EnPassant: NoSquare, FullMove: 1, Variant: v}
+	pos.CastlingRooks = [2][2]Square{{NoSquare, NoSquare}, {NoSquare, NoSquare}}
+
+	fields, err := v.ReadFEN(pos, strings.Fields(fen))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FEN %q: %v", fen, err)
+	}
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid FEN %q: expected at least 4 fields", fen)
+	}
+
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, fmt.Errorf("invalid FEN %q: expected 8 ranks", fen)
+	}
+	for i, row := range ranks {
+		rank, file := 7-i, 0
+		for j := 0; j < len(row); j++ {
+			ch := row[j]
+			if ch >= '1' && ch <= '8' {
+				file += int(ch - '0')
+				continue
+			}
+			piece, ok := pieceFromChar(ch)
+			if !ok || file > 7 {
+				return nil, fmt.Errorf("invalid FEN %q: bad rank %q", fen, row)
+			}
+			pos.Board[SquareAt(file, rank)] = piece
+			file++
+		}
+		if file != 8 {
+			return nil, fmt.Errorf("invalid FEN %q: bad rank %q", fen, row)
+		}
+	}
+
+	switch fields[1] {
+	case "w":
+		pos.Turn = White
+	case "b":
+		pos.Turn = Black
+	default:
+		return nil, fmt.Errorf("invalid FEN %q: bad side to move", fen)
+	}
+
+	if fields[2] != "-" {
+		for _, ch := range fields[2] {
+			if err := pos.addCastlingRight(ch); err != nil {
+				return nil, fmt.Errorf("invalid FEN %q: %v", fen, err)
+			}
+		}
+	}
+
+	if fields[3] != "-" {
+		sq, err := ParseSquare(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid FEN %q: %v", fen, err)
+		}
+		pos.EnPassant = sq
+	}
+
+	if len(fields) > 4 {
+		n, err := strconv.Atoi(fields[4])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid FEN %q: bad halfmove clock", fen)
+		}
+		pos.HalfMove = n
+	}
+	if len(fields) > 5 {
+		n, err := strconv.Atoi(fields[5])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid FEN %q: bad fullmove number", fen)
+		}
+		pos.FullMove = n
+	}
+
+	if err := v.CheckSetup(pos); err != nil {
+		return nil, fmt.Errorf("invalid FEN %q: %v", fen, err)
+	}
+	return pos, nil
+}
+
+// addCastlingRight understands standard KQkq letters, which pick the
+// outermost rook on that side, as well as X-FEN/Shredder-FEN rook files.
+func (p *Position) addCastlingRight(ch rune) error {
+	color := White
+	if ch >= 'a' && ch <= 'z' {
+		color = Black
+		ch -= 'a' - 'A'
+	}
+	rank := backRank(color)
+	king := p.kingSquare(color)
+	if king.Rank() != rank {
+		return fmt.Errorf("castling right %q without king on back rank", ch)
+	}
+
+	switch {
+	case ch == 'K':
+		if rook := p.outermostRook(color, Kingside); rook != NoSquare {
+			p.CastlingRooks[color][Kingside] = rook
+			return nil
+		}
+	case ch == 'Q':
+		if rook := p.outermostRook(color, Queenside); rook != NoSquare {
+			p.CastlingRooks[color][Queenside] = rook
+			return nil
+		}
+	case ch >= 'A' && ch <= 'H':
+		rook := SquareAt(int(ch-'A'), rank)
+		if p.Board[rook] == NewPiece(Rook, color) && rook != king {
+			side := Kingside
+			if rook.File() < king.File() {
+				side = Queenside
+			}
+			p.CastlingRooks[color][side] = rook
+			return nil
+		}
+	}
+	return fmt.Errorf("bad castling right %q", ch)
+}
+
+func backRank(c Color) int {
+	if c == White {
+		return 0
+	}
+	return 7
+}
+
+// outermostRook finds the rook of colour c furthest from the king on the
+// given side of its back rank.
+func (p *Position) outermostRook(c Color, side int) Square {
+	king := p.kingSquare(c)
+	rank := backRank(c)
+	rook := NewPiece(Rook, c)
+	if side == Kingside {
+		for f := 7; f > king.File(); f-- {
+			if p.Board[SquareAt(f, rank)] == rook {
+				return SquareAt(f, rank)
+			}
+		}
+	} else {
+		for f := 0; f < king.File(); f++ {
+			if p.Board[SquareAt(f, rank)] == rook {
+				return SquareAt(f, rank)
+			}
+		}
+	}
+	return NoSquare
+}
+
+// FEN renders the position as X-FEN, which is plain FEN for standard chess.
+func (p *Position) FEN() string {
+	return p.fen(false)
+}
+
+// ShredderFEN renders castling rights as rook files, e.g. "HAha".
+func (p *Position) ShredderFEN() string {
+	return p.fen(true)
+}
+
+func (p *Position) fen(shredder bool) string {
+	var sb strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			piece := p.Board[SquareAt(file, rank)]
+			if piece == NoPiece {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			sb.WriteString(piece.String())
+		}
+		if empty > 0 {
+			sb.WriteByte(byte('0' + empty))
+		}
+		if rank > 0 {
+			sb.WriteByte('/')
+		}
+	}
+
+	turn := "w"
+	if p.Turn == Black {
+		turn = "b"
+	}
+
+	castling := ""
+	for _, c := range []Color{White, Black} {
+		for side, rook := range p.CastlingRooks[c] {
+			if rook == NoSquare {
+				continue
+			}
+			ch := byte('A' + rook.File())
+			if !shredder && p.outermostRook(c, side) == rook {
+				ch = "KQ"[side]
+			}
+			if c == Black {
+				ch += 'a' - 'A'
+			}
+			castling += string(ch)
+		}
+	}
+	if castling == "" {
+		castling = "-"
+	}
+
+	fields := []string{
+		sb.String(), turn, castling, p.EnPassant.String(),
+		strconv.Itoa(p.HalfMove), strconv.Itoa(p.FullMove),
+	}
+	return strings.Join(p.rules().WriteFEN(p, fields), " ")
+}
+
+// rules returns the position's variant, defaulting to standard chess.
+func (p *Position) rules() Variant {
+	if p.Variant == nil {
+		return standardRules{}
+	}
+	return p.Variant
+}
+
+// Outcome reports whether the game is over under the position's variant.
+func (p *Position) Outcome() (over bool, winner string) {
+	return p.rules().Outcome(p)
+}
+
+func (p *Position) kingSquare(c Color) Square {
+	king := NewPiece(King, c)
+	for sq := Square(0); sq < 64; sq++ {
+		if p.Board[sq] == king {
+			return sq
+		}
+	}
+	return NoSquare
+}
+
+// Material sums the piece values of one side.
+func (p *Position) Material(c Color) int {
+	total := 0
+	for _, piece := range p.Board {
+		if piece != NoPiece && piece.Color() == c {
+			total += piece.Type().Value()
+		}
+	}
+	return total
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Chess960StandardIndex is the Scharnagl number of the classical setup.
+const Chess960StandardIndex = 518
+
+// knightPlacements maps the knight digit of a Scharnagl number to the two
+// knight slots among the five squares left after the bishops and queen.
+var knightPlacements = [10][2]int{
+	{0, 1}, {0, 2}, {0, 3}, {0, 4}, {1, 2}, {1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4},
+}
+
+// Chess960FEN returns the start position with the given Scharnagl index
+// (0-959). A negative index picks one at random.
+func Chess960FEN(index int) (string, error) {
+	if index < 0 {
+		index = rand.Intn(960)
+	}
+	if index >= 960 {
+		return "", fmt.Errorf("chess960 index %d out of range", index)
+	}
+
+	var rank [8]byte
+	n := index
+	rank[2*(n%4)+1] = 'b'
+	n /= 4
+	rank[2*(n%4)] = 'b'
+	n /= 4
+
+	place := func(piece byte, slot int) {
+		for f := range rank {
+			if rank[f] != 0 {
+				continue
+			}
+			if slot == 0 {
+				rank[f] = piece
+				return
+			}
+			slot--
+		}
+	}
+
+	place('q', n%6)
+	n /= 6
+	knights := knightPlacements[n]
+	// Place the second knight first so the first slot index stays valid.
+	place('n', knights[1])
+	place('n', knights[0])
+	place('r', 0)
+	place('k', 0)
+	place('r', 0)
+
+	black := string(rank[:])
+	white := strings.ToUpper(black)
+	return fmt.Sprintf("%s/pppppppp/8/8/8/8/PPPPPPPP/%s w KQkq - 0 1", black, white), nil
+}
+
+// chess960 shuffles the back rank. Castling follows the generalised rules
+// in castlingPathClear, and moves use king-takes-rook UCI notation.
+type chess960 struct {
+	standardRules
+}
+
+func (chess960) Name() string {
+	return VariantChess960
+}
+
+func (chess960) PGNName() string {
+	return "Chess960"
+}
+
+func (chess960) StartFEN(index int) (string, error) {
+	return Chess960FEN(index)
+}
+
+func (chess960) ReadFEN(pos *Position, fields []string) ([]string, error) {
+	pos.Chess960 = true
+	return fields, nil
+}
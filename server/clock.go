@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// gameClock is the server-side clock of a live game. It is owned by the
+// room goroutine and saved after every press, with the time the running
+// side's turn started. Nobody's time runs until white's first move, but
+// tournament games are forfeited if it does not come within
+// firstMoveTimeout.
+type gameClock struct {
+	limit     time.Duration
+	remaining map[string]time.Duration
+	increment map[string]time.Duration
+	// running is the colour whose time is running, "" when stopped.
+	running string
+	since   time.Time
+}
+
+// Open rooms flag their own games; the clock scheduler leaves a game alone
+// until flagGrace after its time ran out.
+const (
+	firstMoveTimeout = time.Minute
+	flagGrace        = 5 * time.Second
+)
+
+// ClockState is the clock as sent to clients, in milliseconds.
+type ClockState struct {
+	White   int64  `json:"white"`
+	Black   int64  `json:"black"`
+	Running string `json:"running,omitempty"`
+}
+
+// newGameClock returns the clock of game, or nil for untimed and
+// correspondence games. Saved times and berserks are restored, and if the
+// game is under way the side to move is charged for the whole turn so far,
+// including any time the room was closed.
+func newGameClock(game *Game, pos *Position) *gameClock {
+	if game.ClockLimit <= 0 || game.DaysPerMove > 0 {
+		return nil
+	}
+
+	limit := time.Duration(game.ClockLimit) * time.Second
+	increment := time.Duration(game.ClockIncrement) * time.Second
+	c := &gameClock{
+		limit:     limit,
+		remaining: map[string]time.Duration{"white": limit, "black": limit},
+		increment: map[string]time.Duration{"white": increment, "black": increment},
+	}
+	if game.WhiteTimeMs != nil {
+		c.remaining["white"] = time.Duration(*game.WhiteTimeMs) * time.Millisecond
+	}
+	if game.BlackTimeMs != nil {
+		c.remaining["black"] = time.Duration(*game.BlackTimeMs) * time.Millisecond
+	}
+	if game.WhiteBerserk {
+		c.increment["white"] = 0
+	}
+	if game.BlackBerserk {
+		c.increment["black"] = 0
+	}
+	switch {
+	case game.Status != "active":
+	case game.ClockRunning != "" && game.TurnStartedAt != nil:
+		c.running = game.ClockRunning
+		c.since = *game.TurnStartedAt
+	case pos.FullMove > 1 || pos.Turn == Black:
+		// Games saved before the turn start was kept resume from now.
+		c.running = pos.Turn.String()
+		c.since = time.Now()
+	}
+	return c
+}
+
+// left returns color's remaining time at now.
+func (c *gameClock) left(color string, now time.Time) time.Duration {
+	left := c.remaining[color]
+	if c.running == color {
+		left -= now.Sub(c.since)
+	}
+	return left
+}
+
+// press ends color's move: their elapsed time is charged, the increment
+// added and the opponent's time starts.
+func (c *gameClock) press(color string, now time.Time) {
+	if c.running == color {
+		c.remaining[color] = c.left(color, now) + c.increment[color]
+	}
+	c.running = otherColor(color)
+	c.since = now
+}
+
+func (c *gameClock) stop(now time.Time) {
+	if c.running != "" {
+		c.remaining[c.running] = c.left(c.running, now)
+		c.running = ""
+	}
+}
+
+// berserk halves color's time and removes their increment.
+func (c *gameClock) berserk(color string) {
+	if half := c.limit / 2; c.remaining[color] > half {
+		c.remaining[color] = half
+	}
+	c.increment[color] = 0
+}
+
+func (c *gameClock) state(now time.Time) ClockState {
+	return ClockState{
+		White:   c.left("white", now).Milliseconds(),
+		Black:   c.left("black", now).Milliseconds(),
+		Running: c.running,
+	}
+}
+
+// armFlag schedules a flag check for when the running side's time is up,
+// replacing any earlier one.
+func (r *Room) armFlag() {
+	if r.flagTimer != nil {
+		r.flagTimer.Stop()
+	}
+	if r.clock == nil || r.clock.running == "" {
+		return
+	}
+	r.flagTimer = time.AfterFunc(r.clock.left(r.clock.running, time.Now()), func() {
+		select {
+		case r.flagCheck <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func (r *Room) checkFlag() {
+	if r.clock == nil || r.clock.running == "" || r.result != "" {
+		return
+	}
+	if r.clock.left(r.clock.running, time.Now()) > 0 {
+		r.armFlag()
+		return
+	}
+	r.timeOut(r.clock.running)
+}
+
+// timeOut ends the game as lost by loser, whose time ran out.
+func (r *Room) timeOut(loser string) {
+	r.clock.stop(time.Now())
+	r.clock.remaining[loser] = 0
+	r.premoves = make(map[string]premove)
+
+	winner := otherColor(loser)
+	r.endGame(winner, "Time out")
+	if r.match != nil {
+		r.match.finish(r, winner)
+	}
+}
+
+func (r *Room) saveClock() {
+	c := r.clock
+	if err := r.gameService.SaveClock(r.ID, c.remaining["white"].Milliseconds(), c.remaining["black"].Milliseconds(), c.running, c.since); err != nil {
+		log.Println("Failed to save clock:", err)
+	}
+}
+
+// berserk halves the sender's clock. It is only allowed in berserkable games
+// before the player's first move.
+func (r *Room) berserk(sender *Client) error {
+	if !r.berserkable || r.clock == nil || sender.Color == "" {
+		return fmt.Errorf("berserk is not available in this game")
+	}
+	if r.result != "" {
+		return fmt.Errorf("game is over")
+	}
+	moved := r.position.FullMove > 1 || (sender.Color == "white" && r.position.Turn == Black)
+	if moved {
+		return fmt.Errorf("berserk is only allowed before your first move")
+	}
+	if err := r.gameService.SetBerserk(r.ID, sender.Color); err != nil {
+		if err == errAlreadyBerserk {
+			return err
+		}
+		log.Println("Failed to save berserk:", err)
+		return fmt.Errorf("failed to berserk")
+	}
+
+	r.clock.berserk(sender.Color)
+	r.saveClock()
+	r.armFlag()
+
+	event, _ := json.Marshal(map[string]string{"type": "berserk", "color": sender.Color})
+	for client := range r.Clients {
+		client.Send <- event
+	}
+	r.broadcastPosition()
+	return nil
+}
+
+func otherColor(color string) string {
+	if color == "white" {
+		return "black"
+	}
+	return "white"
+}
+
+// RunClocks ends, every interval, live games whose time ran out while no
+// room was open to flag them, and tournament games whose first move did not
+// come in time. A room that is open is told through Results.
+func (h *Hub) RunClocks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		results, err := h.gameService.expireClocks()
+		if err != nil {
+			log.Println("Clock scheduler:", err)
+		}
+		for _, result := range results {
+			h.Results <- result
+		}
+	}
+}
+
+// expireClocks completes the games RunClocks ends. The side to move loses.
+// Bughouse boards are left to their rooms, which end the whole match.
+func (gs *GameService) expireClocks() ([]GameResult, error) {
+	rows, err := gs.db.Query(`
+        UPDATE games
+        SET status = 'completed', updated_at = CURRENT_TIMESTAMP,
+            winner = CASE split_part(current_fen, ' ', 2) WHEN 'w' THEN 'black' ELSE 'white' END
+        WHERE status = 'active' AND black_player_id IS NOT NULL AND COALESCE(days_per_move, 0) = 0
+          AND COALESCE(variant, 'standard') <> $1
+          AND ((clock_running = 'white' AND turn_started_at + (white_time_ms + $2::bigint) * INTERVAL '1 millisecond' < CURRENT_TIMESTAMP)
+            OR (clock_running = 'black' AND turn_started_at + (black_time_ms + $2::bigint) * INTERVAL '1 millisecond' < CURRENT_TIMESTAMP)
+            OR (tournament_id IS NOT NULL AND clock_running IS NULL
+                AND turn_started_at + $3::bigint * INTERVAL '1 millisecond' < CURRENT_TIMESTAMP
+                AND NOT EXISTS (SELECT 1 FROM game_moves m WHERE m.game_id = games.id)))
+        RETURNING id, winner, clock_running IS NULL
+    `, VariantBughouse, flagGrace.Milliseconds(), firstMoveTimeout.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []GameResult
+	for rows.Next() {
+		var result GameResult
+		var noShow bool
+		if err := rows.Scan(&result.GameID, &result.Winner, &noShow); err != nil {
+			return nil, err
+		}
+		result.Reason = "Time out"
+		if noShow {
+			result.Reason = "The first move was not played in time"
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		gs.completed(result.GameID)
+	}
+	return results, nil
+}
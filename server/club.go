@@ -0,0 +1,642 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Club roles, from most to least privileged.
+const (
+	ClubRoleOwner  = "owner"
+	ClubRoleAdmin  = "admin"
+	ClubRoleMember = "member"
+)
+
+// clubRoomPrefix starts the hub room ID of every club chat room.
+const clubRoomPrefix = "club:"
+
+func clubRoomID(clubID int) string {
+	return clubRoomPrefix + strconv.Itoa(clubID)
+}
+
+// ClubService manages clubs and their members. New members join through a
+// request that an owner or admin accepts. Each club has a chat room run by
+// the hub like a game room.
+type ClubService struct {
+	db            *sql.DB
+	notifications *NotificationService
+	hub           *Hub
+}
+
+func NewClubService(db *sql.DB, notifications *NotificationService, hub *Hub) *ClubService {
+	return &ClubService{
+		db:            db,
+		notifications: notifications,
+		hub:           hub,
+	}
+}
+
+type CreateClubRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ClubJoinRequestBody struct {
+	Message string `json:"message"`
+}
+
+type SetClubRoleRequest struct {
+	Role string `json:"role"`
+}
+
+const clubColumns = `
+	c.id, c.name, COALESCE(c.description, ''), c.owner_id,
+	(SELECT COUNT(*) FROM club_members m WHERE m.club_id = c.id),
+	COALESCE((SELECT role FROM club_members m WHERE m.club_id = c.id AND m.user_id = $1), ''),
+	c.created_at
+`
+
+func scanClub(row rowScanner) (*Club, error) {
+	c := &Club{}
+	if err := row.Scan(&c.ID, &c.Name, &c.Description, &c.OwnerID, &c.Members, &c.Role, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Role returns userID's role in clubID, or "" if they are not a member.
+func (cs *ClubService) Role(clubID, userID int) string {
+	var role string
+	err := cs.db.QueryRow(`SELECT role FROM club_members WHERE club_id = $1 AND user_id = $2`, clubID, userID).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error fetching club role:", err)
+	}
+	return role
+}
+
+func (cs *ClubService) IsMember(clubID, userID int) bool {
+	return cs.Role(clubID, userID) != ""
+}
+
+// IsAdmin reports whether userID is an owner or admin of clubID.
+func (cs *ClubService) IsAdmin(clubID, userID int) bool {
+	role := cs.Role(clubID, userID)
+	return role == ClubRoleOwner || role == ClubRoleAdmin
+}
+
+// clubFromPath loads the club in the {id} path variable as seen by the
+// current user, writing the error response if it cannot.
+func (cs *ClubService) clubFromPath(w http.ResponseWriter, r *http.Request) (*Club, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid club ID", http.StatusBadRequest)
+		return nil, false
+	}
+	club, err := scanClub(cs.db.QueryRow(`SELECT `+clubColumns+` FROM clubs c WHERE c.id = $2`, currentUser(r).ID, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Club not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Error fetching club:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return club, true
+}
+
+// admins returns the owner and admins of clubID.
+func (cs *ClubService) admins(clubID int) ([]int, error) {
+	return queryIDs(cs.db, `
+        SELECT user_id FROM club_members WHERE club_id = $1 AND role IN ($2, $3)
+    `, clubID, ClubRoleOwner, ClubRoleAdmin)
+}
+
+func (cs *ClubService) CreateClub(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var req CreateClubRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := cs.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+        INSERT INTO clubs (name, description, owner_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, req.Name, req.Description, user.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "A club with this name already exists", http.StatusConflict)
+		return
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO club_members (club_id, user_id, role) VALUES ($1, $2, $3)`, id, user.ID, ClubRoleOwner)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("Error creating club:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	club, err := scanClub(cs.db.QueryRow(`SELECT `+clubColumns+` FROM clubs c WHERE c.id = $2`, user.ID, id))
+	if err != nil {
+		log.Println("Error fetching club:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(club)
+}
+
+// ListClubs lists clubs by size, optionally filtered by name with ?q= or to
+// the user's own with ?mine=true.
+func (cs *ClubService) ListClubs(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	mine := r.URL.Query().Get("mine") == "true"
+
+	rows, err := cs.db.Query(`
+        SELECT `+clubColumns+`
+        FROM clubs c
+        WHERE ($2 = '' OR c.name ILIKE '%' || $2 || '%')
+          AND (NOT $3 OR EXISTS(SELECT 1 FROM club_members m WHERE m.club_id = c.id AND m.user_id = $1))
+        ORDER BY 5 DESC, c.name
+        LIMIT 50
+    `, user.ID, query, mine)
+	if err != nil {
+		log.Println("Error fetching clubs:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	clubs := []Club{}
+	for rows.Next() {
+		c, err := scanClub(rows)
+		if err != nil {
+			log.Println("Error scanning club:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		clubs = append(clubs, *c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clubs)
+}
+
+func (cs *ClubService) GetClub(w http.ResponseWriter, r *http.Request) {
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(club)
+}
+
+func (cs *ClubService) GetMembers(w http.ResponseWriter, r *http.Request) {
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cs.db.Query(`
+        SELECT u.id, u.name, u.avatar_url, m.role, m.joined_at
+        FROM club_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.club_id = $1
+        ORDER BY CASE m.role WHEN $2 THEN 0 WHEN $3 THEN 1 ELSE 2 END, u.name
+    `, club.ID, ClubRoleOwner, ClubRoleAdmin)
+	if err != nil {
+		log.Println("Error fetching club members:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []ClubMember{}
+	for rows.Next() {
+		var m ClubMember
+		if err := rows.Scan(&m.User.ID, &m.User.Name, &m.User.AvatarURL, &m.Role, &m.JoinedAt); err != nil {
+			log.Println("Error scanning club member:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// RequestToJoin asks to join a club and tells its owner and admins.
+func (cs *ClubService) RequestToJoin(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	if club.Role != "" {
+		http.Error(w, "Already a member", http.StatusConflict)
+		return
+	}
+
+	var req ClubJoinRequestBody
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.Message) > maxChatLength {
+		http.Error(w, "Message is too long", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := cs.db.Exec(`
+        INSERT INTO club_join_requests (club_id, user_id, message)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (club_id, user_id) DO UPDATE
+        SET message = EXCLUDED.message, status = 'pending', updated_at = CURRENT_TIMESTAMP
+    `, club.ID, user.ID, req.Message); err != nil {
+		log.Println("Error creating join request:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	admins, err := cs.admins(club.ID)
+	if err != nil {
+		log.Println("Error fetching club admins:", err)
+	}
+	for _, adminID := range admins {
+		cs.notifications.Notify(adminID, NotifyClubJoinRequest, map[string]interface{}{
+			"club":    club,
+			"from":    user,
+			"message": req.Message,
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GetJoinRequests lists the pending requests of a club for its admins.
+func (cs *ClubService) GetJoinRequests(w http.ResponseWriter, r *http.Request) {
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	if club.Role != ClubRoleOwner && club.Role != ClubRoleAdmin {
+		http.Error(w, "Only club admins can see join requests", http.StatusForbidden)
+		return
+	}
+
+	rows, err := cs.db.Query(`
+        SELECT u.id, u.name, u.avatar_url, jr.message, jr.status, jr.created_at
+        FROM club_join_requests jr
+        JOIN users u ON u.id = jr.user_id
+        WHERE jr.club_id = $1 AND jr.status = 'pending'
+        ORDER BY jr.created_at
+    `, club.ID)
+	if err != nil {
+		log.Println("Error fetching join requests:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	requests := []ClubJoinRequest{}
+	for rows.Next() {
+		var jr ClubJoinRequest
+		if err := rows.Scan(&jr.User.ID, &jr.User.Name, &jr.User.AvatarURL, &jr.Message, &jr.Status, &jr.CreatedAt); err != nil {
+			log.Println("Error scanning join request:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		requests = append(requests, jr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+func (cs *ClubService) AcceptJoinRequest(w http.ResponseWriter, r *http.Request) {
+	cs.answerJoinRequest(w, r, "accepted")
+}
+
+func (cs *ClubService) DeclineJoinRequest(w http.ResponseWriter, r *http.Request) {
+	cs.answerJoinRequest(w, r, "declined")
+}
+
+// answerJoinRequest closes the pending request of the {user} in the path
+// with status, adding them as a member when accepted.
+func (cs *ClubService) answerJoinRequest(w http.ResponseWriter, r *http.Request, status string) {
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	if club.Role != ClubRoleOwner && club.Role != ClubRoleAdmin {
+		http.Error(w, "Only club admins can answer join requests", http.StatusForbidden)
+		return
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := cs.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        UPDATE club_join_requests SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE club_id = $2 AND user_id = $3 AND status = 'pending'
+    `, status, club.ID, userID)
+	if err != nil {
+		log.Println("Error answering join request:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Join request not found", http.StatusNotFound)
+		return
+	}
+	if status == "accepted" {
+		if _, err := tx.Exec(`
+            INSERT INTO club_members (club_id, user_id, role) VALUES ($1, $2, $3)
+            ON CONFLICT (club_id, user_id) DO NOTHING
+        `, club.ID, userID, ClubRoleMember); err != nil {
+			log.Println("Error adding club member:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Error answering join request:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	notification := NotifyClubJoinAccepted
+	if status != "accepted" {
+		notification = NotifyClubJoinDeclined
+	}
+	cs.notifications.Notify(userID, notification, map[string]interface{}{"club": club})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveClub removes the current user from a club. The owner has to hand the
+// club to someone else first.
+func (cs *ClubService) LeaveClub(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	switch club.Role {
+	case "":
+		http.Error(w, "Not a member", http.StatusNotFound)
+		return
+	case ClubRoleOwner:
+		http.Error(w, "Transfer ownership before leaving the club", http.StatusConflict)
+		return
+	}
+
+	if err := cs.removeMember(club.ID, user.ID); err != nil {
+		log.Println("Error leaving club:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMember lets an admin remove a member. Only the owner may remove
+// another admin, and the owner cannot be removed.
+func (cs *ClubService) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	target := cs.Role(club.ID, userID)
+	switch {
+	case club.Role != ClubRoleOwner && club.Role != ClubRoleAdmin:
+		http.Error(w, "Only club admins can remove members", http.StatusForbidden)
+		return
+	case target == "":
+		http.Error(w, "Not a member", http.StatusNotFound)
+		return
+	case target == ClubRoleOwner || (target == ClubRoleAdmin && club.Role != ClubRoleOwner):
+		http.Error(w, "You cannot remove this member", http.StatusForbidden)
+		return
+	}
+
+	if err := cs.removeMember(club.ID, userID); err != nil {
+		log.Println("Error removing club member:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeMember deletes the membership and closes the user's sockets in the
+// club chat room.
+func (cs *ClubService) removeMember(clubID, userID int) error {
+	if _, err := cs.db.Exec(`DELETE FROM club_members WHERE club_id = $1 AND user_id = $2`, clubID, userID); err != nil {
+		return err
+	}
+	cs.hub.Sanctions <- Sanction{UserID: userID, ClubID: clubID}
+	return nil
+}
+
+// SetMemberRole lets the owner promote or demote members. Giving someone
+// the owner role hands the club over and makes the old owner an admin.
+func (cs *ClubService) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetClubRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case req.Role != ClubRoleOwner && req.Role != ClubRoleAdmin && req.Role != ClubRoleMember:
+		http.Error(w, "Role must be owner, admin or member", http.StatusBadRequest)
+		return
+	case club.Role != ClubRoleOwner:
+		http.Error(w, "Only the club owner can change roles", http.StatusForbidden)
+		return
+	case userID == user.ID:
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	case cs.Role(club.ID, userID) == "":
+		http.Error(w, "Not a member", http.StatusNotFound)
+		return
+	}
+
+	tx, err := cs.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE club_members SET role = $1 WHERE club_id = $2 AND user_id = $3`, req.Role, club.ID, userID)
+	if err == nil && req.Role == ClubRoleOwner {
+		_, err = tx.Exec(`UPDATE club_members SET role = $1 WHERE club_id = $2 AND user_id = $3`, ClubRoleAdmin, club.ID, user.ID)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE clubs SET owner_id = $1 WHERE id = $2`, userID, club.ID)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("Error setting club role:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetLeaderboard ranks a club's members by their best rating, optionally
+// narrowed with ?speed= and ?variant=. Members without a rated game come last.
+func (cs *ClubService) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	club, ok := cs.clubFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	speed, variant := r.URL.Query().Get("speed"), r.URL.Query().Get("variant")
+	if speed != "" && !slices.Contains(speeds, speed) {
+		http.Error(w, "Unknown speed", http.StatusBadRequest)
+		return
+	}
+	if _, err := LookupVariant(variant); variant != "" && err != nil {
+		http.Error(w, "Unknown variant", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := cs.db.Query(`
+        SELECT u.id, u.name, u.avatar_url, ur.rating, COALESCE(ur.games, 0),
+               COALESCE(ur.speed, ''), COALESCE(ur.variant, '')
+        FROM club_members m
+        JOIN users u ON u.id = m.user_id
+        LEFT JOIN LATERAL (
+            SELECT rating, games, speed, variant FROM user_ratings
+            WHERE user_id = u.id AND games > 0
+              AND ($2 = '' OR speed = $2) AND ($3 = '' OR variant = $3)
+            ORDER BY rating DESC, games DESC
+            LIMIT 1
+        ) ur ON TRUE
+        WHERE m.club_id = $1
+        ORDER BY ur.rating IS NULL, ur.rating DESC, ur.games DESC, u.id
+    `, club.ID, speed, variant)
+	if err != nil {
+		log.Println("Error fetching club leaderboard:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []ClubLeaderboardEntry{}
+	for rows.Next() {
+		var e ClubLeaderboardEntry
+		if err := rows.Scan(&e.User.ID, &e.User.Name, &e.User.AvatarURL, &e.Rating, &e.Games, &e.Speed, &e.Variant); err != nil {
+			log.Println("Error scanning club leaderboard:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		e.Rank = len(entries) + 1
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ServeClubWs connects a member to their club's chat room:
+// /ws/club?club=<id>. Messages use the same "chat" format as game rooms.
+func ServeClubWs(hub *Hub, clubs *ClubService, w http.ResponseWriter, r *http.Request, authService *AuthService) {
+	user := authService.getUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	clubID, err := strconv.Atoi(r.URL.Query().Get("club"))
+	if err != nil {
+		http.Error(w, "Invalid club ID", http.StatusBadRequest)
+		return
+	}
+	if !clubs.IsMember(clubID, user.ID) {
+		http.Error(w, "Not a member of this club", http.StatusForbidden)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+
+	client := &Client{
+		Conn:   conn,
+		RoomID: clubRoomID(clubID),
+		ClubID: clubID,
+		User:   user,
+		Send:   make(chan []byte, 256),
+	}
+	hub.Register <- client
+
+	go client.writePump()
+	go client.readPump(hub)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// validateConditionals checks every move of a conditional tree, starting with
+// the opponent to move in pos, and rewrites them in canonical UCI.
+func validateConditionals(pos *Position, tree []ConditionalMove) error {
+	seen := map[string]bool{}
+	for i := range tree {
+		node := &tree[i]
+		move, err := pos.ParseUCI(node.Move)
+		if err != nil {
+			return err
+		}
+		node.Move = pos.MoveUCI(move)
+		if seen[node.Move] {
+			return fmt.Errorf("duplicate condition %s", node.Move)
+		}
+		seen[node.Move] = true
+
+		after := pos.Play(move)
+		if over, _ := after.Outcome(); over {
+			if node.Reply != "" || len(node.Then) > 0 {
+				return fmt.Errorf("game is over after %s", node.Move)
+			}
+			continue
+		}
+
+		reply, err := after.ParseUCI(node.Reply)
+		if err != nil {
+			return fmt.Errorf("reply to %s: %v", node.Move, err)
+		}
+		node.Reply = after.MoveUCI(reply)
+
+		next := after.Play(reply)
+		if over, _ := next.Outcome(); over && len(node.Then) > 0 {
+			return fmt.Errorf("game is over after %s", node.Reply)
+		}
+		if err := validateConditionals(next, node.Then); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveDataFromUCI splits a UCI move or drop ("N@e4") into MoveData.
+func moveDataFromUCI(uci string) (data MoveData, drop bool) {
+	if len(uci) == 4 && uci[1] == '@' {
+		return MoveData{Piece: uci[:1], To: uci[2:]}, true
+	}
+	if len(uci) < 4 {
+		return MoveData{}, false
+	}
+	return MoveData{From: uci[:2], To: uci[2:4], Promotion: uci[4:]}, false
+}
+
+// TakeConditionalMove consumes the conditional tree of the player with color
+// in a game after their opponent played played. When the tree expects that
+// move it returns the queued reply and keeps the subtree below it; otherwise
+// the tree is discarded.
+func (gs *GameService) TakeConditionalMove(gameID string, color string, played string) (reply string, playerID int, ok bool) {
+	var raw []byte
+	err := gs.db.QueryRow(`
+        SELECT cm.player_id, cm.tree
+        FROM conditional_moves cm
+        JOIN games g ON g.id = cm.game_id
+        WHERE cm.game_id = $1
+        AND cm.player_id = CASE WHEN $2 = 'white' THEN g.white_player_id ELSE g.black_player_id END
+    `, gameID, color).Scan(&playerID, &raw)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to load conditional moves:", err)
+		}
+		return "", 0, false
+	}
+
+	var tree []ConditionalMove
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		log.Println("Invalid conditional moves:", err)
+	}
+
+	var rest []ConditionalMove
+	for _, node := range tree {
+		if node.Move == played && node.Reply != "" {
+			reply, rest, ok = node.Reply, node.Then, true
+			break
+		}
+	}
+
+	if len(rest) == 0 {
+		err = gs.DeleteConditionalMoves(gameID, playerID)
+	} else {
+		err = gs.SaveConditionalMoves(gameID, playerID, rest)
+	}
+	if err != nil {
+		log.Println("Failed to update conditional moves:", err)
+	}
+
+	return reply, playerID, ok
+}
+
+func (gs *GameService) SaveConditionalMoves(gameID string, playerID int, tree []ConditionalMove) error {
+	raw, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	_, err = gs.db.Exec(`
+        INSERT INTO conditional_moves (game_id, player_id, tree)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (game_id, player_id)
+        DO UPDATE SET tree = EXCLUDED.tree, updated_at = CURRENT_TIMESTAMP
+    `, gameID, playerID, raw)
+
+	return err
+}
+
+func (gs *GameService) GetConditionalMoves(gameID string, playerID int) ([]ConditionalMove, error) {
+	var raw []byte
+	err := gs.db.QueryRow(`
+        SELECT tree FROM conditional_moves WHERE game_id = $1 AND player_id = $2
+    `, gameID, playerID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return []ConditionalMove{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tree := []ConditionalMove{}
+	err = json.Unmarshal(raw, &tree)
+	return tree, err
+}
+
+func (gs *GameService) DeleteConditionalMoves(gameID string, playerID int) error {
+	_, err := gs.db.Exec(`DELETE FROM conditional_moves WHERE game_id = $1 AND player_id = $2`, gameID, playerID)
+	return err
+}
+
+// SetConditionalMoves replaces the user's conditional tree for a
+// correspondence game. It can only be set while the opponent is to move.
+func (cs *CorrespondenceService) SetConditionalMoves(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	gameID := mux.Vars(r)["id"]
+
+	var tree []ConditionalMove
+	if err := json.NewDecoder(r.Body).Decode(&tree); err != nil {
+		http.Error(w, "Invalid conditional moves", http.StatusBadRequest)
+		return
+	}
+
+	game, err := cs.gameService.GetGame(gameID)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	if game.DaysPerMove == 0 || game.Status != "active" {
+		http.Error(w, "Conditional moves need an active correspondence game", http.StatusBadRequest)
+		return
+	}
+
+	pos, err := NewGamePosition(game.Variant, game.CurrentFEN)
+	if err != nil {
+		http.Error(w, "Invalid game position", http.StatusInternalServerError)
+		return
+	}
+	color := playerColor(game, user.ID)
+	if color == "" {
+		http.Error(w, "You are not playing this game", http.StatusForbidden)
+		return
+	}
+	if color == pos.Turn.String() {
+		http.Error(w, "Conditional moves can only be set on your opponent's turn", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateConditionals(pos, tree); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(tree) == 0 {
+		err = cs.gameService.DeleteConditionalMoves(gameID, user.ID)
+	} else {
+		err = cs.gameService.SaveConditionalMoves(gameID, user.ID, tree)
+	}
+	if err != nil {
+		log.Println("Error saving conditional moves:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (cs *CorrespondenceService) GetConditionalMoves(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	tree, err := cs.gameService.GetConditionalMoves(mux.Vars(r)["id"], user.ID)
+	if err != nil {
+		log.Println("Error fetching conditional moves:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (cs *CorrespondenceService) DeleteConditionalMoves(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	if err := cs.gameService.DeleteConditionalMoves(mux.Vars(r)["id"], user.ID); err != nil {
+		log.Println("Error deleting conditional moves:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
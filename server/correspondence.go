@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxDaysPerMove bounds the time control of correspondence games.
+const MaxDaysPerMove = 14
+
+var errNoRoom = errors.New("no open room for game")
+
+// CorrespondenceService serves daily games: moves submitted over REST and the
+// scheduler that ends games whose move deadline has passed.
+type CorrespondenceService struct {
+	db          *sql.DB
+	gameService *GameService
+	hub         *Hub
+}
+
+func NewCorrespondenceService(db *sql.DB, gameService *GameService, hub *Hub) *CorrespondenceService {
+	return &CorrespondenceService{
+		db:          db,
+		gameService: gameService,
+		hub:         hub,
+	}
+}
+
+// SubmitMove plays a move for the authenticated user. The body is a MoveData;
+// a body with a piece and no from square is a drop.
+func (cs *CorrespondenceService) SubmitMove(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	gameID := mux.Vars(r)["id"]
+
+	var data MoveData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.To == "" {
+		http.Error(w, "Invalid move", http.StatusBadRequest)
+		return
+	}
+
+	game, err := cs.gameService.GetGame(gameID)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	color := playerColor(game, user.ID)
+	if color == "" {
+		http.Error(w, "You are not playing this game", http.StatusForbidden)
+		return
+	}
+
+	move := RemoteMove{
+		GameID: gameID,
+		User:   user,
+		Color:  color,
+		Data:   data,
+		Drop:   data.From == "" && data.Piece != "",
+		Result: make(chan error, 1),
+	}
+
+	// A live room owns the position, so let it apply the move.
+	cs.hub.Moves <- move
+	err = <-move.Result
+	if err == errNoRoom {
+		err = cs.playOffline(game, move)
+	}
+	if err == errStalePosition {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	game, err = cs.gameService.GetGame(gameID)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(game)
+}
+
+// playerColor is the colour userID plays in game, or "" for spectators.
+func playerColor(game *Game, userID int) string {
+	switch {
+	case game.WhitePlayerID != nil && *game.WhitePlayerID == userID:
+		return "white"
+	case game.BlackPlayerID != nil && *game.BlackPlayerID == userID:
+		return "black"
+	}
+	return ""
+}
+
+// playOffline applies a move straight to the database when no room is open,
+// followed by any conditional replies it triggers. Each move is stored only
+// if the game is still at the position it was played from. Live games keep
+// clocks and bughouse pockets in their room, so only correspondence games
+// can be played this way.
+func (cs *CorrespondenceService) playOffline(game *Game, rm RemoteMove) error {
+	if game.Status != "active" {
+		return errors.New("game is not active")
+	}
+	if game.DaysPerMove <= 0 {
+		return errors.New("live games are played through their room")
+	}
+	pos, err := NewGamePosition(game.Variant, game.CurrentFEN)
+	if err != nil {
+		return err
+	}
+	if over, _ := pos.Outcome(); over {
+		return errors.New("game is over")
+	}
+	if rm.Color != pos.Turn.String() {
+		return errors.New("not your turn")
+	}
+
+	fen := game.CurrentFEN
+	for first := true; ; first = false {
+		move, next, err := cs.gameService.PlayMoveFrom(game.ID, fen, pos, rm.User.ID, rm.Data, rm.Drop)
+		if err != nil {
+			if first {
+				return err
+			}
+			log.Println("Failed to play conditional move:", err)
+			return nil
+		}
+
+		meta, _ := json.Marshal(rm.Data)
+		status, winner := gameOutcome(next)
+		if err := cs.gameService.UpdateGame(game.ID, status, winner, meta); err != nil {
+			return err
+		}
+		if status != "active" {
+			return nil
+		}
+
+		reply, playerID, ok := cs.gameService.TakeConditionalMove(game.ID, next.Turn.String(), pos.MoveUCI(move))
+		if !ok {
+			return nil
+		}
+		data, drop := moveDataFromUCI(reply)
+		rm = RemoteMove{GameID: game.ID, User: &User{ID: playerID}, Color: next.Turn.String(), Data: data, Drop: drop}
+		pos, fen = next, next.FEN()
+	}
+}
+
+// ListTurnGames returns the active correspondence games waiting for userID to
+// move, most urgent first.
+func (cs *CorrespondenceService) ListTurnGames(userID int) ([]Game, error) {
+	rows, err := cs.db.Query(gameSelect+`
+		WHERE g.status = 'active' AND g.days_per_move > 0
+		AND ((split_part(g.current_fen, ' ', 2) = 'w' AND g.white_player_id = $1)
+			OR (split_part(g.current_fen, ' ', 2) = 'b' AND g.black_player_id = $1))
+		ORDER BY g.move_deadline
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []Game{}
+	for rows.Next() {
+		game, err := scanGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, *game)
+	}
+
+	return games, rows.Err()
+}
+
+func (cs *CorrespondenceService) GetTurnGames(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	games, err := cs.ListTurnGames(user.ID)
+	if err != nil {
+		log.Println("Error fetching games:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(games)
+}
+
+// RunScheduler checks for expired move deadlines every interval.
+func (cs *CorrespondenceService) RunScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := cs.expireGames(); err != nil {
+			log.Println("Correspondence scheduler:", err)
+		}
+	}
+}
+
+// expireGames ends every game whose side to move has run out of time. The
+// opponent wins on time.
+func (cs *CorrespondenceService) expireGames() error {
+	rows, err := cs.db.Query(`
+        UPDATE games
+        SET status = 'completed', updated_at = CURRENT_TIMESTAMP,
+            winner = CASE split_part(current_fen, ' ', 2) WHEN 'w' THEN 'black' ELSE 'white' END
+        WHERE status = 'active' AND days_per_move > 0 AND move_deadline < CURRENT_TIMESTAMP
+        RETURNING id, winner
+    `)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var results []GameResult
+	for rows.Next() {
+		result := GameResult{Reason: "Time ran out"}
+		if err := rows.Scan(&result.GameID, &result.Winner); err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		log.Printf("Correspondence game %s lost on time, %s wins\n", result.GameID, result.Winner)
+		cs.gameService.completed(result.GameID)
+		cs.hub.Results <- result
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// crazyhouse puts captured pieces into the capturer's pocket, from where
+// they can be dropped onto any empty square instead of making a move. The
+// FEN carries the pockets in brackets after the board, e.g. "...RNBQKBNR[Qp]",
+// and marks promoted pieces with a trailing "~".
+type crazyhouse struct {
+	standardRules
+}
+
+var pocketOrder = []PieceType{Queen, Rook, Bishop, Knight, Pawn}
+
+func squareBit(sq Square) uint64 {
+	return uint64(1) << uint(sq)
+}
+
+func (crazyhouse) Name() string {
+	return VariantCrazyhouse
+}
+
+func (crazyhouse) PGNName() string {
+	return "Crazyhouse"
+}
+
+func (crazyhouse) ReadFEN(pos *Position, fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		return fields, nil
+	}
+
+	board, pocket := fields[0], ""
+	if i := strings.IndexByte(board, '['); i >= 0 {
+		if !strings.HasSuffix(board, "]") {
+			return nil, fmt.Errorf("unterminated pocket in %q", board)
+		}
+		board, pocket = board[:i], board[i+1:len(board)-1]
+	} else if strings.Count(board, "/") == 8 {
+		i := strings.LastIndexByte(board, '/')
+		board, pocket = board[:i], board[i+1:]
+	}
+
+	for i := 0; i < len(pocket); i++ {
+		piece, ok := pieceFromChar(pocket[i])
+		if !ok || piece.Type() == King {
+			return nil, fmt.Errorf("invalid pocket piece %q", pocket[i])
+		}
+		pos.Pockets[piece.Color()][piece.Type()]++
+	}
+
+	var cleaned strings.Builder
+	rank, file := 7, 0
+	for i := 0; i < len(board); i++ {
+		ch := board[i]
+		switch {
+		case ch == '~':
+			if file == 0 || rank < 0 {
+				return nil, fmt.Errorf("misplaced promotion marker in %q", board)
+			}
+			pos.Promoted |= squareBit(SquareAt(file-1, rank))
+			continue
+		case ch == '/':
+			rank, file = rank-1, 0
+		case ch >= '1' && ch <= '8':
+			file += int(ch - '0')
+		default:
+			file++
+		}
+		cleaned.WriteByte(ch)
+	}
+
+	return append([]string{cleaned.String()}, fields[1:]...), nil
+}
+
+func (crazyhouse) WriteFEN(pos *Position, fields []string) []string {
+	var board strings.Builder
+	rank, file := 7, 0
+	for i := 0; i < len(fields[0]); i++ {
+		ch := fields[0][i]
+		board.WriteByte(ch)
+		switch {
+		case ch == '/':
+			rank, file = rank-1, 0
+		case ch >= '1' && ch <= '8':
+			file += int(ch - '0')
+		default:
+			if pos.Promoted&squareBit(SquareAt(file, rank)) != 0 {
+				board.WriteByte('~')
+			}
+			file++
+		}
+	}
+
+	board.WriteByte('[')
+	for _, c := range []Color{White, Black} {
+		for _, t := range pocketOrder {
+			board.WriteString(strings.Repeat(NewPiece(t, c).String(), pos.Pockets[c][t]))
+		}
+	}
+	board.WriteByte(']')
+
+	return append([]string{board.String()}, fields[1:]...)
+}
+
+// AddMoves adds a drop of every pocket piece onto every empty square, except
+// pawns on the first and last ranks.
+func (crazyhouse) AddMoves(pos *Position, moves []Move) []Move {
+	us := pos.Turn
+	for _, t := range pocketOrder {
+		if pos.Pockets[us][t] == 0 {
+			continue
+		}
+		for sq := Square(0); sq < 64; sq++ {
+			if pos.Board[sq] != NoPiece || (t == Pawn && (sq.Rank() == 0 || sq.Rank() == 7)) {
+				continue
+			}
+			moves = append(moves, Move{From: NoSquare, To: sq, Piece: NewPiece(t, us), Flags: FlagDrop})
+		}
+	}
+	return moves
+}
+
+func (crazyhouse) AfterMove(before, after *Position, m Move) {
+	if m.IsCapture() {
+		after.Pockets[before.Turn][pocketPiece(before, m)]++
+	}
+	trackPromotions(before, after, m)
+}
+
+// pocketPiece is the piece type a capture puts in a pocket: promoted
+// pieces go back as pawns.
+func pocketPiece(before *Position, m Move) PieceType {
+	capturedSq := m.To
+	if m.Flags&FlagEnPassant != 0 {
+		capturedSq = SquareAt(m.To.File(), m.From.Rank())
+	}
+	if before.Promoted&squareBit(capturedSq) != 0 {
+		return Pawn
+	}
+	return m.Captured.Type()
+}
+
+// trackPromotions moves the promoted marker along with the piece.
+func trackPromotions(before, after *Position, m Move) {
+	if m.Flags&FlagDrop != 0 {
+		return
+	}
+	wasPromoted := before.Promoted&squareBit(m.From) != 0
+	after.Promoted &^= squareBit(m.From) | squareBit(m.To)
+	if wasPromoted || m.Promotion != NoPieceType {
+		after.Promoted |= squareBit(m.To)
+	}
+}
+
+// PocketCounts lists the pieces in each side's pocket by letter, or nil when
+// both pockets are empty.
+func (p *Position) PocketCounts() map[string]map[string]int {
+	var pockets map[string]map[string]int
+	for _, c := range []Color{White, Black} {
+		for _, t := range pocketOrder {
+			if p.Pockets[c][t] == 0 {
+				continue
+			}
+			if pockets == nil {
+				pockets = map[string]map[string]int{"white": {}, "black": {}}
+			}
+			pockets[c.String()][string(pieceChars[t])] = p.Pockets[c][t]
+		}
+	}
+	return pockets
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// buildCrosstable lists every player's games from their side, in standings
+// order.
+func buildCrosstable(standings []TournamentStanding, pairings []TournamentPairing) []CrosstableRow {
+	rank := make(map[int]int, len(standings))
+	rows := make([]CrosstableRow, len(standings))
+	index := make(map[int]int, len(standings))
+	for i, s := range standings {
+		rank[s.UserID] = s.Rank
+		index[s.UserID] = i
+		rows[i] = CrosstableRow{TournamentStanding: s, Results: []CrosstableResult{}}
+	}
+
+	add := func(userID int, result CrosstableResult) {
+		if i, ok := index[userID]; ok {
+			rows[i].Results = append(rows[i].Results, result)
+		}
+	}
+	for _, p := range pairings {
+		if p.BlackID == nil {
+			if p.Result != nil {
+				add(p.WhiteID, CrosstableResult{Round: p.Round, Points: "+"})
+			}
+			continue
+		}
+		white, black := "", ""
+		if p.Result != nil {
+			w, b := resultPoints(*p.Result)
+			white, black = pointsString(w), pointsString(b)
+		}
+		whiteID, blackID := p.WhiteID, *p.BlackID
+		add(whiteID, CrosstableResult{Round: p.Round, OpponentID: &blackID, OpponentRank: rank[blackID], Color: "white", Points: white, GameID: p.GameID})
+		add(blackID, CrosstableResult{Round: p.Round, OpponentID: &whiteID, OpponentRank: rank[whiteID], Color: "black", Points: black, GameID: p.GameID})
+	}
+	return rows
+}
+
+func pointsString(points float64) string {
+	switch points {
+	case 1:
+		return "1"
+	case 0.5:
+		return "½"
+	}
+	return "0"
+}
+
+// GetCrosstable returns the standings with each player's results against
+// every opponent. Points are empty for games still in progress. Pass
+// ?format=csv or ?format=html for a printable table.
+func (ts *TournamentService) GetCrosstable(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	standings, err := ts.standings(t)
+	if err != nil {
+		log.Println("Error computing standings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	pairings, err := ts.pairings(t.ID, 0)
+	if err != nil {
+		log.Println("Error fetching pairings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows := buildCrosstable(standings, pairings)
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		writeCrosstableCSV(w, t, rows)
+	case "html":
+		writeCrosstableHTML(w, t, rows)
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+	default:
+		http.Error(w, "Format must be json, csv or html", http.StatusBadRequest)
+	}
+}
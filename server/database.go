@@ -56,8 +56,319 @@ func createTables(db *sql.DB) error {
             move_number INTEGER NOT NULL,
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
         )`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS variant VARCHAR(20) DEFAULT 'standard'`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS initial_fen VARCHAR(500) DEFAULT 'rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1'`,
+		`ALTER TABLE game_moves ADD COLUMN IF NOT EXISTS is_drop BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS days_per_move INTEGER DEFAULT 0`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS move_deadline TIMESTAMP`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS clock_limit INTEGER DEFAULT 0`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS clock_increment INTEGER DEFAULT 0`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS rated BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS private BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS tournament_id INTEGER`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS white_time_ms BIGINT`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS black_time_ms BIGINT`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS berserkable BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS simul_id INTEGER`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS rated_at TIMESTAMP`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS white_berserk BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS black_berserk BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS clock_running VARCHAR(5)`,
+		`ALTER TABLE games ADD COLUMN IF NOT EXISTS turn_started_at TIMESTAMP`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) DEFAULT 'user'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS banned_until TIMESTAMP`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS ban_permanent BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS ban_reason TEXT`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS puzzles (
+            id SERIAL PRIMARY KEY,
+            fen VARCHAR(100) NOT NULL,
+            moves TEXT NOT NULL, -- space separated UCI, solver moves first
+            rating INTEGER DEFAULT 1500,
+            themes TEXT[] DEFAULT '{}',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS bughouse_matches (
+            id VARCHAR(255) PRIMARY KEY,
+            board_a_game_id VARCHAR(255) NOT NULL,
+            board_b_game_id VARCHAR(255) NOT NULL,
+            status VARCHAR(20) DEFAULT 'active',
+            winning_team INTEGER,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS conditional_moves (
+            game_id VARCHAR(255) REFERENCES games(id) ON DELETE CASCADE,
+            player_id INTEGER REFERENCES users(id),
+            tree JSONB NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (game_id, player_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS challenges (
+            id SERIAL PRIMARY KEY,
+            challenger_id INTEGER REFERENCES users(id),
+            target_id INTEGER REFERENCES users(id),
+            variant VARCHAR(30) DEFAULT 'standard',
+            color VARCHAR(10) DEFAULT 'random', -- the challenger's colour
+            rated BOOLEAN DEFAULT FALSE,
+            clock_limit INTEGER DEFAULT 0,
+            clock_increment INTEGER DEFAULT 0,
+            days_per_move INTEGER DEFAULT 0,
+            status VARCHAR(20) DEFAULT 'pending',
+            game_id VARCHAR(255) REFERENCES games(id) ON DELETE SET NULL,
+            expires_at TIMESTAMP NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS game_invites (
+            id VARCHAR(64) PRIMARY KEY, -- the token's JWT ID
+            game_id VARCHAR(255) REFERENCES games(id) ON DELETE CASCADE,
+            inviter_id INTEGER REFERENCES users(id),
+            invitee_id INTEGER REFERENCES users(id),
+            expires_at TIMESTAMP NOT NULL,
+            used_by INTEGER REFERENCES users(id),
+            used_at TIMESTAMP,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            type VARCHAR(50) NOT NULL,
+            data JSONB DEFAULT '{}',
+            read BOOLEAN DEFAULT FALSE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS friendships (
+            requester_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            addressee_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            status VARCHAR(20) DEFAULT 'pending',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            accepted_at TIMESTAMP,
+            PRIMARY KEY (requester_id, addressee_id),
+            CHECK (requester_id <> addressee_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS user_blocks (
+            blocker_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            blocked_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (blocker_id, blocked_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS reports (
+            id SERIAL PRIMARY KEY,
+            reporter_id INTEGER REFERENCES users(id),
+            reported_id INTEGER REFERENCES users(id),
+            reason TEXT NOT NULL,
+            game_id VARCHAR(255),
+            chat_excerpt TEXT DEFAULT '',
+            status VARCHAR(20) DEFAULT 'open',
+            resolved_by INTEGER REFERENCES users(id),
+            resolved_at TIMESTAMP,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS chat_messages (
+            id SERIAL PRIMARY KEY,
+            game_id VARCHAR(255) NOT NULL REFERENCES games(id) ON DELETE CASCADE,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            message TEXT NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS conversations (
+            id SERIAL PRIMARY KEY,
+            user_a INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            user_b INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (user_a, user_b),
+            CHECK (user_a < user_b)
+        )`,
+		`CREATE TABLE IF NOT EXISTS direct_messages (
+            id SERIAL PRIMARY KEY,
+            conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+            sender_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            body TEXT NOT NULL,
+            read_at TIMESTAMP,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS tournaments (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            format VARCHAR(20) DEFAULT 'swiss',
+            variant VARCHAR(20) DEFAULT 'standard',
+            rounds INTEGER NOT NULL,
+            current_round INTEGER DEFAULT 0,
+            clock_limit INTEGER DEFAULT 0,
+            clock_increment INTEGER DEFAULT 0,
+            status VARCHAR(20) DEFAULT 'registering',
+            created_by INTEGER REFERENCES users(id),
+            starts_at TIMESTAMP,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS tournament_players (
+            tournament_id INTEGER REFERENCES tournaments(id) ON DELETE CASCADE,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            seed SERIAL,
+            withdrawn BOOLEAN DEFAULT FALSE,
+            joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (tournament_id, user_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS tournament_pairings (
+            id SERIAL PRIMARY KEY,
+            tournament_id INTEGER REFERENCES tournaments(id) ON DELETE CASCADE,
+            round INTEGER NOT NULL,
+            board INTEGER NOT NULL,
+            white_id INTEGER REFERENCES users(id),
+            black_id INTEGER REFERENCES users(id),
+            game_id VARCHAR(255) REFERENCES games(id) ON DELETE SET NULL,
+            result VARCHAR(10),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS moderation_log (
+            id SERIAL PRIMARY KEY,
+            moderator_id INTEGER REFERENCES users(id),
+            action VARCHAR(50) NOT NULL,
+            target_user_id INTEGER REFERENCES users(id),
+            target_game_id VARCHAR(255),
+            report_id INTEGER REFERENCES reports(id),
+            details JSONB DEFAULT '{}',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE OR REPLACE FUNCTION moderation_log_append_only() RETURNS trigger AS $$
+        BEGIN
+            RAISE EXCEPTION 'moderation_log is append-only';
+        END;
+        $$ LANGUAGE plpgsql`,
+		`DO $$
+        BEGIN
+            IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'moderation_log_no_changes') THEN
+                CREATE TRIGGER moderation_log_no_changes
+                BEFORE UPDATE OR DELETE ON moderation_log
+                FOR EACH ROW EXECUTE FUNCTION moderation_log_append_only();
+            END IF;
+        END
+        $$`,
+		`CREATE TABLE IF NOT EXISTS clubs (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(100) UNIQUE NOT NULL,
+            description TEXT DEFAULT '',
+            owner_id INTEGER REFERENCES users(id),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS club_members (
+            club_id INTEGER REFERENCES clubs(id) ON DELETE CASCADE,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            role VARCHAR(20) DEFAULT 'member',
+            joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (club_id, user_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS club_join_requests (
+            club_id INTEGER REFERENCES clubs(id) ON DELETE CASCADE,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            message TEXT DEFAULT '',
+            status VARCHAR(20) DEFAULT 'pending',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (club_id, user_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS simuls (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            host_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            host_color VARCHAR(10) DEFAULT 'white',
+            variant VARCHAR(20) DEFAULT 'standard',
+            clock_limit INTEGER DEFAULT 0,
+            clock_increment INTEGER DEFAULT 0,
+            max_players INTEGER DEFAULT 20,
+            status VARCHAR(20) DEFAULT 'open',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS simul_participants (
+            simul_id INTEGER REFERENCES simuls(id) ON DELETE CASCADE,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            game_id VARCHAR(255) REFERENCES games(id) ON DELETE SET NULL,
+            result VARCHAR(10),
+            joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (simul_id, user_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS user_ratings (
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            speed VARCHAR(20) NOT NULL,
+            variant VARCHAR(20) NOT NULL,
+            rating INTEGER DEFAULT 1500,
+            games INTEGER DEFAULT 0,
+            last_played_at TIMESTAMP,
+            PRIMARY KEY (user_id, speed, variant)
+        )`,
+		`CREATE TABLE IF NOT EXISTS rating_history (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            speed VARCHAR(20) NOT NULL,
+            variant VARCHAR(20) NOT NULL,
+            game_id VARCHAR(255) REFERENCES games(id) ON DELETE SET NULL,
+            rating_before INTEGER NOT NULL,
+            rating_after INTEGER NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS user_achievements (
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            achievement_id VARCHAR(50) NOT NULL,
+            unlocked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (user_id, achievement_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS puzzle_attempts (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+            puzzle_id INTEGER REFERENCES puzzles(id) ON DELETE CASCADE,
+            solved BOOLEAN NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`ALTER TABLE tournaments ADD COLUMN IF NOT EXISTS club_id INTEGER REFERENCES clubs(id) ON DELETE CASCADE`,
+		`ALTER TABLE challenges ADD COLUMN IF NOT EXISTS club_id INTEGER REFERENCES clubs(id) ON DELETE CASCADE`,
+		`ALTER TABLE challenges ALTER COLUMN target_id DROP NOT NULL`,
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS club_id INTEGER REFERENCES clubs(id) ON DELETE CASCADE`,
+		`ALTER TABLE chat_messages ALTER COLUMN game_id DROP NOT NULL`,
+		`ALTER TABLE tournaments ADD COLUMN IF NOT EXISTS duration INTEGER DEFAULT 0`,
+		`ALTER TABLE tournaments ADD COLUMN IF NOT EXISTS ends_at TIMESTAMP`,
+		`ALTER TABLE tournament_pairings ADD COLUMN IF NOT EXISTS white_berserk BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE tournament_pairings ADD COLUMN IF NOT EXISTS black_berserk BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE tournaments ADD COLUMN IF NOT EXISTS cycles INTEGER DEFAULT 1`,
+		`ALTER TABLE tournaments ADD COLUMN IF NOT EXISTS match_games INTEGER DEFAULT 1`,
+		`ALTER TABLE tournaments ADD COLUMN IF NOT EXISTS armageddon BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE tournament_pairings ADD COLUMN IF NOT EXISTS game_number INTEGER DEFAULT 1`,
+		`ALTER TABLE tournament_pairings ADD COLUMN IF NOT EXISTS armageddon BOOLEAN DEFAULT FALSE`,
 		`CREATE INDEX IF NOT EXISTS idx_games_players ON games(white_player_id, black_player_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_game_moves_game_id ON game_moves(game_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_games_move_deadline ON games(move_deadline) WHERE days_per_move > 0`,
+		`CREATE INDEX IF NOT EXISTS idx_challenges_target ON challenges(target_id, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, read, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_friendships_addressee ON friendships(addressee_id, status)`,
+		`DELETE FROM friendships f USING friendships o
+            WHERE f.requester_id = o.addressee_id AND f.addressee_id = o.requester_id
+              AND (f.status <> 'accepted', f.created_at, f.requester_id) > (o.status <> 'accepted', o.created_at, o.requester_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_friendships_pair ON friendships(LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id))`,
+		`CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked ON user_blocks(blocked_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_messages_game ON chat_messages(game_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_messages_club ON chat_messages(club_id, id) WHERE club_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_club_members_user ON club_members(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_challenges_club ON challenges(club_id, status) WHERE club_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_conversations_user_b ON conversations(user_b)`,
+		`CREATE INDEX IF NOT EXISTS idx_direct_messages_conversation ON direct_messages(conversation_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_tournament_pairings_round ON tournament_pairings(tournament_id, round)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_tournament_pairings_board ON tournament_pairings(tournament_id, round, board, game_number)`,
+		`CREATE INDEX IF NOT EXISTS idx_tournament_pairings_pending ON tournament_pairings(game_id) WHERE result IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_simul_participants_pending ON simul_participants(game_id) WHERE result IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_games_unrated ON games(updated_at) WHERE rated AND rated_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_user_ratings_board ON user_ratings(speed, variant, rating DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_rating_history_board ON rating_history(speed, variant, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_puzzle_attempts_user ON puzzle_attempts(user_id, id DESC)`,
+		`DELETE FROM puzzle_attempts a USING puzzle_attempts o
+            WHERE a.user_id = o.user_id AND a.puzzle_id = o.puzzle_id AND a.id > o.id`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_puzzle_attempts_once ON puzzle_attempts(user_id, puzzle_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_moderation_log_target ON moderation_log(target_user_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_puzzles_themes ON puzzles USING GIN(themes)`,
 	}
 
 	for _, query := range queries {
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type FriendService struct {
+	db            *sql.DB
+	presence      *PresenceTracker
+	notifications *NotificationService
+	blocks        *BlockService
+}
+
+func NewFriendService(db *sql.DB, presence *PresenceTracker, notifications *NotificationService, blocks *BlockService) *FriendService {
+	return &FriendService{
+		db:            db,
+		presence:      presence,
+		notifications: notifications,
+		blocks:        blocks,
+	}
+}
+
+// friendIDs lists the users with an accepted friendship with userID.
+func friendIDs(db *sql.DB, userID int) ([]int, error) {
+	rows, err := db.Query(`
+        SELECT CASE WHEN requester_id = $1 THEN addressee_id ELSE requester_id END
+        FROM friendships
+        WHERE (requester_id = $1 OR addressee_id = $1) AND status = 'accepted'
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetFriends lists the user's friends with their current presence.
+func (fs *FriendService) GetFriends(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	rows, err := fs.db.Query(`
+        SELECT u.id, u.name, u.email, u.avatar_url
+        FROM friendships f
+        JOIN users u ON u.id = CASE WHEN f.requester_id = $1 THEN f.addressee_id ELSE f.requester_id END
+        WHERE (f.requester_id = $1 OR f.addressee_id = $1) AND f.status = 'accepted'
+        ORDER BY u.name
+    `, user.ID)
+	if err != nil {
+		log.Println("Error fetching friends:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	friends := []Friend{}
+	for rows.Next() {
+		var f Friend
+		if err := rows.Scan(&f.User.ID, &f.User.Name, &f.User.Email, &f.User.AvatarURL); err != nil {
+			log.Println("Error scanning friend:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		f.Presence = fs.presence.Get(f.User.ID)
+		friends = append(friends, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(friends)
+}
+
+// GetPresence returns a friend's presence. Presence is only shared between
+// friends.
+func (fs *FriendService) GetPresence(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	friendID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if friendID != user.ID && !fs.areFriends(user.ID, friendID) {
+		http.Error(w, "Not a friend", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fs.presence.Get(friendID))
+}
+
+// GetFriendRequests lists pending requests sent to and by the user.
+func (fs *FriendService) GetFriendRequests(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	rows, err := fs.db.Query(`
+        SELECT a.id, a.name, a.email, a.avatar_url, b.id, b.name, b.email, b.avatar_url, f.created_at
+        FROM friendships f
+        JOIN users a ON a.id = f.requester_id
+        JOIN users b ON b.id = f.addressee_id
+        WHERE (f.requester_id = $1 OR f.addressee_id = $1) AND f.status = 'pending'
+        ORDER BY f.created_at DESC
+    `, user.ID)
+	if err != nil {
+		log.Println("Error fetching friend requests:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	result := map[string][]FriendRequest{"incoming": {}, "outgoing": {}}
+	for rows.Next() {
+		var fr FriendRequest
+		if err := rows.Scan(&fr.From.ID, &fr.From.Name, &fr.From.Email, &fr.From.AvatarURL,
+			&fr.To.ID, &fr.To.Name, &fr.To.Email, &fr.To.AvatarURL, &fr.CreatedAt); err != nil {
+			log.Println("Error scanning friend request:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if fr.To.ID == user.ID {
+			result["incoming"] = append(result["incoming"], fr)
+		} else {
+			result["outgoing"] = append(result["outgoing"], fr)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// SendFriendRequest asks the user in the path to be friends. If they had
+// already asked the current user, the friendship is accepted instead.
+func (fs *FriendService) SendFriendRequest(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || targetID == user.ID {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	if err := fs.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, targetID).Scan(&exists); err != nil || !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if fs.blocks.IsBlocked(user.ID, targetID) {
+		http.Error(w, "You cannot befriend this user", http.StatusForbidden)
+		return
+	}
+
+	if accepted, err := fs.accept(targetID, user.ID); err != nil {
+		log.Println("Error accepting friend request:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if accepted {
+		fs.notifications.Notify(targetID, NotifyFriendAccepted, map[string]interface{}{"user": user})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+		return
+	}
+
+	result, err := fs.db.Exec(`
+        INSERT INTO friendships (requester_id, addressee_id)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING
+    `, user.ID, targetID)
+	if err != nil {
+		log.Println("Error creating friend request:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// The pair index also rejects a request to someone who is already
+		// a friend.
+		http.Error(w, "Friend request already sent or already friends", http.StatusConflict)
+		return
+	}
+
+	fs.notifications.Notify(targetID, NotifyFriendRequest, map[string]interface{}{"user": user})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"status": "pending"})
+}
+
+// AcceptFriendRequest accepts the request sent by the user in the path.
+func (fs *FriendService) AcceptFriendRequest(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	requesterID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	accepted, err := fs.accept(requesterID, user.ID)
+	if err != nil {
+		log.Println("Error accepting friend request:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !accepted {
+		http.Error(w, "Friend request not found", http.StatusNotFound)
+		return
+	}
+
+	fs.notifications.Notify(requesterID, NotifyFriendAccepted, map[string]interface{}{"user": user})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fs.presence.Get(requesterID))
+}
+
+func (fs *FriendService) accept(requesterID, addresseeID int) (bool, error) {
+	result, err := fs.db.Exec(`
+        UPDATE friendships
+        SET status = 'accepted', accepted_at = CURRENT_TIMESTAMP
+        WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'
+    `, requesterID, addresseeID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+// RemoveFriend removes a friendship, or declines or withdraws a pending
+// request, with the user in the path.
+func (fs *FriendService) RemoveFriend(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	otherID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := fs.db.Exec(`
+        DELETE FROM friendships
+        WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
+    `, user.ID, otherID)
+	if err != nil {
+		log.Println("Error removing friend:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Friendship not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (fs *FriendService) areFriends(a, b int) bool {
+	var ok bool
+	err := fs.db.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM friendships
+            WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
+            AND status = 'accepted'
+        )
+    `, a, b).Scan(&ok)
+	if err != nil {
+		log.Println("Error checking friendship:", err)
+	}
+	return ok
+}
@@ -1,40 +1,99 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type GameService struct {
-	db *sql.DB
+	db            *sql.DB
+	notifications *NotificationService
+	// completeHooks run, each in its own goroutine, when a game completes.
+	completeHooks []func(*Game)
 }
 
-func NewGameService(db *sql.DB) *GameService {
-	return &GameService{db: db}
+// GameOptions describes how a new game starts.
+type GameOptions struct {
+	Variant  string
+	StartFEN string
+	// MatchID and Board place a bughouse game on board 0 (a) or 1 (b) of a
+	// match.
+	MatchID string
+	Board   int
+	// DaysPerMove is non-zero for correspondence games.
+	DaysPerMove int
+	// ClockLimit and ClockIncrement are the time control in seconds.
+	ClockLimit     int
+	ClockIncrement int
+	Rated          bool
+	// Private games seat the second player only through an invite.
+	Private bool
+	// TournamentID is set for tournament pairings, which outlive their room.
+	TournamentID *int
+	// Berserkable games let each player halve their clock for a bonus.
+	Berserkable bool
+	// SimulID is set for the boards of a simul, which outlive their room.
+	SimulID *int
 }
 
-func (gs *GameService) CreateGame(userID int, gameID string) (*Game, error) {
+// newGameID returns a random, unguessable game and room ID.
+func newGameID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func NewGameService(db *sql.DB, notifications *NotificationService) *GameService {
+	return &GameService{db: db, notifications: notifications}
+}
+
+func (gs *GameService) CreateGame(userID int, gameID string, opts GameOptions) (*Game, error) {
 	// gameID := uuid.New()
+	if opts.Variant == "" {
+		opts.Variant = VariantStandard
+	}
+	if opts.StartFEN == "" {
+		opts.StartFEN = StartFEN
+	}
 
 	game := &Game{
-		ID:            gameID,
-		WhitePlayerID: &userID,
-		// CurrentFEN:    "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
-		Status:    "waiting",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:             gameID,
+		WhitePlayerID:  &userID,
+		Variant:        opts.Variant,
+		InitialFEN:     opts.StartFEN,
+		CurrentFEN:     opts.StartFEN,
+		DaysPerMove:    opts.DaysPerMove,
+		ClockLimit:     opts.ClockLimit,
+		ClockIncrement: opts.ClockIncrement,
+		Rated:          opts.Rated,
+		Private:        opts.Private,
+		TournamentID:   opts.TournamentID,
+		Berserkable:    opts.Berserkable,
+		SimulID:        opts.SimulID,
+		Status:         "waiting",
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
 	}
 
 	_, err := gs.db.Exec(`
-        INSERT INTO games (id, white_player_id, status, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5)
-    `, game.ID, game.WhitePlayerID, game.Status, game.CreatedAt, game.UpdatedAt)
+        INSERT INTO games (id, white_player_id, variant, initial_fen, current_fen, days_per_move,
+            clock_limit, clock_increment, rated, private, tournament_id, berserkable, simul_id, status, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+    `, game.ID, game.WhitePlayerID, game.Variant, game.InitialFEN, game.CurrentFEN, game.DaysPerMove,
+		game.ClockLimit, game.ClockIncrement, game.Rated, game.Private, game.TournamentID, game.Berserkable,
+		game.SimulID, game.Status, game.CreatedAt, game.UpdatedAt)
 
 	return game, err
 }
@@ -42,7 +101,9 @@ func (gs *GameService) CreateGame(userID int, gameID string) (*Game, error) {
 func (gs *GameService) JoinGame(gameID string, userID int) error {
 	result, err := gs.db.Exec(`
         UPDATE games 
-        SET black_player_id = $1, status = 'active', updated_at = CURRENT_TIMESTAMP
+        SET black_player_id = $1, status = 'active', updated_at = CURRENT_TIMESTAMP, turn_started_at = CURRENT_TIMESTAMP,
+            move_deadline = CASE WHEN days_per_move > 0
+                THEN CURRENT_TIMESTAMP + days_per_move * INTERVAL '1 day' END
         WHERE id = $2 AND black_player_id IS NULL AND white_player_id != $1
     `, userID, gameID)
 	rowsAffected, _ := result.RowsAffected()
@@ -51,32 +112,59 @@ func (gs *GameService) JoinGame(gameID string, userID int) error {
 	return err
 }
 
-func (gs *GameService) GetGame(gameID string) (*Game, error) {
-	game := &Game{}
-	whitePlayer := &User{}
-	blackPlayer := &User{}
-
-	fmt.Println("gameID", gameID)
+// joinGameTx is JoinGame within tx. It reports whether the black seat was
+// still free.
+func joinGameTx(tx *sql.Tx, gameID string, userID int) (bool, error) {
+	result, err := tx.Exec(`
+        UPDATE games
+        SET black_player_id = $1, status = 'active', updated_at = CURRENT_TIMESTAMP, turn_started_at = CURRENT_TIMESTAMP,
+            move_deadline = CASE WHEN days_per_move > 0
+                THEN CURRENT_TIMESTAMP + days_per_move * INTERVAL '1 day' END
+        WHERE id = $2 AND black_player_id IS NULL AND white_player_id != $1
+    `, userID, gameID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
 
-	err := gs.db.QueryRow(`
-		SELECT 
-			g.id, g.white_player_id, g.black_player_id, g.metadata, 
-			g.status, g.winner, g.created_at, g.updated_at,
+// gameSelect is the query prefix whose columns scanGame expects.
+const gameSelect = `
+		SELECT
+			g.id, g.white_player_id, g.black_player_id, g.metadata,
+			COALESCE(g.variant, 'standard'), COALESCE(g.initial_fen, ''), COALESCE(g.current_fen, ''),
+			COALESCE(g.days_per_move, 0), g.move_deadline,
+			COALESCE(g.clock_limit, 0), COALESCE(g.clock_increment, 0), COALESCE(g.rated, FALSE), COALESCE(g.private, FALSE), g.tournament_id, g.status, g.winner, g.created_at, g.updated_at,
+			g.white_time_ms, g.black_time_ms, COALESCE(g.berserkable, FALSE), COALESCE(g.white_berserk, FALSE), COALESCE(g.black_berserk, FALSE), g.simul_id,
+			COALESCE(g.clock_running, ''), g.turn_started_at,
 			w.id, w.name, w.email, w.avatar_url,
 			COALESCE(b.id, 0), COALESCE(b.name, ''), COALESCE(b.email, ''), COALESCE(b.avatar_url, '')
 		FROM games g
 		LEFT JOIN users w ON g.white_player_id = w.id
 		LEFT JOIN users b ON g.black_player_id = b.id
-		WHERE g.id = $1
-	`, gameID).Scan(
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGame(row rowScanner) (*Game, error) {
+	game := &Game{}
+	whitePlayer := &User{}
+	blackPlayer := &User{}
+
+	err := row.Scan(
 		&game.ID, &game.WhitePlayerID, &game.BlackPlayerID, &game.MetaData,
-		&game.Status, &game.Winner, &game.CreatedAt, &game.UpdatedAt,
+		&game.Variant, &game.InitialFEN, &game.CurrentFEN,
+		&game.DaysPerMove, &game.MoveDeadline,
+		&game.ClockLimit, &game.ClockIncrement, &game.Rated, &game.Private, &game.TournamentID, &game.Status, &game.Winner, &game.CreatedAt, &game.UpdatedAt,
+		&game.WhiteTimeMs, &game.BlackTimeMs, &game.Berserkable, &game.WhiteBerserk, &game.BlackBerserk, &game.SimulID,
+		&game.ClockRunning, &game.TurnStartedAt,
 		&whitePlayer.ID, &whitePlayer.Name, &whitePlayer.Email, &whitePlayer.AvatarURL,
 		&blackPlayer.ID, &blackPlayer.Name, &blackPlayer.Email, &blackPlayer.AvatarURL,
 	)
-
 	if err != nil {
-		log.Println("Error fetching game:", err)
 		return nil, err
 	}
 
@@ -90,6 +178,44 @@ func (gs *GameService) GetGame(gameID string) (*Game, error) {
 	return game, nil
 }
 
+func (gs *GameService) GetGame(gameID string) (*Game, error) {
+	fmt.Println("gameID", gameID)
+
+	game, err := scanGame(gs.db.QueryRow(gameSelect+` WHERE g.id = $1`, gameID))
+	if err != nil {
+		log.Println("Error fetching game:", err)
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// ListUserGames returns a user's most recent games, optionally filtered by
+// variant.
+func (gs *GameService) ListUserGames(userID int, variant string) ([]Game, error) {
+	rows, err := gs.db.Query(gameSelect+`
+		WHERE (g.white_player_id = $1 OR g.black_player_id = $1)
+		AND ($2 = '' OR g.variant = $2)
+		ORDER BY g.created_at DESC
+		LIMIT 50
+	`, userID, variant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []Game{}
+	for rows.Next() {
+		game, err := scanGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, *game)
+	}
+
+	return games, rows.Err()
+}
+
 func (gs *GameService) DeleteGame(gameID string) error {
 	_, err := gs.db.Exec(`DELETE from games WHERE id = $1`, gameID)
 	return err
@@ -101,10 +227,81 @@ func (gs *GameService) UpdateGame(gameID string, status string, winner string, m
         SET status = $1, winner = $2, updated_at = CURRENT_TIMESTAMP, metadata = $3
         WHERE id = $4
     `, status, winner, moveData, gameID)
+	if err == nil && status == "completed" {
+		gs.completed(gameID)
+	}
+
+	return err
+}
+
+var errAlreadyBerserk = errors.New("you have already gone berserk")
+
+// SaveClock stores the clock so a reloaded room can resume it: the times
+// left when running's turn started at since. running is "" while the clock
+// is stopped, which keeps the stored turn start.
+func (gs *GameService) SaveClock(gameID string, whiteMs, blackMs int64, running string, since time.Time) error {
+	_, err := gs.db.Exec(`
+        UPDATE games
+        SET white_time_ms = $1, black_time_ms = $2, clock_running = NULLIF($3, ''),
+            turn_started_at = CASE WHEN $3 = '' THEN turn_started_at ELSE $4 END
+        WHERE id = $5
+    `, whiteMs, blackMs, running, since, gameID)
+	return err
+}
+
+// SetBerserk records that color went berserk. color is "white" or "black".
+func (gs *GameService) SetBerserk(gameID string, color string) error {
+	result, err := gs.db.Exec(`
+        UPDATE games SET `+color+`_berserk = TRUE
+        WHERE id = $1 AND berserkable AND NOT `+color+`_berserk
+    `, gameID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errAlreadyBerserk
+	}
+	return nil
+}
+
+// FinishGame marks a game completed without touching its move metadata.
+func (gs *GameService) FinishGame(gameID string, winner string) error {
+	_, err := gs.db.Exec(`
+        UPDATE games
+        SET status = 'completed', winner = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, winner, gameID)
+	if err == nil {
+		gs.completed(gameID)
+	}
 
 	return err
 }
 
+// OnComplete registers hook to run whenever a game completes. Hooks are
+// registered at startup, before any game is played, and must tolerate
+// seeing a game twice: a game ended from outside its open room completes
+// again when the room closes it.
+func (gs *GameService) OnComplete(hook func(*Game)) {
+	gs.completeHooks = append(gs.completeHooks, hook)
+}
+
+// completed runs the completion hooks on the finished game. Every path that
+// ends a game calls it.
+func (gs *GameService) completed(gameID string) {
+	if len(gs.completeHooks) == 0 {
+		return
+	}
+	game, err := gs.GetGame(gameID)
+	if err != nil {
+		log.Println("Failed to load completed game:", err)
+		return
+	}
+	for _, hook := range gs.completeHooks {
+		go hook(game)
+	}
+}
+
 func (gs *GameService) setDisconnectionTime(userID int) error {
 	disconnectionTime := time.Now()
 	_, err := gs.db.Exec(`UPDATE users SET disconnected_at=$1 WHERE id=$2`, disconnectionTime, userID)
@@ -121,19 +318,159 @@ func (gs *GameService) UpdateActiveGameState(userID int, gameID string) error {
 	return err
 }
 
+// SaveMove records a move. Crazyhouse drops are stored with the piece and
+// "@" in move_from (e.g. "N@") so that move_from+move_to is the UCI string.
 func (gs *GameService) SaveMove(gameID string, playerID int, moveFrom, moveTo, piece, fenAfter string, moveNumber int) error {
+	isDrop := strings.HasSuffix(moveFrom, "@")
+	_, err := gs.db.Exec(`
+        INSERT INTO game_moves (game_id, player_id, move_from, move_to, piece, is_drop, fen_after, move_number)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `, gameID, playerID, moveFrom, moveTo, piece, isDrop, fenAfter, moveNumber)
+
+	return err
+}
+
+func (gs *GameService) UpdateFEN(gameID string, fen string) error {
 	_, err := gs.db.Exec(`
-        INSERT INTO game_moves (game_id, player_id, move_from, move_to, piece, fen_after, move_number)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
-    `, gameID, playerID, moveFrom, moveTo, piece, fenAfter, moveNumber)
+        UPDATE games
+        SET current_fen = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, fen, gameID)
 
 	return err
 }
 
+var errStalePosition = errors.New("the game has moved on, reload it")
+
+// PlayMove validates a move against pos and persists it. drop means data
+// describes a piece drop (Piece and To) rather than an ordinary move.
+func (gs *GameService) PlayMove(gameID string, pos *Position, playerID int, data MoveData, drop bool) (Move, *Position, error) {
+	return gs.playMove(gameID, "", pos, playerID, data, drop)
+}
+
+// PlayMoveFrom is PlayMove for callers outside a room, which do not own the
+// game: the move is only stored if the game is still active at fen, so two
+// concurrent moves cannot both be played from the same position.
+func (gs *GameService) PlayMoveFrom(gameID, fen string, pos *Position, playerID int, data MoveData, drop bool) (Move, *Position, error) {
+	return gs.playMove(gameID, fen, pos, playerID, data, drop)
+}
+
+func (gs *GameService) playMove(gameID, fen string, pos *Position, playerID int, data MoveData, drop bool) (Move, *Position, error) {
+	uci := data.From + data.To + data.Promotion
+	if drop {
+		uci = strings.ToUpper(data.Piece) + "@" + data.To
+	}
+
+	move, err := pos.ParseUCI(uci)
+	if err != nil {
+		return Move{}, nil, err
+	}
+
+	uci = pos.MoveUCI(move)
+	next := pos.Play(move)
+	if fen != "" {
+		result, err := gs.db.Exec(`
+            UPDATE games
+            SET current_fen = $1, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2 AND current_fen = $3 AND status = 'active'
+        `, next.FEN(), gameID, fen)
+		if err != nil {
+			return Move{}, nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return Move{}, nil, errStalePosition
+		}
+	}
+	ply := (pos.FullMove-1)*2 + int(pos.Turn) + 1
+	if err := gs.SaveMove(gameID, playerID, uci[:2], uci[2:4], move.Piece.String(), next.FEN(), ply); err != nil {
+		log.Println("Failed to save move:", err)
+	}
+	if fen == "" {
+		if err := gs.UpdateFEN(gameID, next.FEN()); err != nil {
+			log.Println("Failed to update FEN:", err)
+		}
+	}
+	if err := gs.resetMoveDeadline(gameID); err != nil {
+		log.Println("Failed to reset move deadline:", err)
+	}
+	if over, _ := next.Outcome(); !over {
+		gs.notifyTurn(gameID)
+	}
+
+	return move, next, nil
+}
+
+// resetMoveDeadline restarts the clock of a correspondence game after a move.
+func (gs *GameService) resetMoveDeadline(gameID string) error {
+	_, err := gs.db.Exec(`
+        UPDATE games
+        SET move_deadline = CURRENT_TIMESTAMP + days_per_move * INTERVAL '1 day'
+        WHERE id = $1 AND days_per_move > 0
+    `, gameID)
+
+	return err
+}
+
+// notifyTurn tells the side to move in a correspondence game that it is
+// their turn. Live games are skipped.
+func (gs *GameService) notifyTurn(gameID string) {
+	var playerID int
+	var deadline time.Time
+	err := gs.db.QueryRow(`
+        SELECT CASE split_part(current_fen, ' ', 2) WHEN 'w' THEN white_player_id ELSE black_player_id END,
+            move_deadline
+        FROM games
+        WHERE id = $1 AND days_per_move > 0 AND black_player_id IS NOT NULL
+    `, gameID).Scan(&playerID, &deadline)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to look up player to move:", err)
+		}
+		return
+	}
+
+	gs.notifications.Notify(playerID, NotifyYourTurn, map[string]interface{}{
+		"game_id":       gameID,
+		"move_deadline": deadline,
+	})
+}
+
+func (gs *GameService) GetGameMoves(gameID string) ([]GameMove, error) {
+	rows, err := gs.db.Query(`
+        SELECT id, game_id, player_id, move_from, move_to, piece, COALESCE(is_drop, FALSE), fen_after, move_number, created_at
+        FROM game_moves
+        WHERE game_id = $1
+        ORDER BY move_number, id
+    `, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moves []GameMove
+	for rows.Next() {
+		var m GameMove
+		if err := rows.Scan(&m.ID, &m.GameID, &m.PlayerID, &m.MoveFrom, &m.MoveTo, &m.Piece, &m.IsDrop, &m.FENAfter, &m.MoveNumber, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		moves = append(moves, m)
+	}
+
+	return moves, rows.Err()
+}
+
 func (gs *GameService) GetUserGames(w http.ResponseWriter, r *http.Request) {
-	// Implementation would get user from context and return their games
+	user := currentUser(r)
+
+	games, err := gs.ListUserGames(user.ID, r.URL.Query().Get("variant"))
+	if err != nil {
+		log.Println("Error fetching games:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]Game{})
+	json.NewEncoder(w).Encode(games)
 }
 
 func (gs *GameService) GetGamebyID(w http.ResponseWriter, r *http.Request) {
@@ -157,3 +494,106 @@ func (gs *GameService) GetGamebyID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(game)
 }
+
+func (gs *GameService) ListVariants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VariantNames())
+}
+
+func (gs *GameService) ExportPGN(w http.ResponseWriter, r *http.Request) {
+	gameID := mux.Vars(r)["id"]
+
+	game, err := gs.GetGame(gameID)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	moves, err := gs.GetGameMoves(gameID)
+	if err != nil {
+		log.Println("Error fetching moves:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	pgn, err := BuildPGN(game, moves)
+	if err != nil {
+		log.Println("Error building PGN:", err)
+		http.Error(w, "Failed to build PGN", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-chess-pgn")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", gameID+".pgn"))
+	fmt.Fprint(w, pgn)
+}
+
+func (gs *GameService) CreateBughouseMatch(matchID string) error {
+	_, err := gs.db.Exec(`
+        INSERT INTO bughouse_matches (id, board_a_game_id, board_b_game_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (id) DO NOTHING
+    `, matchID, bughouseBoardID(matchID, 0), bughouseBoardID(matchID, 1))
+
+	return err
+}
+
+// FinishBughouseMatch records the winning team, 1 or 2, or 0 for a draw.
+func (gs *GameService) FinishBughouseMatch(matchID string, team int) error {
+	var winningTeam *int
+	if team != 0 {
+		winningTeam = &team
+	}
+	_, err := gs.db.Exec(`
+        UPDATE bughouse_matches
+        SET status = 'completed', winning_team = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, winningTeam, matchID)
+
+	return err
+}
+
+func (gs *GameService) GetBughouseGame(matchID string) (*BughouseGame, error) {
+	match := &BughouseGame{}
+	err := gs.db.QueryRow(`
+        SELECT id, board_a_game_id, board_b_game_id, status, winning_team, created_at, updated_at
+        FROM bughouse_matches
+        WHERE id = $1
+    `, matchID).Scan(&match.ID, &match.BoardAGameID, &match.BoardBGameID, &match.Status,
+		&match.WinningTeam, &match.CreatedAt, &match.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gameID := range []string{match.BoardAGameID, match.BoardBGameID} {
+		board := BughouseBoard{Moves: []GameMove{}}
+		if game, err := gs.GetGame(gameID); err == nil {
+			board.Game = game
+			board.Moves, err = gs.GetGameMoves(gameID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		match.Boards = append(match.Boards, board)
+	}
+
+	return match, nil
+}
+
+func (gs *GameService) GetBughouseMatch(w http.ResponseWriter, r *http.Request) {
+	matchID := mux.Vars(r)["id"]
+
+	match, err := gs.GetBughouseGame(matchID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Match not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching bughouse match:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(match)
+}
@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// horde pits 36 white pawns against a normal black army. White has no king
+// and wins by checkmate; black wins by capturing every white piece. White
+// pawns on the first rank may advance two squares, without allowing en
+// passant.
+type horde struct {
+	standardRules
+}
+
+const hordeStartFEN = "rnbqkbnr/pppppppp/8/1PP2PP1/PPPPPPPP/PPPPPPPP/PPPPPPPP/PPPPPPPP w kq - 0 1"
+
+func (horde) Name() string {
+	return VariantHorde
+}
+
+func (horde) PGNName() string {
+	return "Horde"
+}
+
+func (horde) StartFEN(index int) (string, error) {
+	return hordeStartFEN, nil
+}
+
+func (horde) CheckSetup(pos *Position) error {
+	if pos.kingSquare(White) != NoSquare || pos.kingSquare(Black) == NoSquare {
+		return fmt.Errorf("horde needs a black king and no white king")
+	}
+	return nil
+}
+
+func (horde) AddMoves(pos *Position, moves []Move) []Move {
+	if pos.Turn != White {
+		return moves
+	}
+	pawn := NewPiece(Pawn, White)
+	for file := 0; file < 8; file++ {
+		from := SquareAt(file, 0)
+		if pos.Board[from] != pawn || pos.Board[SquareAt(file, 1)] != NoPiece || pos.Board[SquareAt(file, 2)] != NoPiece {
+			continue
+		}
+		moves = append(moves, Move{From: from, To: SquareAt(file, 2), Piece: pawn})
+	}
+	return moves
+}
+
+func (v horde) Outcome(pos *Position) (bool, string) {
+	if pos.pieceCount(White) == 0 {
+		return true, Black.String()
+	}
+	return v.standardRules.Outcome(pos)
+}
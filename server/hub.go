@@ -77,18 +77,37 @@ import (
 type Hub struct {
 	db          *sql.DB
 	gameService *GameService
+	invites     *InviteService
+	presence    *PresenceTracker
+	blocks      *BlockService
+	chat        *ChatService
 	Rooms       map[string]*Room
+	Matches     map[string]*BughouseMatch
 	Register    chan *Client
 	Unregister  chan *Client
+	// Moves and Results come from outside any socket: REST moves and the
+	// correspondence scheduler. They are forwarded to the game's room, if
+	// one is open.
+	Moves     chan RemoteMove
+	Results   chan GameResult
+	Sanctions chan Sanction
 }
 
-func NewHub(db *sql.DB, gameService *GameService) *Hub {
+func NewHub(db *sql.DB, gameService *GameService, invites *InviteService, presence *PresenceTracker, blocks *BlockService, chat *ChatService) *Hub {
 	return &Hub{
 		db:          db,
 		gameService: gameService,
+		invites:     invites,
+		presence:    presence,
+		blocks:      blocks,
+		chat:        chat,
 		Rooms:       make(map[string]*Room),
+		Matches:     make(map[string]*BughouseMatch),
 		Register:    make(chan *Client),
 		Unregister:  make(chan *Client),
+		Moves:       make(chan RemoteMove),
+		Results:     make(chan GameResult),
+		Sanctions:   make(chan Sanction, 16),
 	}
 }
 
@@ -96,10 +115,18 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
+			if client.ClubID != 0 {
+				h.registerClubClient(client)
+				continue
+			}
+
 			room, ok := h.Rooms[client.RoomID]
 			if !ok {
-				room = NewRoom(client.RoomID, h.db, h.gameService)
+				room = NewRoom(client.RoomID, h.db, h.gameService, h.chat)
 				h.Rooms[client.RoomID] = room
+				if client.Options.MatchID != "" {
+					h.linkBughouseRoom(client.Options, room)
+				}
 				go room.Run()
 			}
 
@@ -120,10 +147,10 @@ func (h *Hub) Run() {
 			// var gameID string
 			gameID := client.RoomID
 			fmt.Println(gameID)
-			_, err := h.gameService.GetGame(gameID)
+			existing, err := h.gameService.GetGame(gameID)
 			if err != nil {
 				// Create new game
-				game, err := h.gameService.CreateGame(client.User.ID, gameID)
+				game, err := h.gameService.CreateGame(client.User.ID, gameID, client.Options)
 				if err != nil {
 					client.Conn.WriteJSON(map[string]string{
 						"type":    "error",
@@ -135,9 +162,37 @@ func (h *Hub) Run() {
 				}
 				gameID = game.ID
 				client.RoomID = gameID
+			} else if playerColor(existing, client.User.ID) == "" && existing.WhitePlayerID != nil &&
+				h.blocks.IsBlocked(*existing.WhitePlayerID, client.User.ID) {
+				client.Conn.WriteJSON(map[string]string{
+					"type":    "error",
+					"message": "You cannot join this game",
+				})
+				client.Conn.Close()
+				h.discardRoom(room)
+				continue
+			} else if existing.Private && playerColor(existing, client.User.ID) == "" {
+				// Private games only seat a second player holding a valid
+				// invite for this game.
+				var err error
+				if existing.BlackPlayerID != nil {
+					err = fmt.Errorf("this game is private")
+				} else {
+					err = h.invites.Redeem(client.Invite, gameID, client.User.ID)
+				}
+				if err != nil {
+					client.Conn.WriteJSON(map[string]string{
+						"type":    "error",
+						"message": err.Error(),
+					})
+					client.Conn.Close()
+					h.discardRoom(room)
+					continue
+				}
 			} else {
-				// Try to join existing game
-				if len(room.Clients) == 1 {
+				// Try to join existing game. Correspondence games can be
+				// joined while their creator is offline.
+				if len(room.Clients) == 1 || existing.DaysPerMove > 0 {
 					if err := h.gameService.JoinGame(gameID, client.User.ID); err != nil {
 						client.Conn.WriteJSON(map[string]string{
 							"type":    "error",
@@ -160,6 +215,12 @@ func (h *Hub) Run() {
 				continue
 			}
 
+			if room.position == nil {
+				if err := room.loadPosition(game); err != nil {
+					log.Println("Failed to load position:", err)
+				}
+			}
+
 			// Assign color based on database
 			if game.WhitePlayerID != nil && *game.WhitePlayerID == client.User.ID {
 				client.Color = "white"
@@ -167,7 +228,9 @@ func (h *Hub) Run() {
 				client.Color = "black"
 			}
 
+			client.Blocked = h.blocks.BlockedIDs(client.User.ID)
 			room.Clients[client] = true
+			h.presence.JoinedGame(client.User.ID, client.RoomID)
 
 			// Send game state to client
 			initMsg := map[string]interface{}{
@@ -208,6 +271,13 @@ func (h *Hub) Run() {
 		case client := <-h.Unregister:
 			if room, ok := h.Rooms[client.RoomID]; ok {
 				room.Unregister <- client
+				if room.clubID != 0 {
+					if len(room.Clients) == 0 {
+						h.removeRoom(room)
+					}
+					continue
+				}
+				h.presence.LeftGame(client.User.ID, client.RoomID)
 
 				err1 := h.gameService.setDisconnectionTime(client.User.ID)
 				if err1 != nil {
@@ -231,7 +301,18 @@ func (h *Hub) Run() {
 				}
 
 				if len(room.Clients) == 0 {
-					delete(h.Rooms, client.RoomID)
+					h.removeRoom(room)
+					if room.match != nil {
+						// Bughouse boards stay in the database as part of
+						// the combined match record.
+						if room.match.unlink(room) {
+							delete(h.Matches, room.match.ID)
+						}
+						continue
+					}
+					if room.correspondence || room.tournament || room.simulID != 0 {
+						continue
+					}
 					err := h.gameService.DeleteGame(client.RoomID)
 					if err != nil {
 						fmt.Print(err)
@@ -252,6 +333,78 @@ func (h *Hub) Run() {
 
 				}
 			}
+
+		case move := <-h.Moves:
+			if room, ok := h.Rooms[move.GameID]; ok {
+				room.Remote <- move
+			} else {
+				move.Result <- errNoRoom
+			}
+
+		case result := <-h.Results:
+			if room, ok := h.Rooms[result.GameID]; ok {
+				room.Terminate <- result
+			}
+
+		case sanction := <-h.Sanctions:
+			for _, room := range h.Rooms {
+				for client := range room.Clients {
+					if client.User.ID == sanction.UserID {
+						room.Sanctions <- sanction
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// removeRoom drops a room nobody is connected to and stops its goroutine,
+// including any pending flag check.
+func (h *Hub) removeRoom(room *Room) {
+	delete(h.Rooms, room.ID)
+	close(room.stop)
+}
+
+// discardRoom removes a room again when the client it was opened for is
+// turned away and nobody else is in it.
+func (h *Hub) discardRoom(room *Room) {
+	if len(room.Clients) == 0 {
+		h.removeRoom(room)
+	}
+}
+
+// registerClubClient seats a member in their club's chat room. Membership
+// is checked before the socket is upgraded.
+func (h *Hub) registerClubClient(client *Client) {
+	room, ok := h.Rooms[client.RoomID]
+	if !ok {
+		room = NewRoom(client.RoomID, h.db, h.gameService, h.chat)
+		room.clubID = client.ClubID
+		h.Rooms[client.RoomID] = room
+		go room.Run()
+	}
+
+	client.Blocked = h.blocks.BlockedIDs(client.User.ID)
+	room.Clients[client] = true
+	client.sendJSON(map[string]interface{}{
+		"type":    "init",
+		"club_id": client.ClubID,
+		"user":    client.User,
+	})
+	room.Register <- client
+}
+
+// linkBughouseRoom attaches a new board room to its match, creating the
+// match record when the first board opens.
+func (h *Hub) linkBughouseRoom(options GameOptions, room *Room) {
+	match, ok := h.Matches[options.MatchID]
+	if !ok {
+		match = NewBughouseMatch(options.MatchID, h.gameService)
+		h.Matches[options.MatchID] = match
+		if err := h.gameService.CreateBughouseMatch(options.MatchID); err != nil {
+			log.Println("Failed to create bughouse match:", err)
 		}
 	}
+	match.link(options.Board, room)
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultInviteTTL = 24 * time.Hour
+	maxInviteTTL     = 7 * 24 * time.Hour
+)
+
+// InviteService issues and redeems the signed, single-use tokens that seat
+// the second player of a private game.
+type InviteService struct {
+	db          *sql.DB
+	gameService *GameService
+	secret      []byte
+}
+
+// InviteClaims ties a token to a game and, optionally, to one invitee. The
+// JWT ID names the game_invites row that makes the token single-use.
+type InviteClaims struct {
+	GameID    string `json:"game_id"`
+	InviteeID *int   `json:"invitee_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type CreateInviteRequest struct {
+	InviteeID        *int `json:"invitee_id"`
+	ExpiresInMinutes int  `json:"expires_in_minutes"`
+}
+
+type InviteResponse struct {
+	Token     string    `json:"token"`
+	GameID    string    `json:"game_id"`
+	InviteeID *int      `json:"invitee_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewInviteService derives its signing key from the JWT secret so that an
+// invite can never pass as a login token.
+func NewInviteService(db *sql.DB, gameService *GameService, jwtSecret []byte) *InviteService {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("game-invite"))
+	return &InviteService{
+		db:          db,
+		gameService: gameService,
+		secret:      mac.Sum(nil),
+	}
+}
+
+func (is *InviteService) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	gameID := mux.Vars(r)["id"]
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultInviteTTL
+	if req.ExpiresInMinutes > 0 {
+		ttl = time.Duration(req.ExpiresInMinutes) * time.Minute
+	}
+	if ttl > maxInviteTTL {
+		http.Error(w, "Invites expire after at most 7 days", http.StatusBadRequest)
+		return
+	}
+
+	game, err := is.gameService.GetGame(gameID)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	if playerColor(game, user.ID) == "" {
+		http.Error(w, "Only players can invite to a game", http.StatusForbidden)
+		return
+	}
+	if game.BlackPlayerID != nil {
+		http.Error(w, "Game is already full", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	tokenID := newGameID()
+	if _, err := is.db.Exec(`
+        INSERT INTO game_invites (id, game_id, inviter_id, invitee_id, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+    `, tokenID, gameID, user.ID, req.InviteeID, expiresAt); err != nil {
+		log.Println("Error creating invite:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	claims := InviteClaims{
+		GameID:    gameID,
+		InviteeID: req.InviteeID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(is.secret)
+	if err != nil {
+		http.Error(w, "Failed to sign invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(InviteResponse{
+		Token:     token,
+		GameID:    gameID,
+		InviteeID: req.InviteeID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// Redeem checks an invite token for gameID and userID, marks it used and
+// seats userID as black. The invite is only used up if the seat is taken.
+func (is *InviteService) Redeem(token string, gameID string, userID int) error {
+	if token == "" {
+		return fmt.Errorf("this game is private and needs an invite")
+	}
+
+	claims := &InviteClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return is.secret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return fmt.Errorf("invalid or expired invite")
+	}
+	if claims.GameID != gameID {
+		return fmt.Errorf("invite is for another game")
+	}
+	if claims.InviteeID != nil && *claims.InviteeID != userID {
+		return fmt.Errorf("invite is for another player")
+	}
+
+	tx, err := is.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        UPDATE game_invites
+        SET used_by = $1, used_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND game_id = $3 AND used_by IS NULL AND expires_at > CURRENT_TIMESTAMP
+    `, userID, claims.ID, gameID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return fmt.Errorf("invite has already been used")
+	}
+	joined, err := joinGameTx(tx, gameID, userID)
+	if err != nil {
+		return err
+	}
+	if !joined {
+		return fmt.Errorf("this game is private")
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// armageddonBlackShare is black's share of white's time in an armageddon
+// game, in which a draw counts as a win for black.
+const armageddonBlackShare = 0.8
+
+// knockoutRounds is the number of rounds needed to get n players down to
+// one winner.
+func knockoutRounds(n int) int {
+	rounds := 0
+	for size := 1; size < n; size *= 2 {
+		rounds++
+	}
+	return rounds
+}
+
+// bracketOrder returns the seeds of a bracket of size slots in board order,
+// so that seed 1 meets seed size, seed 2 can only meet seed 1 in the final
+// and so on.
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		next := make([]int, 0, 2*len(order))
+		for _, seed := range order {
+			next = append(next, seed, 2*len(order)+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// knockoutGame is the next game a match needs.
+type knockoutGame struct {
+	White, Black int
+	Number       int
+	Armageddon   bool
+}
+
+// knockoutMatches groups the pairings of a knockout into matches, works out
+// the winner of each that is decided and the next game of those that are
+// not. seeds maps players to their seed.
+func knockoutMatches(t *Tournament, pairings []TournamentPairing, seeds map[int]int, withdrawn map[int]bool) ([]KnockoutMatch, map[int]knockoutGame) {
+	var matches []KnockoutMatch
+	for _, p := range pairings {
+		n := len(matches)
+		if n == 0 || matches[n-1].Round != p.Round || matches[n-1].Board != p.Board {
+			matches = append(matches, KnockoutMatch{Round: p.Round, Board: p.Board, PlayerA: p.WhiteID, PlayerB: p.BlackID})
+			n++
+		}
+		matches[n-1].Games = append(matches[n-1].Games, p)
+	}
+
+	next := make(map[int]knockoutGame)
+	for i := range matches {
+		if game, ok := decideMatch(t, &matches[i], seeds, withdrawn); ok {
+			next[i] = game
+		}
+	}
+	return matches, next
+}
+
+// decideMatch scores m and sets its winner if it is decided. Otherwise it
+// returns the next game to play, if the last one is finished. A tie after
+// the mini-match goes to armageddon when enabled, else to the higher seed.
+func decideMatch(t *Tournament, m *KnockoutMatch, seeds map[int]int, withdrawn map[int]bool) (knockoutGame, bool) {
+	m.ScoreA, m.ScoreB = 0, 0
+	m.WinnerID = nil
+	win := func(id int) (knockoutGame, bool) {
+		m.WinnerID = &id
+		return knockoutGame{}, false
+	}
+	if m.PlayerB == nil {
+		return win(m.PlayerA)
+	}
+	a, b := m.PlayerA, *m.PlayerB
+
+	played := 0
+	var armageddon *TournamentPairing
+	for i, g := range m.Games {
+		if g.Result == nil {
+			return knockoutGame{}, false
+		}
+		if g.Armageddon {
+			armageddon = &m.Games[i]
+			continue
+		}
+		white, black := resultPoints(*g.Result)
+		if g.WhiteID == a {
+			m.ScoreA, m.ScoreB = m.ScoreA+white, m.ScoreB+black
+		} else {
+			m.ScoreA, m.ScoreB = m.ScoreA+black, m.ScoreB+white
+		}
+		played++
+	}
+
+	switch {
+	case withdrawn[a] && !withdrawn[b]:
+		return win(b)
+	case withdrawn[b] && !withdrawn[a]:
+		return win(a)
+	}
+
+	remaining := float64(t.MatchGames - played)
+	switch {
+	case m.ScoreA-m.ScoreB > remaining:
+		return win(a)
+	case m.ScoreB-m.ScoreA > remaining:
+		return win(b)
+	case remaining > 0:
+		// Colours alternate, A having white in the odd games.
+		if played%2 == 0 {
+			return knockoutGame{White: a, Black: b, Number: len(m.Games) + 1}, true
+		}
+		return knockoutGame{White: b, Black: a, Number: len(m.Games) + 1}, true
+	case m.ScoreA != m.ScoreB:
+		if m.ScoreA > m.ScoreB {
+			return win(a)
+		}
+		return win(b)
+	}
+
+	higher, lower := a, b
+	if seeds[b] < seeds[a] {
+		higher, lower = b, a
+	}
+	if armageddon != nil {
+		if *armageddon.Result == ResultWhiteWins {
+			return win(armageddon.WhiteID)
+		}
+		return win(*armageddon.BlackID)
+	}
+	if t.Armageddon {
+		// The higher seed takes white and the extra time.
+		return knockoutGame{White: higher, Black: lower, Number: len(m.Games) + 1, Armageddon: true}, true
+	}
+	return win(higher)
+}
+
+// advanceKnockout plays out the current round: it starts the next game of
+// every undecided match whose last game is over, and once all matches are
+// decided pairs the winners or finishes the tournament. Round one is drawn
+// from the seeds, with byes for the top seeds when the field is not a power
+// of two.
+func (ts *TournamentService) advanceKnockout(t *Tournament) error {
+	players, err := ts.players(t.ID)
+	if err != nil {
+		return err
+	}
+	seeds := make(map[int]int, len(players))
+	withdrawn := make(map[int]bool)
+	for _, p := range players {
+		seeds[p.UserID] = p.Seed
+		withdrawn[p.UserID] = p.Withdrawn
+	}
+
+	if t.CurrentRound == 0 {
+		return ts.drawKnockout(t, players)
+	}
+
+	pairings, err := ts.pairings(t.ID, t.CurrentRound)
+	if err != nil {
+		return err
+	}
+	matches, next := knockoutMatches(t, pairings, seeds, withdrawn)
+	for i, game := range next {
+		m := matches[i]
+		if err := ts.startKnockoutGame(t, m.Round, m.Board, game); err != nil {
+			return err
+		}
+	}
+
+	var winners []int
+	for _, m := range matches {
+		if m.WinnerID == nil {
+			return nil
+		}
+		winners = append(winners, *m.WinnerID)
+	}
+	if len(winners) <= 1 || t.CurrentRound >= t.Rounds {
+		return ts.finish(t)
+	}
+
+	// Every board is started before the round is claimed, so a failure part
+	// of the way is retried on the next call. startKnockoutGame skips the
+	// boards that already exist.
+	round := t.CurrentRound + 1
+	for i := 0; i+1 < len(winners); i += 2 {
+		game := knockoutGame{White: winners[i], Black: winners[i+1], Number: 1}
+		if err := ts.startKnockoutGame(t, round, i/2+1, game); err != nil {
+			return err
+		}
+	}
+	_, err = claimRound(ts.db, t, round)
+	return err
+}
+
+// drawKnockout pairs round one from the seeds.
+func (ts *TournamentService) drawKnockout(t *Tournament, players []TournamentStanding) error {
+	var entrants []int
+	sort.Slice(players, func(i, j int) bool { return players[i].Seed < players[j].Seed })
+	for _, p := range players {
+		if !p.Withdrawn {
+			entrants = append(entrants, p.UserID)
+		}
+	}
+	if len(entrants) < 2 {
+		return ts.finish(t)
+	}
+
+	// As in advanceKnockout, round one is claimed once every board exists.
+	order := bracketOrder(1 << knockoutRounds(len(entrants)))
+	for i := 0; i < len(order); i += 2 {
+		board := i/2 + 1
+		top, bottom := order[i], order[i+1]
+		if bottom > len(entrants) {
+			if _, err := ts.db.Exec(`
+                INSERT INTO tournament_pairings (tournament_id, round, board, game_number, white_id, result)
+                VALUES ($1, 1, $2, 1, $3, $4)
+                ON CONFLICT (tournament_id, round, board, game_number) DO NOTHING
+            `, t.ID, board, entrants[top-1], ResultBye); err != nil {
+				return err
+			}
+			continue
+		}
+		game := knockoutGame{White: entrants[top-1], Black: entrants[bottom-1], Number: 1}
+		if err := ts.startKnockoutGame(t, 1, board, game); err != nil {
+			return err
+		}
+	}
+	_, err := claimRound(ts.db, t, 1)
+	return err
+}
+
+// startKnockoutGame records the pairing first, so that a concurrent caller
+// cannot start the same game twice, then creates the game. Armageddon games
+// start with black on less time.
+func (ts *TournamentService) startKnockoutGame(t *Tournament, round, board int, game knockoutGame) error {
+	black := game.Black
+	pairing := TournamentPairing{
+		TournamentID: t.ID,
+		Round:        round,
+		Board:        board,
+		GameNumber:   game.Number,
+		Armageddon:   game.Armageddon,
+		WhiteID:      game.White,
+		BlackID:      &black,
+	}
+	err := ts.db.QueryRow(`
+        INSERT INTO tournament_pairings (tournament_id, round, board, game_number, armageddon, white_id, black_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (tournament_id, round, board, game_number) DO NOTHING
+        RETURNING id
+    `, t.ID, round, board, game.Number, game.Armageddon, game.White, game.Black).Scan(&pairing.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	gameID, err := ts.createPairingGame(t, game.White, game.Black)
+	if err != nil {
+		ts.db.Exec(`DELETE FROM tournament_pairings WHERE id = $1`, pairing.ID)
+		return err
+	}
+	if game.Armageddon {
+		limit := int64(t.ClockLimit) * 1000
+		if err := ts.gameService.SaveClock(gameID, limit, int64(float64(limit)*armageddonBlackShare), "", time.Time{}); err != nil {
+			return err
+		}
+	}
+	if _, err := ts.db.Exec(`UPDATE tournament_pairings SET game_id = $1 WHERE id = $2`, gameID, pairing.ID); err != nil {
+		return err
+	}
+	pairing.GameID = &gameID
+
+	for _, userID := range []int{game.White, game.Black} {
+		ts.notifications.Notify(userID, NotifyTournamentRound, map[string]interface{}{
+			"tournament": t,
+			"pairing":    pairing,
+		})
+	}
+	return nil
+}
+
+// computeKnockoutStandings ranks players by how far they got: the players
+// still in, then those knocked out in later rounds first. Score is the
+// number of matches won.
+func computeKnockoutStandings(t *Tournament, players []TournamentStanding, pairings []TournamentPairing) []TournamentStanding {
+	seeds := make(map[int]int, len(players))
+	withdrawn := make(map[int]bool)
+	index := make(map[int]int, len(players))
+	for i := range players {
+		seeds[players[i].UserID] = players[i].Seed
+		withdrawn[players[i].UserID] = players[i].Withdrawn
+		index[players[i].UserID] = i
+		players[i].Score = 0
+		players[i].Played = 0
+	}
+
+	reached := make(map[int]int)
+	out := make(map[int]bool)
+	matches, _ := knockoutMatches(t, pairings, seeds, withdrawn)
+	for _, m := range matches {
+		for _, id := range []*int{&m.PlayerA, m.PlayerB} {
+			if id == nil {
+				continue
+			}
+			reached[*id] = m.Round
+			if i, ok := index[*id]; ok {
+				for _, g := range m.Games {
+					if g.Result != nil && g.BlackID != nil {
+						players[i].Played++
+					}
+				}
+			}
+			if m.WinnerID != nil && *m.WinnerID != *id {
+				out[*id] = true
+			}
+		}
+		if m.WinnerID != nil {
+			if i, ok := index[*m.WinnerID]; ok {
+				players[i].Score++
+			}
+		}
+	}
+
+	sort.SliceStable(players, func(i, j int) bool {
+		a, b := players[i].UserID, players[j].UserID
+		switch {
+		case out[a] != out[b]:
+			return !out[a]
+		case reached[a] != reached[b]:
+			return reached[a] > reached[b]
+		case players[i].Score != players[j].Score:
+			return players[i].Score > players[j].Score
+		}
+		return players[i].Seed < players[j].Seed
+	})
+	for i := range players {
+		players[i].Rank = i + 1
+	}
+	return players
+}
+
+// GetBracket returns every match of a knockout, round by round.
+func (ts *TournamentService) GetBracket(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if t.Format != FormatKnockout {
+		http.Error(w, "Only knockout tournaments have a bracket", http.StatusBadRequest)
+		return
+	}
+
+	players, err := ts.players(t.ID)
+	if err != nil {
+		log.Println("Error fetching players:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	pairings, err := ts.pairings(t.ID, 0)
+	if err != nil {
+		log.Println("Error fetching pairings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	seeds := make(map[int]int, len(players))
+	withdrawn := make(map[int]bool)
+	for _, p := range players {
+		seeds[p.UserID] = p.Seed
+		withdrawn[p.UserID] = p.Withdrawn
+	}
+	matches, _ := knockoutMatches(t, pairings, seeds, withdrawn)
+	if matches == nil {
+		matches = []KnockoutMatch{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tournament": t,
+		"players":    players,
+		"matches":    matches,
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBracketOrder(t *testing.T) {
+	tests := map[int][]int{
+		1: {1},
+		2: {1, 2},
+		4: {1, 4, 2, 3},
+		8: {1, 8, 4, 5, 2, 7, 3, 6},
+	}
+	for size, want := range tests {
+		if got := bracketOrder(size); !reflect.DeepEqual(got, want) {
+			t.Errorf("bracketOrder(%d) = %v, want %v", size, got, want)
+		}
+	}
+}
+
+// knockoutGames builds the games of a match between players 1 and 2, who
+// have white in alternate games starting with 1. An empty result is a game
+// still in progress; "A" marks the armageddon game, which 2 plays as white.
+func knockoutGames(results ...string) []TournamentPairing {
+	var games []TournamentPairing
+	for i, result := range results {
+		white, black := 1, 2
+		if i%2 == 1 {
+			white, black = 2, 1
+		}
+		armageddon := false
+		if len(result) > 0 && result[0] == 'A' {
+			white, black, armageddon, result = 2, 1, true, result[1:]
+		}
+		g := pairing(1, white, black, result)
+		g.GameNumber, g.Armageddon = i+1, armageddon
+		if result == "" {
+			g.Result = nil
+		}
+		games = append(games, g)
+	}
+	return games
+}
+
+func TestDecideMatch(t *testing.T) {
+	// Player 2 is the higher seed.
+	seeds := map[int]int{1: 2, 2: 1}
+	tests := []struct {
+		name       string
+		matchGames int
+		armageddon bool
+		games      []TournamentPairing
+		withdrawn  map[int]bool
+		winner     int
+		next       *knockoutGame
+	}{
+		{
+			name:       "clinched early",
+			matchGames: 4,
+			games:      knockoutGames(ResultWhiteWins, ResultBlackWins, ResultWhiteWins),
+			winner:     1,
+		},
+		{
+			name:       "not yet clinched",
+			matchGames: 4,
+			games:      knockoutGames(ResultWhiteWins, ResultBlackWins),
+			next:       &knockoutGame{White: 1, Black: 2, Number: 3},
+		},
+		{
+			name:       "colours alternate",
+			matchGames: 2,
+			games:      knockoutGames(ResultDraw),
+			next:       &knockoutGame{White: 2, Black: 1, Number: 2},
+		},
+		{
+			name:       "game in progress",
+			matchGames: 2,
+			games:      knockoutGames(ResultDraw, ""),
+		},
+		{
+			name:       "won on score",
+			matchGames: 2,
+			games:      knockoutGames(ResultDraw, ResultBlackWins),
+			winner:     1,
+		},
+		{
+			name:       "level goes to the higher seed",
+			matchGames: 2,
+			games:      knockoutGames(ResultWhiteWins, ResultWhiteWins),
+			winner:     2,
+		},
+		{
+			name:       "level goes to armageddon",
+			matchGames: 2,
+			armageddon: true,
+			games:      knockoutGames(ResultWhiteWins, ResultWhiteWins),
+			next:       &knockoutGame{White: 2, Black: 1, Number: 3, Armageddon: true},
+		},
+		{
+			name:       "armageddon win for white",
+			matchGames: 2,
+			armageddon: true,
+			games:      knockoutGames(ResultDraw, ResultDraw, "A"+ResultWhiteWins),
+			winner:     2,
+		},
+		{
+			name:       "armageddon draw goes to black",
+			matchGames: 2,
+			armageddon: true,
+			games:      knockoutGames(ResultDraw, ResultDraw, "A"+ResultDraw),
+			winner:     1,
+		},
+		{
+			name:       "opponent withdrew",
+			matchGames: 2,
+			games:      knockoutGames(ResultWhiteWins),
+			withdrawn:  map[int]bool{1: true},
+			winner:     2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tournament := &Tournament{MatchGames: tc.matchGames, Armageddon: tc.armageddon}
+			b := 2
+			m := &KnockoutMatch{PlayerA: 1, PlayerB: &b, Games: tc.games}
+
+			next, ok := decideMatch(tournament, m, seeds, tc.withdrawn)
+			winner := 0
+			if m.WinnerID != nil {
+				winner = *m.WinnerID
+			}
+			if winner != tc.winner {
+				t.Errorf("winner = %d, want %d", winner, tc.winner)
+			}
+			if ok != (tc.next != nil) || (ok && next != *tc.next) {
+				t.Errorf("next = %+v, %v, want %+v", next, ok, tc.next)
+			}
+		})
+	}
+}
+
+func TestDecideMatchBye(t *testing.T) {
+	m := &KnockoutMatch{PlayerA: 3}
+	if _, ok := decideMatch(&Tournament{MatchGames: 2}, m, nil, nil); ok || m.WinnerID == nil || *m.WinnerID != 3 {
+		t.Errorf("bye: winner %v, next %v", m.WinnerID, ok)
+	}
+}
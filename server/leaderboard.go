@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Leaderboards only list players with leaderboardMinGames rated games who
+// have played within leaderboardActivity. Most-improved lists need
+// improvedMinGames games in the period.
+const (
+	leaderboardMinGames = 10
+	leaderboardActivity = 30 * 24 * time.Hour
+	leaderboardSize     = 100
+	defaultLeaderboard  = 50
+	improvedMinGames    = 3
+	leaderboardCacheTTL = time.Minute
+)
+
+// improvedPeriods are the windows of the most-improved lists.
+var improvedPeriods = map[string]time.Duration{
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// LeaderboardService serves the rating leaderboards. Each list is built
+// once per leaderboardCacheTTL and shared by every request; only the
+// caller's own rank is looked up each time.
+type LeaderboardService struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	cache map[string]leaderboardCache
+}
+
+type leaderboardCache struct {
+	entries []LeaderboardEntry
+	builtAt time.Time
+}
+
+func NewLeaderboardService(db *sql.DB) *LeaderboardService {
+	return &LeaderboardService{
+		db:    db,
+		cache: make(map[string]leaderboardCache),
+	}
+}
+
+// cached returns the list stored under key, rebuilding it with build once
+// it is older than leaderboardCacheTTL.
+func (ls *LeaderboardService) cached(key string, build func() ([]LeaderboardEntry, error)) ([]LeaderboardEntry, error) {
+	ls.mu.Lock()
+	c, ok := ls.cache[key]
+	ls.mu.Unlock()
+	if ok && time.Since(c.builtAt) < leaderboardCacheTTL {
+		return c.entries, nil
+	}
+
+	entries, err := build()
+	if err != nil {
+		return nil, err
+	}
+	ls.mu.Lock()
+	ls.cache[key] = leaderboardCache{entries: entries, builtAt: time.Now()}
+	ls.mu.Unlock()
+	return entries, nil
+}
+
+// boardFromPath reads and checks the {speed} and {variant} path variables,
+// writing the error response if they are invalid.
+func boardFromPath(w http.ResponseWriter, r *http.Request) (speed, variant string, ok bool) {
+	vars := mux.Vars(r)
+	speed, variant = vars["speed"], vars["variant"]
+	valid := false
+	for _, s := range speeds {
+		valid = valid || s == speed
+	}
+	if !valid {
+		http.Error(w, "Unknown speed", http.StatusBadRequest)
+		return "", "", false
+	}
+	if _, err := LookupVariant(variant); err != nil || variant == VariantBughouse {
+		http.Error(w, "Unknown variant", http.StatusBadRequest)
+		return "", "", false
+	}
+	return speed, variant, true
+}
+
+// leaderboardLimit reads ?limit=, defaulting to defaultLeaderboard.
+func leaderboardLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultLeaderboard
+	}
+	return min(limit, leaderboardSize)
+}
+
+func scanLeaderboard(rows *sql.Rows) ([]LeaderboardEntry, error) {
+	defer rows.Close()
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var e LeaderboardEntry
+		if err := rows.Scan(&e.User.ID, &e.User.Name, &e.User.AvatarURL, &e.Rating, &e.Games, &e.Progress, &e.LastPlayedAt); err != nil {
+			return nil, err
+		}
+		e.Rank = len(entries) + 1
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetLeaderboard lists the top players of a speed and variant, with the
+// caller's own entry as "me". Their rank is 0 until they qualify.
+func (ls *LeaderboardService) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	speed, variant, ok := boardFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-leaderboardActivity)
+	entries, err := ls.cached("top:"+speed+":"+variant, func() ([]LeaderboardEntry, error) {
+		rows, err := ls.db.Query(`
+            SELECT u.id, u.name, u.avatar_url, ur.rating, ur.games, 0, ur.last_played_at
+            FROM user_ratings ur
+            JOIN users u ON u.id = ur.user_id
+            WHERE ur.speed = $1 AND ur.variant = $2 AND ur.games >= $3 AND ur.last_played_at >= $4
+            ORDER BY ur.rating DESC, ur.games DESC, u.id
+            LIMIT $5
+        `, speed, variant, leaderboardMinGames, since, leaderboardSize)
+		if err != nil {
+			return nil, err
+		}
+		return scanLeaderboard(rows)
+	})
+	if err != nil {
+		log.Println("Error fetching leaderboard:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	me, err := ls.ownEntry(user, speed, variant, since)
+	if err != nil {
+		log.Println("Error fetching own rank:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"speed":     speed,
+		"variant":   variant,
+		"min_games": leaderboardMinGames,
+		"players":   entries[:min(len(entries), leaderboardLimit(r))],
+		"me":        me,
+	})
+}
+
+// ownEntry returns user's rating with their rank among qualifying players,
+// or nil if they have never played a rated game of this kind.
+func (ls *LeaderboardService) ownEntry(user *User, speed, variant string, since time.Time) (*LeaderboardEntry, error) {
+	e := &LeaderboardEntry{User: *user}
+	err := ls.db.QueryRow(`
+        SELECT rating, games, last_played_at FROM user_ratings
+        WHERE user_id = $1 AND speed = $2 AND variant = $3
+    `, user.ID, speed, variant).Scan(&e.Rating, &e.Games, &e.LastPlayedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.Games < leaderboardMinGames || e.LastPlayedAt == nil || e.LastPlayedAt.Before(since) {
+		return e, nil
+	}
+
+	err = ls.db.QueryRow(`
+        SELECT COUNT(*) + 1 FROM user_ratings
+        WHERE speed = $1 AND variant = $2 AND games >= $3 AND last_played_at >= $4
+          AND (rating > $5 OR (rating = $5 AND games > $6) OR (rating = $5 AND games = $6 AND user_id < $7))
+    `, speed, variant, leaderboardMinGames, since, e.Rating, e.Games, user.ID).Scan(&e.Rank)
+	return e, err
+}
+
+// GetMostImproved lists the players who gained the most rating in a speed
+// and variant over ?period=week (the default) or month.
+func (ls *LeaderboardService) GetMostImproved(w http.ResponseWriter, r *http.Request) {
+	speed, variant, ok := boardFromPath(w, r)
+	if !ok {
+		return
+	}
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "week"
+	}
+	window, ok := improvedPeriods[period]
+	if !ok {
+		http.Error(w, "Period must be week or month", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := ls.cached("improved:"+period+":"+speed+":"+variant, func() ([]LeaderboardEntry, error) {
+		rows, err := ls.db.Query(`
+            SELECT u.id, u.name, u.avatar_url, ur.rating, h.games, h.gain, ur.last_played_at
+            FROM (
+                SELECT user_id, SUM(rating_after - rating_before) AS gain, COUNT(*) AS games
+                FROM rating_history
+                WHERE speed = $1 AND variant = $2 AND created_at >= $3
+                GROUP BY user_id
+                HAVING COUNT(*) >= $4
+            ) h
+            JOIN users u ON u.id = h.user_id
+            JOIN user_ratings ur ON ur.user_id = h.user_id AND ur.speed = $1 AND ur.variant = $2
+            WHERE h.gain > 0
+            ORDER BY h.gain DESC, u.id
+            LIMIT $5
+        `, speed, variant, time.Now().Add(-window), improvedMinGames, leaderboardSize)
+		if err != nil {
+			return nil, err
+		}
+		return scanLeaderboard(rows)
+	})
+	if err != nil {
+		log.Println("Error fetching most improved:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"speed":   speed,
+		"variant": variant,
+		"period":  period,
+		"players": entries[:min(len(entries), leaderboardLimit(r))],
+	})
+}
@@ -24,6 +24,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -50,10 +51,37 @@ func main() {
 	// Initialize services
 	authService := NewAuthService(db)
 	fmt.Println(authService.oauthConfig.ClientID)
-	gameService := NewGameService(db)
-	hub := NewHub(db, gameService)
+	userHub := NewUserHub()
+	presence := NewPresenceTracker(db, userHub)
+	notificationService := NewNotificationService(db, userHub)
+	gameService := NewGameService(db, notificationService)
+	achievementService := NewAchievementService(db, gameService, notificationService)
+	gameService.OnComplete(achievementService.GameCompleted)
+	puzzleService := NewPuzzleService(db, achievementService)
+	inviteService := NewInviteService(db, gameService, authService.jwtSecret)
+	blockService := NewBlockService(db)
+	chatService := NewChatService(db, chatConfigFromEnv())
+	hub := NewHub(db, gameService, inviteService, presence, blockService, chatService)
+	correspondenceService := NewCorrespondenceService(db, gameService, hub)
+	clubService := NewClubService(db, notificationService, hub)
+	challengeService := NewChallengeService(db, gameService, notificationService, blockService, clubService)
+	friendService := NewFriendService(db, presence, notificationService, blockService)
+	moderationService := NewModerationService(db, gameService, hub, userHub)
+	messageService := NewMessageService(db, userHub, blockService, chatService)
+	ratingService := NewRatingService(db)
+	leaderboardService := NewLeaderboardService(db)
+	simulService := NewSimulService(db, gameService, notificationService)
+	tournamentService := NewTournamentService(db, gameService, notificationService, presence, userHub, clubService)
 
 	go hub.Run()
+	go userHub.Run()
+	go correspondenceService.RunScheduler(time.Minute)
+	go hub.RunClocks(5 * time.Second)
+	go challengeService.RunExpiry(time.Minute)
+	go presence.RunIdleCheck(time.Minute)
+	go tournamentService.RunResults(3 * time.Second)
+	go simulService.RunResults(3 * time.Second)
+	go ratingService.RunRatings(10 * time.Second)
 
 	// Setup routes
 	r := mux.NewRouter()
@@ -70,9 +98,119 @@ func main() {
 	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ServeWs(hub, w, r, authService)
 	}).Methods("GET")
+	r.HandleFunc("/ws/user", func(w http.ResponseWriter, r *http.Request) {
+		ServeUserWs(userHub, presence, w, r, authService)
+	}).Methods("GET")
+	r.HandleFunc("/ws/club", func(w http.ResponseWriter, r *http.Request) {
+		ServeClubWs(hub, clubService, w, r, authService)
+	}).Methods("GET")
 
+	r.HandleFunc("/variants", gameService.ListVariants).Methods("GET")
 	r.HandleFunc("/games", authService.RequireAuth(gameService.GetUserGames)).Methods("GET")
+	r.HandleFunc("/games/my-turn", authService.RequireAuth(correspondenceService.GetTurnGames)).Methods("GET")
 	r.HandleFunc("/games/{id}", authService.RequireAuth(gameService.GetGamebyID)).Methods("GET")
+	r.HandleFunc("/games/{id}/pgn", authService.RequireAuth(gameService.ExportPGN)).Methods("GET")
+	r.HandleFunc("/games/{id}/invites", authService.RequireAuth(inviteService.CreateInvite)).Methods("POST")
+	r.HandleFunc("/games/{id}/moves", authService.RequireAuth(correspondenceService.SubmitMove)).Methods("POST")
+	r.HandleFunc("/games/{id}/conditional", authService.RequireAuth(correspondenceService.GetConditionalMoves)).Methods("GET")
+	r.HandleFunc("/games/{id}/conditional", authService.RequireAuth(correspondenceService.SetConditionalMoves)).Methods("PUT")
+	r.HandleFunc("/games/{id}/conditional", authService.RequireAuth(correspondenceService.DeleteConditionalMoves)).Methods("DELETE")
+	r.HandleFunc("/bughouse/{id}", authService.RequireAuth(gameService.GetBughouseMatch)).Methods("GET")
+
+	// Notification routes
+	r.HandleFunc("/notifications", authService.RequireAuth(notificationService.GetNotifications)).Methods("GET")
+	r.HandleFunc("/notifications/unread-count", authService.RequireAuth(notificationService.GetUnreadCount)).Methods("GET")
+	r.HandleFunc("/notifications/read-all", authService.RequireAuth(notificationService.MarkAllRead)).Methods("POST")
+	r.HandleFunc("/notifications/{id}/read", authService.RequireAuth(notificationService.MarkRead)).Methods("POST")
+
+	// Friend routes
+	r.HandleFunc("/friends", authService.RequireAuth(friendService.GetFriends)).Methods("GET")
+	r.HandleFunc("/friends/requests", authService.RequireAuth(friendService.GetFriendRequests)).Methods("GET")
+	r.HandleFunc("/friends/{id}", authService.RequireAuth(friendService.SendFriendRequest)).Methods("POST")
+	r.HandleFunc("/friends/{id}/accept", authService.RequireAuth(friendService.AcceptFriendRequest)).Methods("POST")
+	r.HandleFunc("/friends/{id}", authService.RequireAuth(friendService.RemoveFriend)).Methods("DELETE")
+	r.HandleFunc("/friends/{id}/presence", authService.RequireAuth(friendService.GetPresence)).Methods("GET")
+
+	// Block and report routes
+	r.HandleFunc("/blocks", authService.RequireAuth(blockService.GetBlocks)).Methods("GET")
+	r.HandleFunc("/blocks/{id}", authService.RequireAuth(blockService.BlockUser)).Methods("POST")
+	r.HandleFunc("/blocks/{id}", authService.RequireAuth(blockService.UnblockUser)).Methods("DELETE")
+	r.HandleFunc("/reports", authService.RequireAuth(blockService.ReportUser)).Methods("POST")
+
+	// Direct message routes
+	r.HandleFunc("/conversations", authService.RequireAuth(messageService.GetConversations)).Methods("GET")
+	r.HandleFunc("/conversations/{id}/messages", authService.RequireAuth(messageService.GetMessages)).Methods("GET")
+	r.HandleFunc("/conversations/{id}/read", authService.RequireAuth(messageService.MarkConversationRead)).Methods("POST")
+	r.HandleFunc("/users/{id}/messages", authService.RequireAuth(messageService.SendMessage)).Methods("POST")
+
+	// Tournament routes
+	r.HandleFunc("/tournaments", authService.RequireAuth(tournamentService.CreateTournament)).Methods("POST")
+	r.HandleFunc("/tournaments", authService.RequireAuth(tournamentService.ListTournaments)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}", authService.RequireAuth(tournamentService.GetTournament)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/standings", authService.RequireAuth(tournamentService.GetStandings)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/rounds/{round}", authService.RequireAuth(tournamentService.GetRound)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/rounds/{round}/pgn", authService.RequireAuth(tournamentService.ExportRoundPGN)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/crosstable", authService.RequireAuth(tournamentService.GetCrosstable)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/trf", authService.RequireAuth(tournamentService.ExportTRF)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/bracket", authService.RequireAuth(tournamentService.GetBracket)).Methods("GET")
+	r.HandleFunc("/tournaments/{id}/join", authService.RequireAuth(tournamentService.JoinTournament)).Methods("POST")
+	r.HandleFunc("/tournaments/{id}/join", authService.RequireAuth(tournamentService.LeaveTournament)).Methods("DELETE")
+	r.HandleFunc("/tournaments/{id}/start", authService.RequireAuth(tournamentService.StartTournament)).Methods("POST")
+	r.HandleFunc("/tournaments/{id}/pairings/{pairing}/result", authService.RequireAuth(tournamentService.SetResult)).Methods("POST")
+
+	// Leaderboard routes
+	r.HandleFunc("/leaderboards/{speed}/{variant}", authService.RequireAuth(leaderboardService.GetLeaderboard)).Methods("GET")
+	r.HandleFunc("/leaderboards/{speed}/{variant}/improved", authService.RequireAuth(leaderboardService.GetMostImproved)).Methods("GET")
+
+	// Simul routes
+	r.HandleFunc("/simuls", authService.RequireAuth(simulService.CreateSimul)).Methods("POST")
+	r.HandleFunc("/simuls", authService.RequireAuth(simulService.ListSimuls)).Methods("GET")
+	r.HandleFunc("/simuls/{id}", authService.RequireAuth(simulService.GetSimul)).Methods("GET")
+	r.HandleFunc("/simuls/{id}/join", authService.RequireAuth(simulService.JoinSimul)).Methods("POST")
+	r.HandleFunc("/simuls/{id}/join", authService.RequireAuth(simulService.LeaveSimul)).Methods("DELETE")
+	r.HandleFunc("/simuls/{id}/start", authService.RequireAuth(simulService.StartSimul)).Methods("POST")
+
+	// Club routes
+	r.HandleFunc("/clubs", authService.RequireAuth(clubService.CreateClub)).Methods("POST")
+	r.HandleFunc("/clubs", authService.RequireAuth(clubService.ListClubs)).Methods("GET")
+	r.HandleFunc("/clubs/{id}", authService.RequireAuth(clubService.GetClub)).Methods("GET")
+	r.HandleFunc("/clubs/{id}/members", authService.RequireAuth(clubService.GetMembers)).Methods("GET")
+	r.HandleFunc("/clubs/{id}/members/{user}", authService.RequireAuth(clubService.RemoveMember)).Methods("DELETE")
+	r.HandleFunc("/clubs/{id}/members/{user}/role", authService.RequireAuth(clubService.SetMemberRole)).Methods("PUT")
+	r.HandleFunc("/clubs/{id}/join", authService.RequireAuth(clubService.RequestToJoin)).Methods("POST")
+	r.HandleFunc("/clubs/{id}/join", authService.RequireAuth(clubService.LeaveClub)).Methods("DELETE")
+	r.HandleFunc("/clubs/{id}/requests", authService.RequireAuth(clubService.GetJoinRequests)).Methods("GET")
+	r.HandleFunc("/clubs/{id}/requests/{user}/accept", authService.RequireAuth(clubService.AcceptJoinRequest)).Methods("POST")
+	r.HandleFunc("/clubs/{id}/requests/{user}/decline", authService.RequireAuth(clubService.DeclineJoinRequest)).Methods("POST")
+	r.HandleFunc("/clubs/{id}/leaderboard", authService.RequireAuth(clubService.GetLeaderboard)).Methods("GET")
+
+	// Moderation routes
+	r.HandleFunc("/admin/reports", authService.RequireRole(RoleModerator, moderationService.GetReports)).Methods("GET")
+	r.HandleFunc("/admin/reports/{id}/resolve", authService.RequireRole(RoleModerator, moderationService.ResolveReport)).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/mute", authService.RequireRole(RoleModerator, moderationService.MuteUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/mute", authService.RequireRole(RoleModerator, moderationService.UnmuteUser)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id}/ban", authService.RequireRole(RoleModerator, moderationService.BanUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/ban", authService.RequireRole(RoleModerator, moderationService.UnbanUser)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id}/role", authService.RequireRole(RoleAdmin, moderationService.SetRole)).Methods("PUT")
+	r.HandleFunc("/admin/games/{id}/close", authService.RequireRole(RoleModerator, moderationService.CloseGame)).Methods("POST")
+	r.HandleFunc("/admin/log", authService.RequireRole(RoleModerator, moderationService.GetLog)).Methods("GET")
+
+	// Challenge routes
+	r.HandleFunc("/challenges", authService.RequireAuth(challengeService.CreateChallenge)).Methods("POST")
+	r.HandleFunc("/challenges", authService.RequireAuth(challengeService.ListChallenges)).Methods("GET")
+	r.HandleFunc("/challenges/{id}/accept", authService.RequireAuth(challengeService.AcceptChallenge)).Methods("POST")
+	r.HandleFunc("/challenges/{id}/decline", authService.RequireAuth(challengeService.DeclineChallenge)).Methods("POST")
+	r.HandleFunc("/challenges/{id}/cancel", authService.RequireAuth(challengeService.CancelChallenge)).Methods("POST")
+
+	// Puzzle routes
+	r.HandleFunc("/puzzles", authService.RequireAuth(puzzleService.CreatePuzzle)).Methods("POST")
+	r.HandleFunc("/puzzles/next", authService.RequireAuth(puzzleService.GetNextPuzzle)).Methods("GET")
+	r.HandleFunc("/puzzles/{id}", authService.RequireAuth(puzzleService.GetPuzzleByID)).Methods("GET")
+	r.HandleFunc("/puzzles/{id}/attempts", authService.RequireAuth(puzzleService.AttemptPuzzle)).Methods("POST")
+
+	// Achievement routes
+	r.HandleFunc("/achievements", authService.RequireAuth(achievementService.GetAchievements)).Methods("GET")
+	r.HandleFunc("/users/{id}/achievements", authService.RequireAuth(achievementService.GetUserAchievements)).Methods("GET")
 
 	// Enable CORS
 	r.Use(corsMiddleware)
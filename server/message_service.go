@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// MessageService handles direct messages between users. Each pair of users
+// shares one conversation; messages are pushed over /ws/user as they arrive
+// and are not copied into the notifications inbox.
+type MessageService struct {
+	db      *sql.DB
+	userHub *UserHub
+	blocks  *BlockService
+	chat    *ChatService
+}
+
+func NewMessageService(db *sql.DB, userHub *UserHub, blocks *BlockService, chat *ChatService) *MessageService {
+	return &MessageService{
+		db:      db,
+		userHub: userHub,
+		blocks:  blocks,
+		chat:    chat,
+	}
+}
+
+type SendMessageRequest struct {
+	Body string `json:"body"`
+}
+
+// conversationID returns the conversation between two users, creating it if
+// needed. The lower user ID is always stored as user_a.
+func (ms *MessageService) conversationID(a, b int) (int, error) {
+	if a > b {
+		a, b = b, a
+	}
+	var id int
+	err := ms.db.QueryRow(`
+        INSERT INTO conversations (user_a, user_b)
+        VALUES ($1, $2)
+        ON CONFLICT (user_a, user_b) DO UPDATE SET user_a = EXCLUDED.user_a
+        RETURNING id
+    `, a, b).Scan(&id)
+	return id, err
+}
+
+// otherParticipant returns the other user in conversationID, or
+// sql.ErrNoRows if userID is not part of it.
+func (ms *MessageService) otherParticipant(conversationID, userID int) (int, error) {
+	var other int
+	err := ms.db.QueryRow(`
+        SELECT CASE WHEN user_a = $2 THEN user_b ELSE user_a END
+        FROM conversations
+        WHERE id = $1 AND (user_a = $2 OR user_b = $2)
+    `, conversationID, userID).Scan(&other)
+	return other, err
+}
+
+// GetConversations lists the user's conversations, most recent first, with
+// the last message and the number unread.
+func (ms *MessageService) GetConversations(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	rows, err := ms.db.Query(`
+        SELECT c.id, c.updated_at, u.id, u.name, u.avatar_url,
+               m.id, m.sender_id, m.body, m.read_at, m.created_at,
+               (SELECT COUNT(*) FROM direct_messages d
+                WHERE d.conversation_id = c.id AND d.sender_id != $1 AND d.read_at IS NULL)
+        FROM conversations c
+        JOIN users u ON u.id = CASE WHEN c.user_a = $1 THEN c.user_b ELSE c.user_a END
+        LEFT JOIN LATERAL (
+            SELECT id, sender_id, body, read_at, created_at
+            FROM direct_messages
+            WHERE conversation_id = c.id
+            ORDER BY id DESC
+            LIMIT 1
+        ) m ON TRUE
+        WHERE c.user_a = $1 OR c.user_b = $1
+        ORDER BY c.updated_at DESC
+    `, user.ID)
+	if err != nil {
+		log.Println("Error fetching conversations:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		var last struct {
+			ID        sql.NullInt64
+			SenderID  sql.NullInt64
+			Body      sql.NullString
+			ReadAt    sql.NullTime
+			CreatedAt sql.NullTime
+		}
+		if err := rows.Scan(&c.ID, &c.UpdatedAt, &c.User.ID, &c.User.Name, &c.User.AvatarURL,
+			&last.ID, &last.SenderID, &last.Body, &last.ReadAt, &last.CreatedAt, &c.Unread); err != nil {
+			log.Println("Error scanning conversation:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if last.ID.Valid {
+			c.LastMessage = &DirectMessage{
+				ID:             int(last.ID.Int64),
+				ConversationID: c.ID,
+				SenderID:       int(last.SenderID.Int64),
+				Body:           last.Body.String,
+				CreatedAt:      last.CreatedAt.Time,
+			}
+			if last.ReadAt.Valid {
+				c.LastMessage.ReadAt = &last.ReadAt.Time
+			}
+		}
+		conversations = append(conversations, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// GetMessages pages backwards through a conversation. Pass the oldest ID
+// already loaded as ?before= to get the page before it.
+func (ms *MessageService) GetMessages(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	conversationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := ms.otherParticipant(conversationID, user.ID); err == sql.ErrNoRows {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Error fetching conversation:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	var before *int
+	if b, err := strconv.Atoi(r.URL.Query().Get("before")); err == nil {
+		before = &b
+	}
+
+	rows, err := ms.db.Query(`
+        SELECT id, conversation_id, sender_id, body, read_at, created_at
+        FROM direct_messages
+        WHERE conversation_id = $1 AND ($2::INTEGER IS NULL OR id < $2)
+        ORDER BY id DESC
+        LIMIT $3
+    `, conversationID, before, limit)
+	if err != nil {
+		log.Println("Error fetching messages:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	messages := []DirectMessage{}
+	for rows.Next() {
+		var m DirectMessage
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.Body, &m.ReadAt, &m.CreatedAt); err != nil {
+			log.Println("Error scanning message:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		messages = append(messages, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// SendMessage sends a direct message to the user in the path.
+func (ms *MessageService) SendMessage(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	recipientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || recipientID == user.ID {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	if err := ms.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, recipientID).Scan(&exists); err != nil || !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if ms.blocks.IsBlocked(user.ID, recipientID) {
+		http.Error(w, "You cannot message this user", http.StatusForbidden)
+		return
+	}
+	body, err := ms.chat.Filter(user, req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	conversationID, err := ms.conversationID(user.ID, recipientID)
+	if err != nil {
+		log.Println("Error creating conversation:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	msg := DirectMessage{ConversationID: conversationID, SenderID: user.ID, Body: body}
+	err = ms.db.QueryRow(`
+        WITH touched AS (
+            UPDATE conversations SET updated_at = CURRENT_TIMESTAMP WHERE id = $1
+        )
+        INSERT INTO direct_messages (conversation_id, sender_id, body)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `, conversationID, user.ID, body).Scan(&msg.ID, &msg.CreatedAt)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// The sender's own sockets get it too, so other open tabs stay in sync.
+	event := map[string]interface{}{"type": NotifyDirectMessage, "data": msg}
+	ms.userHub.Send(recipientID, event)
+	ms.userHub.Send(user.ID, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// MarkConversationRead marks the other user's messages read and sends them a
+// read receipt.
+func (ms *MessageService) MarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	conversationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+	otherID, err := ms.otherParticipant(conversationID, user.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching conversation:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID sql.NullInt64
+	err = ms.db.QueryRow(`
+        WITH marked AS (
+            UPDATE direct_messages
+            SET read_at = CURRENT_TIMESTAMP
+            WHERE conversation_id = $1 AND sender_id != $2 AND read_at IS NULL
+            RETURNING id
+        )
+        SELECT MAX(id) FROM marked
+    `, conversationID, user.ID).Scan(&lastID)
+	if err != nil {
+		log.Println("Error marking messages read:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if lastID.Valid {
+		ms.userHub.Send(otherID, map[string]interface{}{
+			"type": NotifyMessagesRead,
+			"data": map[string]interface{}{
+				"conversation_id": conversationID,
+				"reader_id":       user.ID,
+				"up_to_id":        lastID.Int64,
+			},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,29 +6,51 @@ import (
 )
 
 type User struct {
-	ID             int       `json:"id"`
-	GoogleID       string    `json:"google_id"`
-	Email          string    `json:"email"`
-	Name           string    `json:"name"`
-	Password       string    `json:"-"`
-	AvatarURL      string    `json:"avatar_url"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	ActiveGame     *string   `json:"active_game"`
-	DisconnectedAt time.Time `json:"disconnected_at"`
+	ID             int        `json:"id"`
+	GoogleID       string     `json:"google_id"`
+	Email          string     `json:"email"`
+	Name           string     `json:"name"`
+	Password       string     `json:"-"`
+	AvatarURL      string     `json:"avatar_url"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	ActiveGame     *string    `json:"active_game"`
+	DisconnectedAt time.Time  `json:"disconnected_at"`
+	Role           string     `json:"role"`
+	BannedUntil    *time.Time `json:"banned_until,omitempty"`
+	BanPermanent   bool       `json:"ban_permanent,omitempty"`
+	MutedUntil     *time.Time `json:"muted_until,omitempty"`
 }
 
 type Game struct {
-	ID            string           `json:"id"`
-	WhitePlayerID *int             `json:"white_player_id"`
-	BlackPlayerID *int             `json:"black_player_id"`
-	WhitePlayer   *User            `json:"white_player,omitempty"`
-	BlackPlayer   *User            `json:"black_player,omitempty"`
-	MetaData      *json.RawMessage `json:"metadata"`
-	Status        string           `json:"status"`
-	Winner        *string          `json:"winner"`
-	CreatedAt     time.Time        `json:"created_at"`
-	UpdatedAt     time.Time        `json:"updated_at"`
+	ID             string           `json:"id"`
+	WhitePlayerID  *int             `json:"white_player_id"`
+	BlackPlayerID  *int             `json:"black_player_id"`
+	WhitePlayer    *User            `json:"white_player,omitempty"`
+	BlackPlayer    *User            `json:"black_player,omitempty"`
+	MetaData       *json.RawMessage `json:"metadata"`
+	Variant        string           `json:"variant"`
+	InitialFEN     string           `json:"initial_fen"`
+	CurrentFEN     string           `json:"current_fen"`
+	DaysPerMove    int              `json:"days_per_move"`
+	MoveDeadline   *time.Time       `json:"move_deadline"`
+	ClockLimit     int              `json:"clock_limit"`
+	ClockIncrement int              `json:"clock_increment"`
+	Rated          bool             `json:"rated"`
+	Private        bool             `json:"private"`
+	TournamentID   *int             `json:"tournament_id,omitempty"`
+	WhiteTimeMs    *int64           `json:"white_time_ms,omitempty"`
+	BlackTimeMs    *int64           `json:"black_time_ms,omitempty"`
+	Berserkable    bool             `json:"berserkable,omitempty"`
+	WhiteBerserk   bool             `json:"white_berserk,omitempty"`
+	BlackBerserk   bool             `json:"black_berserk,omitempty"`
+	SimulID        *int             `json:"simul_id,omitempty"`
+	ClockRunning   string           `json:"clock_running,omitempty"`
+	TurnStartedAt  *time.Time       `json:"turn_started_at,omitempty"`
+	Status         string           `json:"status"`
+	Winner         *string          `json:"winner"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
 }
 
 type GameMove struct {
@@ -38,7 +60,332 @@ type GameMove struct {
 	MoveFrom   string    `json:"move_from"`
 	MoveTo     string    `json:"move_to"`
 	Piece      string    `json:"piece"`
+	IsDrop     bool      `json:"is_drop"`
 	FENAfter   string    `json:"fen_after"`
 	MoveNumber int       `json:"move_number"`
 	CreatedAt  time.Time `json:"created_at"`
 }
+
+// Challenge invites a specific user to a game. Status is one of pending,
+// accepted, declined, cancelled or expired.
+type Challenge struct {
+	ID           int `json:"id"`
+	ChallengerID int `json:"challenger_id"`
+	// TargetID is 0 for an open club challenge that any member may accept.
+	TargetID       int       `json:"target_id"`
+	ClubID         *int      `json:"club_id,omitempty"`
+	Variant        string    `json:"variant"`
+	Color          string    `json:"color"`
+	Rated          bool      `json:"rated"`
+	ClockLimit     int       `json:"clock_limit"`
+	ClockIncrement int       `json:"clock_increment"`
+	DaysPerMove    int       `json:"days_per_move"`
+	Status         string    `json:"status"`
+	GameID         *string   `json:"game_id"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// BughouseGame is the combined record of a bughouse match's two boards.
+type BughouseGame struct {
+	ID           string          `json:"id"`
+	BoardAGameID string          `json:"board_a_game_id"`
+	BoardBGameID string          `json:"board_b_game_id"`
+	Status       string          `json:"status"`
+	WinningTeam  *int            `json:"winning_team"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Boards       []BughouseBoard `json:"boards,omitempty"`
+}
+
+type BughouseBoard struct {
+	Game  *Game      `json:"game"`
+	Moves []GameMove `json:"moves"`
+}
+
+// Presence is what friends see of a user: State is one of offline, online,
+// playing or idle, and GameID is set while playing.
+type Presence struct {
+	UserID     int        `json:"user_id"`
+	State      string     `json:"state"`
+	GameID     string     `json:"game_id,omitempty"`
+	LastActive *time.Time `json:"last_active,omitempty"`
+}
+
+type Friend struct {
+	User     User     `json:"user"`
+	Presence Presence `json:"presence"`
+}
+
+// FriendRequest is a pending friendship, seen by either side.
+type FriendRequest struct {
+	From      User      `json:"from"`
+	To        User      `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Report is an entry in the moderation queue. Status is open or resolved.
+type Report struct {
+	ID          int        `json:"id"`
+	ReporterID  int        `json:"reporter_id"`
+	ReportedID  int        `json:"reported_id"`
+	Reason      string     `json:"reason"`
+	GameID      *string    `json:"game_id"`
+	ChatExcerpt string     `json:"chat_excerpt"`
+	Status      string     `json:"status"`
+	ResolvedBy  *int       `json:"resolved_by"`
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Notification is an inbox entry, also pushed over /ws/user. Data holds the
+// type-specific payload.
+type Notification struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"user_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	Read      bool            `json:"read"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ChatMessage is a stored game chat line. Name is the author's name.
+type ChatMessage struct {
+	ID        int       `json:"id"`
+	GameID    string    `json:"game_id,omitempty"`
+	ClubID    int       `json:"club_id,omitempty"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Conversation is a direct message thread as seen by one participant; User
+// is the other participant.
+type Conversation struct {
+	ID          int            `json:"id"`
+	User        User           `json:"user"`
+	LastMessage *DirectMessage `json:"last_message"`
+	Unread      int            `json:"unread"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type DirectMessage struct {
+	ID             int        `json:"id"`
+	ConversationID int        `json:"conversation_id"`
+	SenderID       int        `json:"sender_id"`
+	Body           string     `json:"body"`
+	ReadAt         *time.Time `json:"read_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type Tournament struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Format         string     `json:"format"`
+	Variant        string     `json:"variant"`
+	Rounds         int        `json:"rounds"`
+	CurrentRound   int        `json:"current_round"`
+	ClockLimit     int        `json:"clock_limit"`
+	ClockIncrement int        `json:"clock_increment"`
+	Status         string     `json:"status"`
+	CreatedBy      int        `json:"created_by"`
+	StartsAt       *time.Time `json:"starts_at"`
+	// Duration is the length of an arena in minutes.
+	Duration int        `json:"duration,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	// Cycles is 2 for a double round-robin.
+	Cycles int `json:"cycles,omitempty"`
+	// MatchGames is the length of a knockout mini-match; ties go to an
+	// armageddon game when Armageddon is set.
+	MatchGames int  `json:"match_games,omitempty"`
+	Armageddon bool `json:"armageddon,omitempty"`
+	// ClubID limits entry to the club's members.
+	ClubID    *int      `json:"club_id,omitempty"`
+	Players   int       `json:"players"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TournamentStanding is a player's line in the standings.
+type TournamentStanding struct {
+	Rank            int     `json:"rank"`
+	UserID          int     `json:"user_id"`
+	Name            string  `json:"name"`
+	Seed            int     `json:"seed"`
+	Score           float64 `json:"score"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonneborn_berger"`
+	Played          int     `json:"played"`
+	Withdrawn       bool    `json:"withdrawn"`
+	// OnFire marks an arena player on a winning streak, whose points double.
+	OnFire bool `json:"on_fire,omitempty"`
+}
+
+// TournamentPairing is one board of a round. A bye has no BlackID. In a
+// knockout each game of a mini-match is its own pairing on the same board.
+type TournamentPairing struct {
+	ID           int     `json:"id"`
+	TournamentID int     `json:"tournament_id"`
+	Round        int     `json:"round"`
+	Board        int     `json:"board"`
+	GameNumber   int     `json:"game_number"`
+	Armageddon   bool    `json:"armageddon,omitempty"`
+	WhiteID      int     `json:"white_id"`
+	BlackID      *int    `json:"black_id"`
+	GameID       *string `json:"game_id"`
+	Result       *string `json:"result"`
+	WhiteBerserk bool    `json:"white_berserk,omitempty"`
+	BlackBerserk bool    `json:"black_berserk,omitempty"`
+}
+
+// KnockoutMatch is one mini-match of a knockout bracket. PlayerA has white
+// in the first game; a bye has no PlayerB.
+type KnockoutMatch struct {
+	Round    int                 `json:"round"`
+	Board    int                 `json:"board"`
+	PlayerA  int                 `json:"player_a"`
+	PlayerB  *int                `json:"player_b"`
+	ScoreA   float64             `json:"score_a"`
+	ScoreB   float64             `json:"score_b"`
+	WinnerID *int                `json:"winner_id"`
+	Games    []TournamentPairing `json:"games"`
+}
+
+// CrosstableRow is a player's standing with their result in every game.
+type CrosstableRow struct {
+	TournamentStanding
+	Results []CrosstableResult `json:"results"`
+}
+
+// CrosstableResult is one game from a player's side. Points is "1", "0",
+// "½", or "+" for a bye.
+type CrosstableResult struct {
+	Round        int     `json:"round"`
+	OpponentID   *int    `json:"opponent_id"`
+	OpponentRank int     `json:"opponent_rank,omitempty"`
+	Color        string  `json:"color,omitempty"`
+	Points       string  `json:"points"`
+	GameID       *string `json:"game_id,omitempty"`
+}
+
+type Club struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	OwnerID     int    `json:"owner_id"`
+	Members     int    `json:"members"`
+	// Role is the current user's role, empty if they are not a member.
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ClubMember struct {
+	User     User      `json:"user"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type ClubJoinRequest struct {
+	User      User      `json:"user"`
+	Message   string    `json:"message"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ClubLeaderboardEntry is a member's best rating and the speed and variant
+// it was earned in. Rating is nil for members without a rated game.
+type ClubLeaderboardEntry struct {
+	Rank    int    `json:"rank"`
+	User    User   `json:"user"`
+	Rating  *int   `json:"rating"`
+	Games   int    `json:"games"`
+	Speed   string `json:"speed,omitempty"`
+	Variant string `json:"variant,omitempty"`
+}
+
+// UserAchievement is an achievement with when the user unlocked it, nil
+// while it is still locked.
+type UserAchievement struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	UnlockedAt  *time.Time `json:"unlocked_at"`
+}
+
+// LeaderboardEntry is a player's place on a rating leaderboard. Progress
+// is the rating gained over the period of a most-improved list.
+type LeaderboardEntry struct {
+	Rank         int        `json:"rank"`
+	User         User       `json:"user"`
+	Rating       int        `json:"rating"`
+	Games        int        `json:"games"`
+	Progress     int        `json:"progress,omitempty"`
+	LastPlayedAt *time.Time `json:"last_played_at"`
+}
+
+// Simul is a simultaneous exhibition: the host plays every participant at
+// once, always with HostColor.
+type Simul struct {
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	HostID         int       `json:"host_id"`
+	HostColor      string    `json:"host_color"`
+	Variant        string    `json:"variant"`
+	ClockLimit     int       `json:"clock_limit"`
+	ClockIncrement int       `json:"clock_increment"`
+	MaxPlayers     int       `json:"max_players"`
+	Status         string    `json:"status"`
+	Participants   int       `json:"participants"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SimulBoard is one participant's game in a simul. Result is the game's
+// winner once it has finished.
+type SimulBoard struct {
+	User       User    `json:"user"`
+	GameID     *string `json:"game_id"`
+	CurrentFEN string  `json:"current_fen,omitempty"`
+	Result     *string `json:"result"`
+}
+
+// SimulSummary counts the finished boards from the host's side.
+type SimulSummary struct {
+	Boards   int     `json:"boards"`
+	Finished int     `json:"finished"`
+	Wins     int     `json:"wins"`
+	Draws    int     `json:"draws"`
+	Losses   int     `json:"losses"`
+	Score    float64 `json:"score"`
+}
+
+// ModerationAction is an entry in the append-only moderation audit log.
+type ModerationAction struct {
+	ID           int             `json:"id"`
+	ModeratorID  int             `json:"moderator_id"`
+	Action       string          `json:"action"`
+	TargetUserID *int            `json:"target_user_id"`
+	TargetGameID *string         `json:"target_game_id"`
+	ReportID     *int            `json:"report_id"`
+	Details      json.RawMessage `json:"details"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// ConditionalMove queues Reply for when the opponent plays Move in a
+// correspondence game. Then holds the conditions for the move after that.
+type ConditionalMove struct {
+	Move  string            `json:"move"`
+	Reply string            `json:"reply"`
+	Then  []ConditionalMove `json:"then,omitempty"`
+}
+
+type Puzzle struct {
+	ID        int       `json:"id"`
+	FEN       string    `json:"fen"`
+	Moves     []string  `json:"moves"`
+	Rating    int       `json:"rating"`
+	Themes    []string  `json:"themes"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// User roles, lowest first.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// HasRole reports whether u holds role or a higher one.
+func (u *User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+func (u *User) IsBanned() bool {
+	return u.BanPermanent || (u.BannedUntil != nil && u.BannedUntil.After(time.Now()))
+}
+
+func (u *User) IsMuted() bool {
+	return u.MutedUntil != nil && u.MutedUntil.After(time.Now())
+}
+
+// Sanction tells the live sockets of a user about a ban or mute. With
+// ClubID set it only removes them from that club's chat room.
+type Sanction struct {
+	UserID     int
+	Banned     bool
+	MutedUntil *time.Time
+	ClubID     int
+}
+
+// ModerationService backs the /admin endpoints. Every action is written to
+// moderation_log in the same transaction as the change it records.
+type ModerationService struct {
+	db          *sql.DB
+	gameService *GameService
+	hub         *Hub
+	userHub     *UserHub
+}
+
+func NewModerationService(db *sql.DB, gameService *GameService, hub *Hub, userHub *UserHub) *ModerationService {
+	return &ModerationService{
+		db:          db,
+		gameService: gameService,
+		hub:         hub,
+		userHub:     userHub,
+	}
+}
+
+type SanctionRequest struct {
+	Minutes   int    `json:"minutes"`
+	Permanent bool   `json:"permanent"`
+	Reason    string `json:"reason"`
+}
+
+type ResolveReportRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type CloseGameRequest struct {
+	Winner string `json:"winner"`
+	Reason string `json:"reason"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// record appends an entry to the audit log.
+func record(tx *sql.Tx, entry ModerationAction, details interface{}) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO moderation_log (moderator_id, action, target_user_id, target_game_id, report_id, details)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `, entry.ModeratorID, entry.Action, entry.TargetUserID, entry.TargetGameID, entry.ReportID, payload)
+	return err
+}
+
+// inTx runs fn and commits, rolling back if fn fails.
+func (ms *ModerationService) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := ms.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// target parses the user in the path and checks the moderator outranks them.
+// It writes the error response and returns false when they may not act.
+func (ms *ModerationService) target(w http.ResponseWriter, r *http.Request) (int, bool) {
+	moderator := currentUser(r)
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || targetID == moderator.ID {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	var role string
+	err = ms.db.QueryRow(`SELECT COALESCE(role, 'user') FROM users WHERE id = $1`, targetID).Scan(&role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return 0, false
+	}
+	if err != nil {
+		log.Println("Error fetching user role:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return 0, false
+	}
+	if roleRank[role] >= roleRank[moderator.Role] {
+		http.Error(w, "You cannot moderate this user", http.StatusForbidden)
+		return 0, false
+	}
+	return targetID, true
+}
+
+// GetReports lists reports, open ones by default, oldest first.
+func (ms *ModerationService) GetReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+
+	rows, err := ms.db.Query(`
+        SELECT id, reporter_id, reported_id, reason, game_id, chat_excerpt, status, resolved_by, resolved_at, created_at
+        FROM reports
+        WHERE status = $1
+        ORDER BY created_at
+        LIMIT 100
+    `, status)
+	if err != nil {
+		log.Println("Error fetching reports:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var rep Report
+		if err := rows.Scan(&rep.ID, &rep.ReporterID, &rep.ReportedID, &rep.Reason, &rep.GameID,
+			&rep.ChatExcerpt, &rep.Status, &rep.ResolvedBy, &rep.ResolvedAt, &rep.CreatedAt); err != nil {
+			log.Println("Error scanning report:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		reports = append(reports, rep)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// ResolveReport closes an open report as resolved or dismissed.
+func (ms *ModerationService) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	moderator := currentUser(r)
+	reportID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid report ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ResolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Status == "" {
+		req.Status = "resolved"
+	}
+	if req.Status != "resolved" && req.Status != "dismissed" {
+		http.Error(w, "Status must be resolved or dismissed", http.StatusBadRequest)
+		return
+	}
+
+	found := true
+	err = ms.inTx(func(tx *sql.Tx) error {
+		var reportedID int
+		err := tx.QueryRow(`
+            UPDATE reports
+            SET status = $1, resolved_by = $2, resolved_at = CURRENT_TIMESTAMP
+            WHERE id = $3 AND status = 'open'
+            RETURNING reported_id
+        `, req.Status, moderator.ID, reportID).Scan(&reportedID)
+		if err == sql.ErrNoRows {
+			found = false
+		}
+		if err != nil {
+			return err
+		}
+		return record(tx, ModerationAction{
+			ModeratorID:  moderator.ID,
+			Action:       "report-" + req.Status,
+			TargetUserID: &reportedID,
+			ReportID:     &reportID,
+		}, map[string]string{"note": req.Note})
+	})
+	if !found {
+		http.Error(w, "Report not found or already closed", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error resolving report:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MuteUser stops the user in the path from chatting for the given minutes.
+func (ms *ModerationService) MuteUser(w http.ResponseWriter, r *http.Request) {
+	moderator := currentUser(r)
+	targetID, ok := ms.target(w, r)
+	if !ok {
+		return
+	}
+
+	var req SanctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Minutes <= 0 {
+		http.Error(w, "A positive number of minutes is required", http.StatusBadRequest)
+		return
+	}
+	until := time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+
+	err := ms.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET muted_until = $1 WHERE id = $2`, until, targetID); err != nil {
+			return err
+		}
+		return record(tx, ModerationAction{ModeratorID: moderator.ID, Action: "mute", TargetUserID: &targetID},
+			map[string]interface{}{"minutes": req.Minutes, "reason": req.Reason})
+	})
+	if err != nil {
+		log.Println("Error muting user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ms.hub.Sanctions <- Sanction{UserID: targetID, MutedUntil: &until}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ms *ModerationService) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	moderator := currentUser(r)
+	targetID, ok := ms.target(w, r)
+	if !ok {
+		return
+	}
+
+	err := ms.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET muted_until = NULL WHERE id = $1`, targetID); err != nil {
+			return err
+		}
+		return record(tx, ModerationAction{ModeratorID: moderator.ID, Action: "unmute", TargetUserID: &targetID}, struct{}{})
+	})
+	if err != nil {
+		log.Println("Error unmuting user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ms.hub.Sanctions <- Sanction{UserID: targetID}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BanUser bans the user in the path for the given minutes, or permanently,
+// and drops their open sockets.
+func (ms *ModerationService) BanUser(w http.ResponseWriter, r *http.Request) {
+	moderator := currentUser(r)
+	targetID, ok := ms.target(w, r)
+	if !ok {
+		return
+	}
+
+	var req SanctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case !req.Permanent && req.Minutes <= 0:
+		http.Error(w, "Give a positive number of minutes or a permanent ban", http.StatusBadRequest)
+		return
+	case req.Reason == "":
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+
+	var until *time.Time
+	if !req.Permanent {
+		t := time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+		until = &t
+	}
+
+	err := ms.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+            UPDATE users SET banned_until = $1, ban_permanent = $2, ban_reason = $3 WHERE id = $4
+        `, until, req.Permanent, req.Reason, targetID); err != nil {
+			return err
+		}
+		return record(tx, ModerationAction{ModeratorID: moderator.ID, Action: "ban", TargetUserID: &targetID},
+			map[string]interface{}{"minutes": req.Minutes, "permanent": req.Permanent, "reason": req.Reason})
+	})
+	if err != nil {
+		log.Println("Error banning user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ms.hub.Sanctions <- Sanction{UserID: targetID, Banned: true}
+	ms.userHub.Disconnect <- targetID
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ms *ModerationService) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	moderator := currentUser(r)
+	targetID, ok := ms.target(w, r)
+	if !ok {
+		return
+	}
+
+	err := ms.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+            UPDATE users SET banned_until = NULL, ban_permanent = FALSE, ban_reason = NULL WHERE id = $1
+        `, targetID); err != nil {
+			return err
+		}
+		return record(tx, ModerationAction{ModeratorID: moderator.ID, Action: "unban", TargetUserID: &targetID}, struct{}{})
+	})
+	if err != nil {
+		log.Println("Error unbanning user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRole changes the role of the user in the path. Admin only.
+func (ms *ModerationService) SetRole(w http.ResponseWriter, r *http.Request) {
+	admin := currentUser(r)
+	targetID, ok := ms.target(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	err := ms.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET role = $1 WHERE id = $2`, req.Role, targetID); err != nil {
+			return err
+		}
+		return record(tx, ModerationAction{ModeratorID: admin.ID, Action: "set-role", TargetUserID: &targetID},
+			map[string]string{"role": req.Role})
+	})
+	if err != nil {
+		log.Println("Error setting role:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CloseGame ends a game with the given result, a draw by default, and tells
+// anyone still connected to it.
+func (ms *ModerationService) CloseGame(w http.ResponseWriter, r *http.Request) {
+	moderator := currentUser(r)
+	gameID := mux.Vars(r)["id"]
+
+	var req CloseGameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Winner == "" {
+		req.Winner = "draw"
+	}
+	if req.Winner != "white" && req.Winner != "black" && req.Winner != "draw" {
+		http.Error(w, "Winner must be white, black or draw", http.StatusBadRequest)
+		return
+	}
+
+	found := true
+	err := ms.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+            UPDATE games
+            SET status = 'completed', winner = $1, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2 AND status != 'completed'
+        `, req.Winner, gameID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			found = false
+			return sql.ErrNoRows
+		}
+		return record(tx, ModerationAction{ModeratorID: moderator.ID, Action: "close-game", TargetGameID: &gameID},
+			map[string]string{"winner": req.Winner, "reason": req.Reason})
+	})
+	if !found {
+		http.Error(w, "Game not found or already over", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error closing game:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ms.gameService.completed(gameID)
+	ms.hub.Results <- GameResult{GameID: gameID, Winner: req.Winner, Reason: "Closed by a moderator"}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetLog returns the newest audit log entries, optionally for one user.
+func (ms *ModerationService) GetLog(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	var userID *int
+	if id, err := strconv.Atoi(r.URL.Query().Get("user")); err == nil {
+		userID = &id
+	}
+
+	rows, err := ms.db.Query(`
+        SELECT id, moderator_id, action, target_user_id, target_game_id, report_id, details, created_at
+        FROM moderation_log
+        WHERE $1::INTEGER IS NULL OR target_user_id = $1
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2
+    `, userID, limit)
+	if err != nil {
+		log.Println("Error fetching moderation log:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []ModerationAction{}
+	for rows.Next() {
+		var e ModerationAction
+		if err := rows.Scan(&e.ID, &e.ModeratorID, &e.Action, &e.TargetUserID, &e.TargetGameID,
+			&e.ReportID, &e.Details, &e.CreatedAt); err != nil {
+			log.Println("Error scanning moderation log:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type MoveFlag uint8
+
+const (
+	FlagCapture MoveFlag = 1 << iota
+	FlagEnPassant
+	FlagDoublePush
+	FlagCastle
+	FlagDrop
+)
+
+type Move struct {
+	From      Square
+	To        Square
+	Piece     Piece
+	Captured  Piece
+	Promotion PieceType
+	Flags     MoveFlag
+}
+
+func (m Move) IsCapture() bool {
+	return m.Flags&FlagCapture != 0
+}
+
+func (m Move) castlingSide() int {
+	if m.To.File() < m.From.File() {
+		return Queenside
+	}
+	return Kingside
+}
+
+// Dest is the square the moving piece lands on. It differs from To only for
+// castling, where To holds the rook.
+func (m Move) Dest() Square {
+	if m.Flags&FlagCastle != 0 {
+		king, _ := castlingTargets(m.Piece.Color(), m.castlingSide())
+		return king
+	}
+	return m.To
+}
+
+// UCI renders the move in long algebraic form, e.g. "e2e4", "e7e8q" or the
+// drop "P@e4". Castling comes out as king-takes-rook; see Position.MoveUCI.
+func (m Move) UCI() string {
+	if m.Flags&FlagDrop != 0 {
+		return NewPiece(m.Piece.Type(), White).String() + "@" + m.To.String()
+	}
+	s := m.From.String() + m.To.String()
+	if m.Promotion != NoPieceType {
+		s += string(pieceChars[m.Promotion])
+	}
+	return s
+}
+
+var (
+	knightOffsets = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingOffsets   = [][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	bishopDirs    = [][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	rookDirs      = [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	queenDirs     = append(append([][2]int{}, bishopDirs...), rookDirs...)
+)
+
+var promotionTypes = []PieceType{Queen, Rook, Bishop, Knight}
+
+func pawnDirection(c Color) int {
+	if c == White {
+		return 1
+	}
+	return -1
+}
+
+// slidingDirs returns the ray directions of a sliding piece type, or nil.
+func slidingDirs(t PieceType) [][2]int {
+	switch t {
+	case Bishop:
+		return bishopDirs
+	case Rook:
+		return rookDirs
+	case Queen:
+		return queenDirs
+	}
+	return nil
+}
+
+// AttacksFrom lists the squares attacked by the piece standing on sq.
+func (p *Position) AttacksFrom(sq Square) []Square {
+	piece := p.Board[sq]
+	var squares []Square
+
+	switch piece.Type() {
+	case Pawn:
+		dir := pawnDirection(piece.Color())
+		for _, df := range []int{-1, 1} {
+			if to, ok := sq.offset(df, dir); ok {
+				squares = append(squares, to)
+			}
+		}
+	case Knight, King:
+		offsets := knightOffsets
+		if piece.Type() == King {
+			offsets = kingOffsets
+		}
+		for _, o := range offsets {
+			if to, ok := sq.offset(o[0], o[1]); ok {
+				squares = append(squares, to)
+			}
+		}
+	case Bishop, Rook, Queen:
+		for _, d := range slidingDirs(piece.Type()) {
+			to, ok := sq.offset(d[0], d[1])
+			for ok {
+				squares = append(squares, to)
+				if p.Board[to] != NoPiece {
+					break
+				}
+				to, ok = to.offset(d[0], d[1])
+			}
+		}
+	}
+
+	return squares
+}
+
+// Attackers lists the squares of pieces of colour by that attack sq.
+func (p *Position) Attackers(sq Square, by Color) []Square {
+	var attackers []Square
+
+	dir := pawnDirection(by)
+	for _, df := range []int{-1, 1} {
+		if from, ok := sq.offset(df, -dir); ok && p.Board[from] == NewPiece(Pawn, by) {
+			attackers = append(attackers, from)
+		}
+	}
+	for _, o := range knightOffsets {
+		if from, ok := sq.offset(o[0], o[1]); ok && p.Board[from] == NewPiece(Knight, by) {
+			attackers = append(attackers, from)
+		}
+	}
+	for _, o := range kingOffsets {
+		if from, ok := sq.offset(o[0], o[1]); ok && p.Board[from] == NewPiece(King, by) {
+			attackers = append(attackers, from)
+		}
+	}
+	for _, d := range queenDirs {
+		diagonal := d[0] != 0 && d[1] != 0
+		from, ok := sq.offset(d[0], d[1])
+		for ok {
+			piece := p.Board[from]
+			if piece != NoPiece {
+				if piece.Color() == by {
+					t := piece.Type()
+					if t == Queen || (diagonal && t == Bishop) || (!diagonal && t == Rook) {
+						attackers = append(attackers, from)
+					}
+				}
+				break
+			}
+			from, ok = from.offset(d[0], d[1])
+		}
+	}
+
+	return attackers
+}
+
+func (p *Position) Attacked(sq Square, by Color) bool {
+	return len(p.Attackers(sq, by)) > 0
+}
+
+func (p *Position) InCheck() bool {
+	return p.rules().KingInCheck(p, p.Turn)
+}
+
+func (p *Position) pseudoMoves() []Move {
+	var moves []Move
+	us := p.Turn
+
+	for from := Square(0); from < 64; from++ {
+		piece := p.Board[from]
+		if piece == NoPiece || piece.Color() != us {
+			continue
+		}
+
+		if piece.Type() == Pawn {
+			moves = p.appendPawnMoves(moves, from, piece)
+			continue
+		}
+
+		for _, to := range p.AttacksFrom(from) {
+			target := p.Board[to]
+			if target == NoPiece {
+				moves = append(moves, Move{From: from, To: to, Piece: piece})
+			} else if target.Color() != us {
+				moves = append(moves, Move{From: from, To: to, Piece: piece, Captured: target, Flags: FlagCapture})
+			}
+		}
+
+		if piece.Type() == King {
+			moves = p.appendCastlingMoves(moves, from, piece)
+		}
+	}
+
+	return p.rules().AddMoves(p, moves)
+}
+
+func (p *Position) appendPawnMoves(moves []Move, from Square, piece Piece) []Move {
+	us := piece.Color()
+	dir := pawnDirection(us)
+	startRank, lastRank := 1, 7
+	if us == Black {
+		startRank, lastRank = 6, 0
+	}
+
+	add := func(m Move) {
+		if m.To.Rank() == lastRank {
+			for _, t := range promotionTypes {
+				m.Promotion = t
+				moves = append(moves, m)
+			}
+			return
+		}
+		moves = append(moves, m)
+	}
+
+	if to, ok := from.offset(0, dir); ok && p.Board[to] == NoPiece {
+		add(Move{From: from, To: to, Piece: piece})
+		if from.Rank() == startRank {
+			if to2, ok := to.offset(0, dir); ok && p.Board[to2] == NoPiece {
+				moves = append(moves, Move{From: from, To: to2, Piece: piece, Flags: FlagDoublePush})
+			}
+		}
+	}
+
+	for _, to := range p.AttacksFrom(from) {
+		target := p.Board[to]
+		if target != NoPiece && target.Color() != us {
+			add(Move{From: from, To: to, Piece: piece, Captured: target, Flags: FlagCapture})
+		} else if to == p.EnPassant {
+			moves = append(moves, Move{From: from, To: to, Piece: piece, Captured: NewPiece(Pawn, us.Other()), Flags: FlagCapture | FlagEnPassant})
+		}
+	}
+
+	return moves
+}
+
+func (p *Position) appendCastlingMoves(moves []Move, from Square, piece Piece) []Move {
+	us := piece.Color()
+	for side, rook := range p.CastlingRooks[us] {
+		if rook == NoSquare || p.Board[rook] != NewPiece(Rook, us) {
+			continue
+		}
+		kingTo, rookTo := castlingTargets(us, side)
+		if p.castlingPathClear(from, rook, kingTo, rookTo) {
+			// Castling is encoded as the king capturing its own rook, which
+			// stays unambiguous in Chess960 start positions.
+			moves = append(moves, Move{From: from, To: rook, Piece: piece, Flags: FlagCastle})
+		}
+	}
+	return moves
+}
+
+// castlingTargets returns where the king and rook end up after castling.
+func castlingTargets(c Color, side int) (king, rook Square) {
+	rank := backRank(c)
+	if side == Kingside {
+		return SquareAt(6, rank), SquareAt(5, rank)
+	}
+	return SquareAt(2, rank), SquareAt(3, rank)
+}
+
+// castlingPathClear applies the Chess960 rules, which cover standard chess
+// too: every square the king and rook travel over must be empty apart from
+// the two castling pieces, and no square the king stands on or crosses may
+// be attacked while the rook is still in place.
+func (p *Position) castlingPathClear(king, rook, kingTo, rookTo Square) bool {
+	us := p.Board[king].Color()
+	board := *p
+	board.Board[king] = NoPiece
+	board.Board[rook] = NoPiece
+
+	for _, span := range [][2]Square{{king, kingTo}, {rook, rookTo}} {
+		lo, hi := min(span[0], span[1]), max(span[0], span[1])
+		for sq := lo; sq <= hi; sq++ {
+			if board.Board[sq] != NoPiece {
+				return false
+			}
+		}
+	}
+
+	board.Board[rook] = p.Board[rook]
+	rules := p.rules()
+	lo, hi := min(king, kingTo), max(king, kingTo)
+	for sq := lo; sq <= hi; sq++ {
+		if rules.SquareAttacked(&board, sq, us.Other()) {
+			return false
+		}
+	}
+	return true
+}
+
+// LegalMoves returns the pseudo-legal moves the variant allows, which in
+// standard chess are those that do not leave the mover's king in check.
+func (p *Position) LegalMoves() []Move {
+	rules := p.rules()
+	var legal []Move
+	for _, m := range p.pseudoMoves() {
+		if rules.Legal(p, p.Play(m), m) {
+			legal = append(legal, m)
+		}
+	}
+	return legal
+}
+
+// Play returns the position after m. It does not check legality.
+func (p *Position) Play(m Move) *Position {
+	next := *p
+	us := p.Turn
+	piece := m.Piece
+
+	if m.Flags&FlagDrop != 0 {
+		next.Board[m.To] = piece
+		next.Pockets[us][piece.Type()]--
+	} else if m.Flags&FlagCastle != 0 {
+		next.Board[m.From] = NoPiece
+		kingTo, rookTo := castlingTargets(us, m.castlingSide())
+		next.Board[m.To] = NoPiece
+		next.Board[kingTo] = piece
+		next.Board[rookTo] = NewPiece(Rook, us)
+	} else {
+		next.Board[m.From] = NoPiece
+		if m.Flags&FlagEnPassant != 0 {
+			next.Board[SquareAt(m.To.File(), m.From.Rank())] = NoPiece
+		}
+		if m.Promotion != NoPieceType {
+			piece = NewPiece(m.Promotion, us)
+		}
+		next.Board[m.To] = piece
+	}
+
+	if piece.Type() == King {
+		next.CastlingRooks[us] = [2]Square{NoSquare, NoSquare}
+	}
+	for c := range next.CastlingRooks {
+		for side, rook := range next.CastlingRooks[c] {
+			if rook == m.From || rook == m.To {
+				next.CastlingRooks[c][side] = NoSquare
+			}
+		}
+	}
+
+	next.EnPassant = NoSquare
+	if m.Flags&FlagDoublePush != 0 {
+		next.EnPassant = SquareAt(m.From.File(), (m.From.Rank()+m.To.Rank())/2)
+	}
+
+	if m.Piece.Type() == Pawn || m.IsCapture() {
+		next.HalfMove = 0
+	} else {
+		next.HalfMove++
+	}
+	if us == Black {
+		next.FullMove++
+	}
+	next.Turn = us.Other()
+
+	p.rules().AfterMove(p, &next, m)
+	return &next
+}
+
+// MoveUCI renders m for this position: standard games write castling as the
+// king's two-square step, Chess960 games as king-takes-rook.
+func (p *Position) MoveUCI(m Move) string {
+	if m.Flags&FlagCastle != 0 && !p.Chess960 {
+		return m.From.String() + m.Dest().String()
+	}
+	return m.UCI()
+}
+
+// ParseUCI resolves a long algebraic move string against the legal moves.
+// King-takes-rook castling is accepted in every game.
+func (p *Position) ParseUCI(s string) (Move, error) {
+	s = strings.TrimSpace(s)
+	for _, m := range p.LegalMoves() {
+		if strings.EqualFold(p.MoveUCI(m), s) || (m.Flags&FlagCastle != 0 && strings.EqualFold(m.UCI(), s)) {
+			return m, nil
+		}
+	}
+	return Move{}, fmt.Errorf("illegal move %q in %s", s, p.FEN())
+}
+
+// SAN renders a legal move in standard algebraic notation.
+func (p *Position) SAN(m Move) string {
+	var san string
+	switch {
+	case m.Flags&FlagDrop != 0:
+		san = m.UCI()
+	case m.Flags&FlagCastle != 0 && m.castlingSide() == Kingside:
+		san = "O-O"
+	case m.Flags&FlagCastle != 0:
+		san = "O-O-O"
+	case m.Piece.Type() == Pawn:
+		if m.IsCapture() {
+			san = string(rune('a'+m.From.File())) + "x"
+		}
+		san += m.To.String()
+		if m.Promotion != NoPieceType {
+			san += "=" + NewPiece(m.Promotion, White).String()
+		}
+	default:
+		san = NewPiece(m.Piece.Type(), White).String()
+		sameFile, sameRank, ambiguous := false, false, false
+		for _, other := range p.LegalMoves() {
+			if other.Piece != m.Piece || other.To != m.To || other.From == m.From || other.Flags&FlagCastle != 0 {
+				continue
+			}
+			ambiguous = true
+			if other.From.File() == m.From.File() {
+				sameFile = true
+			}
+			if other.From.Rank() == m.From.Rank() {
+				sameRank = true
+			}
+		}
+		if ambiguous {
+			switch {
+			case !sameFile:
+				san += string(rune('a' + m.From.File()))
+			case !sameRank:
+				san += string(rune('1' + m.From.Rank()))
+			default:
+				san += m.From.String()
+			}
+		}
+		if m.IsCapture() {
+			san += "x"
+		}
+		san += m.To.String()
+	}
+
+	next := p.Play(m)
+	if next.IsCheckmate() {
+		san += "#"
+	} else if next.InCheck() {
+		san += "+"
+	}
+	return san
+}
+
+func (p *Position) IsCheckmate() bool {
+	return p.InCheck() && len(p.LegalMoves()) == 0
+}
+
+func (p *Position) IsStalemate() bool {
+	return !p.InCheck() && len(p.LegalMoves()) == 0
+}
+
+// Perft counts the leaf nodes of the legal move tree to the given depth.
+// Positions where the game is over have no moves.
+func Perft(p *Position, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	if over, _ := p.Outcome(); over {
+		return 0
+	}
+	moves := p.LegalMoves()
+	if depth == 1 {
+		return len(moves)
+	}
+	nodes := 0
+	for _, m := range moves {
+		nodes += Perft(p.Play(m), depth-1)
+	}
+	return nodes
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+type perftCase struct {
+	name    string
+	variant string
+	fen     string
+	nodes   []int
+}
+
+func runPerft(t *testing.T, cases []perftCase) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pos, err := NewGamePosition(tc.variant, tc.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tc.nodes {
+				if got := Perft(pos, i+1); got != want {
+					t.Errorf("perft(%d) = %d, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPerft(t *testing.T) {
+	runPerft(t, []perftCase{
+		{"startpos", VariantStandard, StartFEN, []int{20, 400, 8902, 197281}},
+		{"kiwipete", VariantStandard, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+		{"position 3", VariantStandard, "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+		{"position 4", VariantStandard, "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+		{"position 5", VariantStandard, "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", []int{44, 1486, 62379}},
+		{"position 6", VariantStandard, "r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10", []int{46, 2079, 89890}},
+	})
+}
+
+func TestPerftChess960(t *testing.T) {
+	runPerft(t, []perftCase{
+		{"bqnb1rkr", VariantChess960, "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", []int{21, 528, 12189}},
+		{"2nnrbkr", VariantChess960, "2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9", []int{21, 807, 18002}},
+		{"b1q1rrkb", VariantChess960, "b1q1rrkb/pppppppp/3nn3/8/P7/1PPP4/4PPPP/BQNNRKRB w GE - 1 9", []int{20, 479, 10471}},
+		{"qbbnnrkr", VariantChess960, "qbbnnrkr/2pp2pp/p7/1p2pp2/8/P3PP2/1PPP1KPP/QBBNNR1R w hf - 0 9", []int{22, 593, 13440}},
+	})
+}
+
+func TestChess960StartPositions(t *testing.T) {
+	for _, index := range []int{0, 518, 959} {
+		fen, err := Chess960FEN(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos, err := NewGamePosition(VariantChess960, fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Perft(pos, 2); got != 400 {
+			t.Errorf("start %d %s: perft(2) = %d, want 400", index, fen, got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Notification types. The payload of each is stored in Notification.Data.
+const (
+	NotifyChallenge          = "challenge"
+	NotifyChallengeAccepted  = "challenge-accepted"
+	NotifyChallengeDeclined  = "challenge-declined"
+	NotifyChallengeCancelled = "challenge-cancelled"
+	NotifyChallengeExpired   = "challenge-expired"
+	NotifyYourTurn           = "your-turn"
+	NotifyFriendOnline       = "friend-online"
+	NotifyPresence           = "presence"
+	NotifyFriendRequest      = "friend-request"
+	NotifyFriendAccepted     = "friend-accepted"
+	NotifyTournamentStarting = "tournament-starting"
+	NotifyTournamentRound    = "tournament-round"
+	NotifyTournamentFinished = "tournament-finished"
+	NotifyClubJoinRequest    = "club-join-request"
+	NotifyClubJoinAccepted   = "club-join-accepted"
+	NotifyClubJoinDeclined   = "club-join-declined"
+	NotifySimulStarted       = "simul-started"
+	NotifySimulFinished      = "simul-finished"
+	NotifyAchievement        = "achievement-unlocked"
+)
+
+// Events that are only pushed, never stored as notifications.
+const (
+	NotifyDirectMessage    = "direct-message"
+	NotifyMessagesRead     = "messages-read"
+	NotifyArenaLeaderboard = "arena-leaderboard"
+	NotifySimulBoard       = "simul-board"
+)
+
+// NotificationService stores notifications and pushes them to the user's
+// open /ws/user sockets, so events missed while offline can be read later.
+type NotificationService struct {
+	db      *sql.DB
+	userHub *UserHub
+}
+
+func NewNotificationService(db *sql.DB, userHub *UserHub) *NotificationService {
+	return &NotificationService{
+		db:      db,
+		userHub: userHub,
+	}
+}
+
+// Notify stores a notification for userID and pushes it if they are online.
+func (ns *NotificationService) Notify(userID int, notificationType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Println("Failed to marshal notification:", err)
+		return
+	}
+
+	n := Notification{UserID: userID, Type: notificationType, Data: payload}
+	err = ns.db.QueryRow(`
+        INSERT INTO notifications (user_id, type, data)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `, userID, notificationType, payload).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		log.Println("Failed to store notification:", err)
+	}
+
+	ns.userHub.Send(userID, n)
+}
+
+// Push sends an event to userID's open sockets without storing it.
+func (ns *NotificationService) Push(userID int, eventType string, data interface{}) {
+	ns.userHub.Send(userID, map[string]interface{}{"type": eventType, "data": data})
+}
+
+func (ns *NotificationService) ListNotifications(userID int, unreadOnly bool, limit int) ([]Notification, error) {
+	rows, err := ns.db.Query(`
+        SELECT id, user_id, type, data, read, created_at
+        FROM notifications
+        WHERE user_id = $1 AND (NOT $2 OR NOT read)
+        ORDER BY created_at DESC
+        LIMIT $3
+    `, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Data, &n.Read, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+func (ns *NotificationService) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+
+	notifications, err := ns.ListNotifications(user.ID, r.URL.Query().Get("unread") == "true", limit)
+	if err != nil {
+		log.Println("Error fetching notifications:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+func (ns *NotificationService) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var count int
+	err := ns.db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND NOT read`, user.ID).Scan(&count)
+	if err != nil {
+		log.Println("Error counting notifications:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unread": count})
+}
+
+func (ns *NotificationService) MarkRead(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := ns.db.Exec(`UPDATE notifications SET read = TRUE WHERE id = $1 AND user_id = $2`, id, user.ID)
+	if err != nil {
+		log.Println("Error marking notification read:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ns *NotificationService) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	if _, err := ns.db.Exec(`UPDATE notifications SET read = TRUE WHERE user_id = $1 AND NOT read`, user.ID); err != nil {
+		log.Println("Error marking notifications read:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BuildPGN replays the stored moves of a game from its initial position and
+// renders them as PGN.
+func BuildPGN(game *Game, moves []GameMove) (string, error) {
+	return BuildEventPGN(game, moves, "Casual game", "-")
+}
+
+// BuildEventPGN is BuildPGN with the Event and Round tags set, for games
+// played in a tournament.
+func BuildEventPGN(game *Game, moves []GameMove, event, round string) (string, error) {
+	initialFEN := game.InitialFEN
+	if initialFEN == "" {
+		initialFEN = StartFEN
+	}
+	variant, err := LookupVariant(game.Variant)
+	if err != nil {
+		return "", err
+	}
+	pos, err := ParseVariantFEN(variant, initialFEN)
+	if err != nil {
+		return "", err
+	}
+
+	white, black := "?", "?"
+	if game.WhitePlayer != nil && game.WhitePlayer.Name != "" {
+		white = game.WhitePlayer.Name
+	}
+	if game.BlackPlayer != nil && game.BlackPlayer.Name != "" {
+		black = game.BlackPlayer.Name
+	}
+	result := pgnResult(game)
+
+	var sb strings.Builder
+	tag := func(name, value string) {
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", name, strings.ReplaceAll(value, `"`, `\"`))
+	}
+	tag("Event", event)
+	tag("Site", "ChessBackend")
+	tag("Date", game.CreatedAt.Format("2006.01.02"))
+	tag("Round", round)
+	tag("White", white)
+	tag("Black", black)
+	tag("Result", result)
+	if name := variant.PGNName(); name != "" {
+		tag("Variant", name)
+	}
+	if initialFEN != StartFEN {
+		tag("SetUp", "1")
+		tag("FEN", initialFEN)
+	}
+	sb.WriteString("\n")
+
+	var tokens []string
+	for i, gm := range moves {
+		if gm.IsDrop && variant.Name() == VariantBughouse {
+			creditDrop(pos, gm)
+		}
+		m, err := findStoredMove(pos, gm)
+		if err != nil {
+			return "", fmt.Errorf("move %d: %v", i+1, err)
+		}
+		if pos.Turn == White {
+			tokens = append(tokens, fmt.Sprintf("%d.", pos.FullMove))
+		} else if i == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d...", pos.FullMove))
+		}
+		tokens = append(tokens, pos.SAN(m))
+		pos = pos.Play(m)
+	}
+	tokens = append(tokens, result)
+
+	line := 0
+	for i, token := range tokens {
+		if i > 0 {
+			if line+1+len(token) > 80 {
+				sb.WriteString("\n")
+				line = 0
+			} else {
+				sb.WriteString(" ")
+				line++
+			}
+		}
+		sb.WriteString(token)
+		line += len(token)
+	}
+	sb.WriteString("\n")
+
+	return sb.String(), nil
+}
+
+func pgnResult(game *Game) string {
+	if game.Winner == nil {
+		return "*"
+	}
+	switch *game.Winner {
+	case "white":
+		return "1-0"
+	case "black":
+		return "0-1"
+	case "draw":
+		return "1/2-1/2"
+	}
+	return "*"
+}
+
+// creditDrop puts the dropped piece into the mover's pocket. Bughouse
+// pockets are filled from the partner board, which a replay of one board
+// never sees.
+func creditDrop(pos *Position, gm GameMove) {
+	if gm.Piece == "" {
+		return
+	}
+	if piece, ok := pieceFromChar(gm.Piece[0]); ok {
+		pos.Pockets[pos.Turn][piece.Type()]++
+	}
+}
+
+// findStoredMove matches a game_moves row to a legal move. Rows do not keep
+// the promotion piece, so it is recovered from the stored FEN.
+func findStoredMove(pos *Position, gm GameMove) (Move, error) {
+	var candidates []Move
+	for _, m := range pos.LegalMoves() {
+		uci := pos.MoveUCI(m)
+		if uci[:4] == gm.MoveFrom+gm.MoveTo || (m.Flags&FlagCastle != 0 && m.UCI() == gm.MoveFrom+gm.MoveTo) {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return Move{}, fmt.Errorf("illegal move %s%s in %s", gm.MoveFrom, gm.MoveTo, pos.FEN())
+	}
+
+	board := strings.Fields(gm.FENAfter)
+	for _, m := range candidates {
+		if len(board) > 0 && strings.Fields(pos.Play(m).FEN())[0] == board[0] {
+			return m, nil
+		}
+	}
+	return candidates[0], nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildPGNBughousePartnerDrop(t *testing.T) {
+	game := &Game{ID: "match-a", Variant: VariantBughouse}
+	pos, err := NewGamePosition(VariantBughouse, StartFEN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var moves []GameMove
+	for _, uci := range []string{"e2e4", "e7e5", "N@f3"} {
+		m, err := pos.ParseUCI(uci)
+		if err != nil {
+			// The knight came from the partner board.
+			pos.Pockets[pos.Turn][Knight]++
+			if m, err = pos.ParseUCI(uci); err != nil {
+				t.Fatal(err)
+			}
+		}
+		next := pos.Play(m)
+		moves = append(moves, GameMove{
+			MoveFrom: uci[:2],
+			MoveTo:   uci[2:4],
+			Piece:    m.Piece.String(),
+			IsDrop:   m.Flags&FlagDrop != 0,
+			FENAfter: next.FEN(),
+		})
+		pos = next
+	}
+
+	pgn, err := BuildPGN(game, moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pgn, "1. e4 e5 2. N@f3 *") {
+		t.Errorf("unexpected movetext in\n%s", pgn)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Presence states.
+const (
+	PresenceOffline = "offline"
+	PresenceOnline  = "online"
+	PresencePlaying = "playing"
+	PresenceIdle    = "idle"
+)
+
+// idleAfter is how long an online user may be silent before showing as idle.
+const idleAfter = 5 * time.Minute
+
+type presenceState struct {
+	sockets    int
+	games      map[string]int
+	lastActive time.Time
+	// reported is the state friends were last told about.
+	reported Presence
+}
+
+// PresenceTracker follows which users are connected and what they are
+// playing. It is fed by the game Hub and the /ws/user sockets and pushes
+// every change to the user's friends.
+type PresenceTracker struct {
+	db      *sql.DB
+	userHub *UserHub
+
+	mu    sync.Mutex
+	users map[int]*presenceState
+}
+
+func NewPresenceTracker(db *sql.DB, userHub *UserHub) *PresenceTracker {
+	return &PresenceTracker{
+		db:      db,
+		userHub: userHub,
+		users:   make(map[int]*presenceState),
+	}
+}
+
+func (pt *PresenceTracker) UserConnected(userID int) {
+	pt.update(userID, func(s *presenceState) { s.sockets++ })
+}
+
+func (pt *PresenceTracker) UserDisconnected(userID int) {
+	pt.update(userID, func(s *presenceState) { s.sockets-- })
+}
+
+func (pt *PresenceTracker) JoinedGame(userID int, gameID string) {
+	pt.update(userID, func(s *presenceState) { s.games[gameID]++ })
+}
+
+func (pt *PresenceTracker) LeftGame(userID int, gameID string) {
+	pt.update(userID, func(s *presenceState) {
+		if s.games[gameID]--; s.games[gameID] <= 0 {
+			delete(s.games, gameID)
+		}
+	})
+}
+
+// Touch records activity, which clears the idle state.
+func (pt *PresenceTracker) Touch(userID int) {
+	pt.update(userID, func(s *presenceState) {})
+}
+
+// Get returns the current presence of userID.
+func (pt *PresenceTracker) Get(userID int) Presence {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return pt.presenceOf(userID, pt.users[userID], time.Now())
+}
+
+func (pt *PresenceTracker) update(userID int, change func(s *presenceState)) {
+	pt.mu.Lock()
+	s, ok := pt.users[userID]
+	if !ok {
+		s = &presenceState{games: make(map[string]int)}
+		pt.users[userID] = s
+	}
+	change(s)
+	now := time.Now()
+	s.lastActive = now
+
+	p := pt.presenceOf(userID, s, now)
+	wasOffline := s.reported.State == "" || s.reported.State == PresenceOffline
+	changed := p.State != s.reported.State || p.GameID != s.reported.GameID
+	if wasOffline && p.State == PresenceOffline {
+		changed = false
+	}
+	eventType := NotifyPresence
+	if wasOffline {
+		eventType = NotifyFriendOnline
+	}
+	s.reported = p
+	if s.sockets <= 0 && len(s.games) == 0 {
+		delete(pt.users, userID)
+	}
+	pt.mu.Unlock()
+
+	if changed {
+		go pt.pushToFriends(eventType, p)
+	}
+}
+
+// presenceOf must be called with mu held.
+func (pt *PresenceTracker) presenceOf(userID int, s *presenceState, now time.Time) Presence {
+	p := Presence{UserID: userID, State: PresenceOffline}
+	if s == nil {
+		return p
+	}
+	lastActive := s.lastActive
+	p.LastActive = &lastActive
+
+	switch {
+	case len(s.games) > 0:
+		p.State = PresencePlaying
+		for gameID := range s.games {
+			p.GameID = gameID
+			break
+		}
+	case s.sockets <= 0:
+		p.State = PresenceOffline
+	case now.Sub(s.lastActive) > idleAfter:
+		p.State = PresenceIdle
+	default:
+		p.State = PresenceOnline
+	}
+	return p
+}
+
+// RunIdleCheck reports users who went idle, checking every interval.
+func (pt *PresenceTracker) RunIdleCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		var idle []Presence
+
+		pt.mu.Lock()
+		for userID, s := range pt.users {
+			p := pt.presenceOf(userID, s, now)
+			if p.State != s.reported.State {
+				s.reported = p
+				idle = append(idle, p)
+			}
+		}
+		pt.mu.Unlock()
+
+		for _, p := range idle {
+			pt.pushToFriends(NotifyPresence, p)
+		}
+	}
+}
+
+// pushToFriends sends p to every friend's user socket. Presence changes are
+// too frequent to keep in the notifications inbox.
+func (pt *PresenceTracker) pushToFriends(eventType string, p Presence) {
+	friends, err := friendIDs(pt.db, p.UserID)
+	if err != nil {
+		log.Println("Failed to load friends for presence:", err)
+		return
+	}
+	for _, friendID := range friends {
+		pt.userHub.Send(friendID, map[string]interface{}{
+			"type": eventType,
+			"data": p,
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+type PuzzleService struct {
+	db           *sql.DB
+	achievements *AchievementService
+}
+
+type ImportPuzzleRequest struct {
+	FEN    string   `json:"fen"`
+	Moves  []string `json:"moves"`
+	Rating int      `json:"rating"`
+}
+
+// AttemptPuzzleRequest is the line a user played, in the same alternating
+// UCI form as the puzzle's moves.
+type AttemptPuzzleRequest struct {
+	Moves []string `json:"moves"`
+}
+
+func NewPuzzleService(db *sql.DB, achievements *AchievementService) *PuzzleService {
+	return &PuzzleService{db: db, achievements: achievements}
+}
+
+// ImportPuzzle validates the solution line, tags its themes and stores it.
+func (ps *PuzzleService) ImportPuzzle(fen string, moves []string, rating int) (*Puzzle, error) {
+	themes, err := DetectThemes(fen, moves)
+	if err != nil {
+		return nil, err
+	}
+	if rating <= 0 {
+		rating = 1500
+	}
+
+	puzzle := &Puzzle{
+		FEN:    fen,
+		Moves:  moves,
+		Rating: rating,
+		Themes: themes,
+	}
+	err = ps.db.QueryRow(`
+        INSERT INTO puzzles (fen, moves, rating, themes)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at
+    `, fen, strings.Join(moves, " "), rating, pq.Array(themes)).Scan(&puzzle.ID, &puzzle.CreatedAt)
+
+	return puzzle, err
+}
+
+func (ps *PuzzleService) scanPuzzle(row *sql.Row) (*Puzzle, error) {
+	puzzle := &Puzzle{}
+	var moves string
+	err := row.Scan(&puzzle.ID, &puzzle.FEN, &moves, &puzzle.Rating, pq.Array(&puzzle.Themes), &puzzle.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	puzzle.Moves = strings.Fields(moves)
+	return puzzle, nil
+}
+
+func (ps *PuzzleService) GetPuzzle(id int) (*Puzzle, error) {
+	return ps.scanPuzzle(ps.db.QueryRow(`
+        SELECT id, fen, moves, rating, themes, created_at
+        FROM puzzles WHERE id = $1
+    `, id))
+}
+
+// NextPuzzle picks a random puzzle, restricted to a theme when one is given.
+func (ps *PuzzleService) NextPuzzle(theme string) (*Puzzle, error) {
+	return ps.scanPuzzle(ps.db.QueryRow(`
+        SELECT id, fen, moves, rating, themes, created_at
+        FROM puzzles
+        WHERE $1 = '' OR $1 = ANY(themes)
+        ORDER BY RANDOM()
+        LIMIT 1
+    `, theme))
+}
+
+func (ps *PuzzleService) CreatePuzzle(w http.ResponseWriter, r *http.Request) {
+	var req ImportPuzzleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.FEN == "" || len(req.Moves) == 0 {
+		http.Error(w, "FEN and moves are required", http.StatusBadRequest)
+		return
+	}
+
+	puzzle, err := ps.ImportPuzzle(req.FEN, req.Moves, req.Rating)
+	if err != nil {
+		log.Println("Error importing puzzle:", err)
+		http.Error(w, "Invalid puzzle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(puzzle)
+}
+
+func (ps *PuzzleService) GetNextPuzzle(w http.ResponseWriter, r *http.Request) {
+	theme := r.URL.Query().Get("theme")
+
+	puzzle, err := ps.NextPuzzle(theme)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No puzzles found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Error fetching puzzle:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(puzzle)
+}
+
+func (ps *PuzzleService) GetPuzzleByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid puzzle ID", http.StatusBadRequest)
+		return
+	}
+
+	puzzle, err := ps.GetPuzzle(id)
+	if err != nil {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(puzzle)
+}
+
+// AttemptPuzzle records whether the user's line solves the puzzle and
+// returns it with their current solving streak. Only the first attempt at a
+// puzzle counts, and the solution is shown once that attempt has failed.
+func (ps *PuzzleService) AttemptPuzzle(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid puzzle ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AttemptPuzzleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	puzzle, err := ps.GetPuzzle(id)
+	if err != nil {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+
+	solved := len(req.Moves) == len(puzzle.Moves)
+	for i := 0; solved && i < len(req.Moves); i++ {
+		solved = strings.EqualFold(req.Moves[i], puzzle.Moves[i])
+	}
+
+	result, err := ps.db.Exec(`
+        INSERT INTO puzzle_attempts (user_id, puzzle_id, solved) VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, puzzle_id) DO NOTHING
+    `, user.ID, puzzle.ID, solved)
+	if err != nil {
+		log.Println("Error recording puzzle attempt:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	counted, _ := result.RowsAffected()
+
+	firstSolved := solved
+	if counted == 0 {
+		if err := ps.db.QueryRow(`
+            SELECT solved FROM puzzle_attempts WHERE user_id = $1 AND puzzle_id = $2
+        `, user.ID, puzzle.ID).Scan(&firstSolved); err != nil {
+			log.Println("Error fetching puzzle attempt:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	streak := ps.achievements.PuzzleAttempted(user.ID)
+
+	resp := map[string]interface{}{
+		"solved":  solved,
+		"counted": counted > 0,
+		"streak":  streak,
+	}
+	if !firstSolved {
+		resp["solution"] = puzzle.Moves
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
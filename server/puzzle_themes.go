@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	ThemeFork             = "fork"
+	ThemePin              = "pin"
+	ThemeSkewer           = "skewer"
+	ThemeDiscoveredAttack = "discoveredAttack"
+	ThemeBackRankMate     = "backRankMate"
+	ThemeMate             = "mate"
+	ThemePromotion        = "promotion"
+	ThemeSacrifice        = "sacrifice"
+)
+
+// DetectThemes replays a puzzle solution from fen and tags the tactical
+// patterns it contains. The side to move in fen is the solver, and moves
+// alternate between solver and opponent in UCI notation.
+func DetectThemes(fen string, solution []string) ([]string, error) {
+	pos, err := ParseFEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	if len(solution) == 0 {
+		return nil, fmt.Errorf("puzzle has no solution moves")
+	}
+
+	solver := pos.Turn
+	startBalance := pos.Material(solver) - pos.Material(solver.Other())
+	themes := map[string]bool{}
+
+	for i, uci := range solution {
+		move, err := pos.ParseUCI(uci)
+		if err != nil {
+			return nil, fmt.Errorf("solution move %d: %v", i+1, err)
+		}
+		before := pos
+		pos = pos.Play(move)
+
+		if i%2 == 1 {
+			balance := pos.Material(solver) - pos.Material(solver.Other())
+			if balance <= startBalance-2 {
+				themes[ThemeSacrifice] = true
+			}
+			continue
+		}
+
+		if move.Promotion != NoPieceType {
+			themes[ThemePromotion] = true
+		}
+		if pos.IsCheckmate() {
+			continue
+		}
+		if isFork(pos, move.Dest()) {
+			themes[ThemeFork] = true
+		}
+		pin, skewer := lineTactics(pos, move.Dest())
+		if pin {
+			themes[ThemePin] = true
+		}
+		if skewer {
+			themes[ThemeSkewer] = true
+		}
+		if isDiscoveredAttack(before, pos, move) {
+			themes[ThemeDiscoveredAttack] = true
+		}
+	}
+
+	if pos.IsCheckmate() {
+		themes[ThemeMate] = true
+		themes[fmt.Sprintf("mateIn%d", (len(solution)+1)/2)] = true
+		if isBackRankMate(pos) {
+			themes[ThemeBackRankMate] = true
+		}
+	}
+
+	tags := make([]string, 0, len(themes))
+	for theme := range themes {
+		tags = append(tags, theme)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// isWorthAttacking reports whether an attack by attacker on target wins
+// something: a check, a higher-valued piece, or an undefended piece.
+func isWorthAttacking(pos *Position, attacker Piece, target Square) bool {
+	victim := pos.Board[target]
+	switch {
+	case victim.Type() == King:
+		return true
+	case victim.Type() == Pawn:
+		return false
+	case victim.Type().Value() > attacker.Type().Value():
+		return true
+	}
+	return !pos.Attacked(target, victim.Color())
+}
+
+func isFork(pos *Position, sq Square) bool {
+	attacker := pos.Board[sq]
+	targets := 0
+	for _, target := range pos.AttacksFrom(sq) {
+		victim := pos.Board[target]
+		if victim == NoPiece || victim.Color() == attacker.Color() {
+			continue
+		}
+		if isWorthAttacking(pos, attacker, target) {
+			targets++
+		}
+	}
+	return targets >= 2
+}
+
+// lineTactics looks along each ray of the slider on sq for two enemy pieces
+// in a row. A more valuable piece behind is a pin, a less valuable one a
+// skewer.
+func lineTactics(pos *Position, sq Square) (pin, skewer bool) {
+	slider := pos.Board[sq]
+	for _, d := range slidingDirs(slider.Type()) {
+		var found []Piece
+		to, ok := sq.offset(d[0], d[1])
+		for ok && len(found) < 2 {
+			if piece := pos.Board[to]; piece != NoPiece {
+				if piece.Color() == slider.Color() {
+					break
+				}
+				found = append(found, piece)
+			}
+			to, ok = to.offset(d[0], d[1])
+		}
+		if len(found) < 2 {
+			continue
+		}
+
+		front, back := found[0], found[1]
+		if back.Type() == King || (front.Type() != King && back.Type().Value() > front.Type().Value()) {
+			pin = true
+		} else if back.Type() != Pawn && (front.Type() == King || front.Type().Value() > back.Type().Value()) {
+			skewer = true
+		}
+	}
+	return pin, skewer
+}
+
+// isDiscoveredAttack reports whether moving a piece off move.From opened a
+// line for another of the solver's sliders onto a target worth attacking.
+func isDiscoveredAttack(before, after *Position, move Move) bool {
+	us := before.Turn
+	for sq := Square(0); sq < 64; sq++ {
+		slider := after.Board[sq]
+		if sq == move.Dest() || slider == NoPiece || slider.Color() != us {
+			continue
+		}
+		for _, d := range slidingDirs(slider.Type()) {
+			passed := false
+			to, ok := sq.offset(d[0], d[1])
+			for ok && after.Board[to] == NoPiece {
+				if to == move.From {
+					passed = true
+				}
+				to, ok = to.offset(d[0], d[1])
+			}
+			if !ok || !passed {
+				continue
+			}
+			victim := after.Board[to]
+			if victim.Color() != us && isWorthAttacking(after, slider, to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBackRankMate expects pos to be checkmate.
+func isBackRankMate(pos *Position) bool {
+	loser := pos.Turn
+	king := pos.kingSquare(loser)
+	backRank, forward := 0, 1
+	if loser == Black {
+		backRank, forward = 7, -1
+	}
+	if king.Rank() != backRank {
+		return false
+	}
+
+	checkers := pos.Attackers(king, loser.Other())
+	if len(checkers) != 1 {
+		return false
+	}
+	checker := pos.Board[checkers[0]]
+	if (checker.Type() != Rook && checker.Type() != Queen) || checkers[0].Rank() != backRank {
+		return false
+	}
+
+	for df := -1; df <= 1; df++ {
+		sq, ok := king.offset(df, forward)
+		if !ok {
+			continue
+		}
+		piece := pos.Board[sq]
+		if (piece == NoPiece || piece.Color() != loser) && !pos.Attacked(sq, loser.Other()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"time"
+)
+
+// Speeds group time controls for ratings, by the estimated game length of
+// limit + 40 × increment seconds.
+const (
+	SpeedBullet         = "bullet"
+	SpeedBlitz          = "blitz"
+	SpeedRapid          = "rapid"
+	SpeedClassical      = "classical"
+	SpeedCorrespondence = "correspondence"
+)
+
+var speeds = []string{SpeedBullet, SpeedBlitz, SpeedRapid, SpeedClassical, SpeedCorrespondence}
+
+// Elo parameters. New players move faster until they have played
+// provisionalGames rated games.
+const (
+	defaultRating    = 1500
+	provisionalGames = 30
+	provisionalK     = 40
+	establishedK     = 20
+)
+
+// gameSpeed returns the speed a game is rated in, or "" for untimed games,
+// which are not rated.
+func gameSpeed(clockLimit, clockIncrement, daysPerMove int) string {
+	if daysPerMove > 0 {
+		return SpeedCorrespondence
+	}
+	estimate := clockLimit + 40*clockIncrement
+	switch {
+	case estimate <= 0:
+		return ""
+	case estimate < 180:
+		return SpeedBullet
+	case estimate < 480:
+		return SpeedBlitz
+	case estimate < 1500:
+		return SpeedRapid
+	}
+	return SpeedClassical
+}
+
+// eloUpdate returns the new ratings after a game in which white scored
+// score (1, 0.5 or 0). Each side's K depends on their own game count.
+func eloUpdate(white, black, whiteGames, blackGames int, score float64) (int, int) {
+	expected := 1 / (1 + math.Pow(10, float64(black-white)/400))
+	k := func(games int) float64 {
+		if games < provisionalGames {
+			return provisionalK
+		}
+		return establishedK
+	}
+	newWhite := white + int(math.Round(k(whiteGames)*(score-expected)))
+	newBlack := black + int(math.Round(k(blackGames)*((1-score)-(1-expected))))
+	return newWhite, newBlack
+}
+
+// RatingService keeps a rating per user, speed and variant, updated from
+// rated games once they complete.
+type RatingService struct {
+	db *sql.DB
+}
+
+func NewRatingService(db *sql.DB) *RatingService {
+	return &RatingService{db: db}
+}
+
+// RunRatings rates newly completed games every interval.
+func (rs *RatingService) RunRatings(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := rs.rateGames(); err != nil {
+			log.Println("Ratings:", err)
+		}
+	}
+}
+
+// rateGames rates completed rated games not yet rated, oldest first.
+func (rs *RatingService) rateGames() error {
+	rows, err := rs.db.Query(`
+        SELECT id FROM games
+        WHERE rated AND rated_at IS NULL AND status = 'completed'
+          AND winner IN ('white', 'black', 'draw') AND black_player_id IS NOT NULL
+          AND COALESCE(variant, 'standard') <> $1
+        ORDER BY updated_at
+        LIMIT 200
+    `, VariantBughouse)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := rs.rateGame(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateGame updates both players' ratings for a completed game and records
+// the change in rating_history. Marking the game rated in the same
+// transaction makes it count once.
+func (rs *RatingService) rateGame(gameID string) error {
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var white, black, clockLimit, clockIncrement, daysPerMove int
+	var winner, variant string
+	err = tx.QueryRow(`
+        UPDATE games SET rated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND rated_at IS NULL
+        RETURNING white_player_id, black_player_id, winner, COALESCE(variant, 'standard'),
+                  COALESCE(clock_limit, 0), COALESCE(clock_increment, 0), COALESCE(days_per_move, 0)
+    `, gameID).Scan(&white, &black, &winner, &variant, &clockLimit, &clockIncrement, &daysPerMove)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	speed := gameSpeed(clockLimit, clockIncrement, daysPerMove)
+	if speed == "" {
+		return tx.Commit()
+	}
+
+	whiteRating, whiteGames, err := lockRating(tx, white, speed, variant)
+	if err != nil {
+		return err
+	}
+	blackRating, blackGames, err := lockRating(tx, black, speed, variant)
+	if err != nil {
+		return err
+	}
+
+	score := 0.5
+	switch winner {
+	case "white":
+		score = 1
+	case "black":
+		score = 0
+	}
+	newWhite, newBlack := eloUpdate(whiteRating, blackRating, whiteGames, blackGames, score)
+
+	for _, change := range []struct{ userID, before, after int }{
+		{white, whiteRating, newWhite},
+		{black, blackRating, newBlack},
+	} {
+		if _, err := tx.Exec(`
+            UPDATE user_ratings
+            SET rating = $1, games = games + 1, last_played_at = CURRENT_TIMESTAMP
+            WHERE user_id = $2 AND speed = $3 AND variant = $4
+        `, change.after, change.userID, speed, variant); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+            INSERT INTO rating_history (user_id, speed, variant, game_id, rating_before, rating_after)
+            VALUES ($1, $2, $3, $4, $5, $6)
+        `, change.userID, speed, variant, gameID, change.before, change.after); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockRating returns userID's rating and game count, creating the rating at
+// the default, and locks it for the rest of tx.
+func lockRating(tx *sql.Tx, userID int, speed, variant string) (rating, games int, err error) {
+	if _, err := tx.Exec(`
+        INSERT INTO user_ratings (user_id, speed, variant, rating)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, speed, variant) DO NOTHING
+    `, userID, speed, variant, defaultRating); err != nil {
+		return 0, 0, err
+	}
+	err = tx.QueryRow(`
+        SELECT rating, games FROM user_ratings
+        WHERE user_id = $1 AND speed = $2 AND variant = $3
+        FOR UPDATE
+    `, userID, speed, variant).Scan(&rating, &games)
+	return rating, games, err
+}
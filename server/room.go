@@ -159,40 +159,149 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	Conn   *websocket.Conn
-	RoomID string
-	Send   chan []byte
-	User   *User
-	Color  string
+	Conn    *websocket.Conn
+	RoomID  string
+	Send    chan []byte
+	User    *User
+	Color   string
+	Options GameOptions
+	// ClubID is set for a member of a club chat room.
+	ClubID int
+	// Invite is the token from ?invite= that seats a player in a private
+	// game.
+	Invite string
+	// Blocked holds the users this client has blocked; their chat is not
+	// delivered to it.
+	Blocked map[int]bool
+}
+
+// ClientMessage is a raw websocket message tagged with the client that sent it.
+type ClientMessage struct {
+	Client *Client
+	Data   []byte
 }
 
 type Room struct {
 	ID          string
 	db          *sql.DB
 	gameService *GameService
-	Clients     map[*Client]bool
-	Broadcast   chan []byte
-	Register    chan *Client
-	Unregister  chan *Client
+	chat        *ChatService
+	position    *Position
+	// result is set when the game was ended from outside the board, e.g. by
+	// the partner board of a bughouse match.
+	result     string
+	match      *BughouseMatch
+	Clients    map[*Client]bool
+	Broadcast  chan []byte
+	Incoming   chan ClientMessage
+	Register   chan *Client
+	Unregister chan *Client
+	Pocket     chan Piece
+	Terminate  chan GameResult
+	Remote     chan RemoteMove
+	Sanctions  chan Sanction
+	// correspondence rooms outlive their connections.
+	correspondence bool
+	tournament     bool
+	// clubID is set for a club chat room, which has no game.
+	clubID int
+	// simulID and simulHost are set for a simul board; the host is sent
+	// every move of every board.
+	simulID   int
+	simulHost int
+	// premoves holds at most one queued move per colour.
+	premoves map[string]premove
+	clock    *gameClock
+	// berserkable games let each player halve their clock before moving.
+	berserkable bool
+	flagTimer   *time.Timer
+	flagCheck   chan struct{}
+	// stop is closed by the hub when it drops the room.
+	stop chan struct{}
+}
+
+// premove is a move sent while the opponent was to move. It is played as
+// soon as the opponent has moved, if it is legal then.
+type premove struct {
+	client *Client
+	data   MoveData
+	drop   bool
+}
+
+// GameResult ends a game from outside its room.
+type GameResult struct {
+	GameID string
+	Winner string
+	Reason string
 }
 
-func NewRoom(id string, db *sql.DB, gameService *GameService) *Room {
+// RemoteMove is a move submitted over REST rather than the room's socket.
+// The room replies on Result.
+type RemoteMove struct {
+	GameID string
+	User   *User
+	Color  string
+	Data   MoveData
+	Drop   bool
+	Result chan error
+}
+
+func NewRoom(id string, db *sql.DB, gameService *GameService, chat *ChatService) *Room {
 	return &Room{
 		ID:          id,
 		db:          db,
 		gameService: gameService,
+		chat:        chat,
 		Clients:     make(map[*Client]bool),
 		Broadcast:   make(chan []byte),
+		Incoming:    make(chan ClientMessage),
 		Register:    make(chan *Client),
 		Unregister:  make(chan *Client),
+		// Buffered so linked rooms never block on each other.
+		Pocket:    make(chan Piece, 64),
+		Terminate: make(chan GameResult, 1),
+		Remote:    make(chan RemoteMove, 8),
+		Sanctions: make(chan Sanction, 8),
+		premoves:  make(map[string]premove),
+		flagCheck: make(chan struct{}, 1),
+		stop:      make(chan struct{}),
 	}
 }
 
+// loadPosition sets up the room's board from the stored game.
+func (r *Room) loadPosition(game *Game) error {
+	fen := game.CurrentFEN
+	if fen == "" {
+		fen = StartFEN
+	}
+	pos, err := NewGamePosition(game.Variant, fen)
+	if err != nil {
+		return err
+	}
+	r.position = pos
+	r.correspondence = game.DaysPerMove > 0
+	r.tournament = game.TournamentID != nil
+	r.berserkable = game.Berserkable
+	if game.SimulID != nil {
+		r.simulID = *game.SimulID
+		r.simulHost = r.gameService.SimulHost(r.simulID)
+	}
+	r.clock = newGameClock(game, pos)
+	r.armFlag()
+	if game.Status == "completed" && game.Winner != nil {
+		r.result = *game.Winner
+	}
+	return nil
+}
+
 type Message struct {
 	Type       string `json:"type"`
 	From       string `json:"from,omitempty"`
@@ -204,6 +313,28 @@ type Message struct {
 	GameStatus string `json:"game_status,omitempty"`
 	Winner     string `json:"winner,omitempty"`
 	GameID     string `json:"gameid,omitempty"`
+	Promotion  string `json:"promotion,omitempty"`
+	Piece      string `json:"piece,omitempty"`
+	// Move is the client's move object, stored as game metadata.
+	Move json.RawMessage `json:"move,omitempty"`
+}
+
+// MoveData is the move carried by "move" messages. "drop" messages use Piece
+// and To, e.g. {"type": "drop", "piece": "N", "to": "f7"}.
+type MoveData struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Promotion string `json:"promotion,omitempty"`
+	Piece     string `json:"piece,omitempty"`
+}
+
+// PositionUpdate is broadcast after every accepted move so clients can
+// follow server-side state such as crazyhouse pockets.
+type PositionUpdate struct {
+	Type    string                    `json:"type"`
+	FEN     string                    `json:"fen"`
+	Pockets map[string]map[string]int `json:"pockets,omitempty"`
+	Clock   *ClockState               `json:"clock,omitempty"`
 }
 
 func (r *Room) Run() {
@@ -211,6 +342,10 @@ func (r *Room) Run() {
 		select {
 		case client := <-r.Register:
 			r.Clients[client] = true
+			if r.clubID != 0 {
+				r.sendChatHistory(client)
+				continue
+			}
 
 			// Send current room status to the newly joined client
 			statusMsg := map[string]interface{}{
@@ -222,12 +357,20 @@ func (r *Room) Run() {
 
 			statusBytes, _ := json.Marshal(statusMsg)
 			client.Send <- statusBytes
+			r.sendChatHistory(client)
+			if r.clock != nil {
+				state := r.clock.state(time.Now())
+				client.sendJSON(map[string]interface{}{"type": "clock", "clock": state})
+			}
 
 		case client := <-r.Unregister:
 			if _, ok := r.Clients[client]; ok {
 				delete(r.Clients, client)
 				close(client.Send)
 			}
+			if pm, ok := r.premoves[client.Color]; ok && pm.client == client {
+				delete(r.premoves, client.Color)
+			}
 
 		case msg := <-r.Broadcast:
 			var payload Message
@@ -235,7 +378,61 @@ func (r *Room) Run() {
 				continue
 			}
 
-			r.handleMessage(payload, msg)
+			r.handleMessage(nil, payload, msg)
+
+		case in := <-r.Incoming:
+			var payload Message
+			if err := json.Unmarshal(in.Data, &payload); err != nil {
+				continue
+			}
+
+			r.handleMessage(in.Client, payload, in.Data)
+
+		case piece := <-r.Pocket:
+			if r.position == nil {
+				continue
+			}
+			r.position.Pockets[piece.Color()][piece.Type()]++
+			if err := r.gameService.UpdateFEN(r.ID, r.position.FEN()); err != nil {
+				log.Println("Failed to update FEN:", err)
+			}
+			r.broadcastPosition()
+
+		case result := <-r.Terminate:
+			r.endGame(result.Winner, result.Reason)
+
+		case rm := <-r.Remote:
+			rm.Result <- r.playRemote(rm, time.Now())
+
+		case <-r.flagCheck:
+			r.checkFlag()
+
+		case <-r.stop:
+			// The stored clock carries on; whoever loads the game next
+			// is charged the time that passed.
+			if r.flagTimer != nil {
+				r.flagTimer.Stop()
+			}
+			return
+
+		case s := <-r.Sanctions:
+			for client := range r.Clients {
+				if client.User.ID != s.UserID {
+					continue
+				}
+				if s.ClubID != 0 {
+					if s.ClubID == r.clubID {
+						client.Conn.Close()
+					}
+					continue
+				}
+				if s.Banned {
+					// readPump sees the closed socket and unregisters it.
+					client.Conn.Close()
+					continue
+				}
+				client.User.MutedUntil = s.MutedUntil
+			}
 
 			// switch payload.Type {
 			// case "move":
@@ -282,52 +479,68 @@ func (r *Room) Run() {
 	}
 }
 
-func (r *Room) handleMessage(payload Message, originalMsg []byte) {
-	switch payload.Type {
-	case "move":
-		// Save move to database
-		gameID := r.ID
-		var playerID int
-		var piece string = "unknown" // You'd parse this from the move
+// handleMessage processes a message from sender, or from the hub when
+// sender is nil.
+func (r *Room) handleMessage(sender *Client, payload Message, originalMsg []byte) {
+	// Club rooms only carry chat.
+	if r.clubID != 0 && payload.Type != "chat" && payload.Type != "ping" {
+		return
+	}
 
-		for client := range r.Clients {
-			if client.User.Name == payload.Sender {
-				playerID = client.User.ID
-				break
-			}
+	switch payload.Type {
+	case "move", "drop":
+		if sender == nil {
+			return
 		}
 
-		if playerID != 0 {
-			r.gameService.SaveMove(gameID, playerID, payload.From, payload.To, piece, payload.FEN, payload.MoveNumber)
-
-			// Update game status if needed
-			// if payload.GameStatus != "" {
-			// 	var winner *string
-			// 	if payload.Winner != "" {
-			// 		winner = &payload.Winner
-			// 	}
-			// 	// r.gameService.UpdateGame(gameID, payload.FEN, payload.GameStatus, winner)
-			// }
+		now := time.Now()
+		before := r.position
+		move, next, err := r.applyMove(sender, payload, now)
+		if err != nil {
+			sender.sendJSON(map[string]string{
+				"type":    "error",
+				"message": err.Error(),
+			})
+			return
 		}
 
 		// Broadcast to other players
 		for client := range r.Clients {
-			if client.User.Name != payload.Sender {
+			if client != sender {
 				client.Send <- originalMsg
 			}
 		}
-		var raw map[string]interface{}
-		if err := json.Unmarshal(originalMsg, &raw); err != nil {
-			log.Println("Failed to unmarshal original message:", err)
+		r.afterMove(before, move, next, payload.Move, now)
+
+	case "premove":
+		if sender == nil {
 			return
 		}
+		if err := r.queuePremove(sender, payload); err != nil {
+			sender.sendJSON(map[string]string{
+				"type":    "error",
+				"message": err.Error(),
+			})
+			return
+		}
+		sender.sendJSON(map[string]string{"type": "premove-set"})
 
-		moveData, err := json.Marshal(raw["move"])
-		if err != nil {
-			log.Println("Failed to marshal move field:", err)
+	case "berserk":
+		if sender == nil {
+			return
+		}
+		if err := r.berserk(sender); err != nil {
+			sender.sendJSON(map[string]string{
+				"type":    "error",
+				"message": err.Error(),
+			})
+		}
+
+	case "cancel-premove":
+		if sender == nil {
 			return
 		}
-		r.gameService.UpdateGame(gameID, payload.GameStatus, payload.Winner, moveData)
+		delete(r.premoves, sender.Color)
 
 	case "chat":
 		// Check if this is a "hello" message and log it
@@ -337,9 +550,37 @@ func (r *Room) handleMessage(payload Message, originalMsg []byte) {
 				payload.Sender, r.ID, len(r.Clients))
 		}
 
-		// Broadcast chat to all players
+		// Messages without a sender come from the server and are not stored
+		if sender == nil {
+			for client := range r.Clients {
+				client.Send <- originalMsg
+			}
+			return
+		}
+
+		var msg *ChatMessage
+		var err error
+		if r.clubID != 0 {
+			msg, err = r.chat.PostClub(r.clubID, sender.User, payload.Message)
+		} else {
+			msg, err = r.chat.Post(r.ID, sender.User, payload.Message)
+		}
+		if err != nil {
+			sender.sendJSON(map[string]string{
+				"type":    "error",
+				"message": err.Error(),
+			})
+			return
+		}
+
+		// Broadcast chat to all players who have not blocked the sender,
+		// with the author set by the server rather than the client
+		chatBytes, _ := json.Marshal(chatEvent{Type: "chat", Sender: strconv.Itoa(sender.User.ID), ChatMessage: *msg})
 		for client := range r.Clients {
-			client.Send <- originalMsg
+			if client.Blocked[sender.User.ID] {
+				continue
+			}
+			client.Send <- chatBytes
 		}
 
 	case "room_status":
@@ -377,6 +618,299 @@ func (r *Room) handleMessage(payload Message, originalMsg []byte) {
 	}
 }
 
+// applyMove checks a move against the room's position and persists it.
+func (r *Room) applyMove(sender *Client, payload Message, now time.Time) (Move, *Position, error) {
+	if err := r.checkTurn(sender.Color, now); err != nil {
+		return Move{}, nil, err
+	}
+
+	data, err := payload.moveData()
+	if err != nil {
+		return Move{}, nil, err
+	}
+
+	move, next, err := r.gameService.PlayMove(r.ID, r.position, sender.User.ID, data, payload.Type == "drop")
+	if err != nil {
+		return Move{}, nil, err
+	}
+
+	r.position = next
+	return move, next, nil
+}
+
+// moveData reads the move from the message fields or its move object.
+func (m Message) moveData() (MoveData, error) {
+	data := MoveData{From: m.From, To: m.To, Promotion: m.Promotion, Piece: m.Piece}
+	if data.To == "" && len(m.Move) > 0 {
+		if err := json.Unmarshal(m.Move, &data); err != nil {
+			return MoveData{}, fmt.Errorf("invalid move data")
+		}
+	}
+	if data.To == "" {
+		return MoveData{}, fmt.Errorf("invalid move data")
+	}
+	return data, nil
+}
+
+// queuePremove stores sender's premove, replacing any earlier one. Premoves
+// are only accepted while the opponent is to move; a premove with a piece
+// and no from square is a drop.
+func (r *Room) queuePremove(sender *Client, payload Message) error {
+	if r.position == nil {
+		return fmt.Errorf("game has not started")
+	}
+	if over, _ := r.position.Outcome(); over || r.result != "" {
+		return fmt.Errorf("game is over")
+	}
+	if sender.Color == "" || sender.Color == r.position.Turn.String() {
+		return fmt.Errorf("premoves can only be made on your opponent's turn")
+	}
+
+	data, err := payload.moveData()
+	if err != nil {
+		return err
+	}
+	r.premoves[sender.Color] = premove{
+		client: sender,
+		data:   data,
+		drop:   data.From == "" && data.Piece != "",
+	}
+	return nil
+}
+
+// playPremove plays the side to move's premove, or drops it and tells its
+// owner if it is not legal in the new position. now is when the opponent's
+// clock was pressed, so the premove takes no time.
+func (r *Room) playPremove(now time.Time) {
+	color := r.position.Turn.String()
+	pm, ok := r.premoves[color]
+	if !ok {
+		return
+	}
+	delete(r.premoves, color)
+
+	rm := RemoteMove{GameID: r.ID, User: pm.client.User, Color: color, Data: pm.data, Drop: pm.drop}
+	if err := r.playRemote(rm, now); err != nil {
+		pm.client.sendJSON(map[string]string{
+			"type":    "premove-rejected",
+			"message": err.Error(),
+		})
+	}
+}
+
+func (r *Room) checkTurn(color string, now time.Time) error {
+	if r.position == nil {
+		return fmt.Errorf("game has not started")
+	}
+	if over, _ := r.position.Outcome(); over || r.result != "" {
+		return fmt.Errorf("game is over")
+	}
+	if color != r.position.Turn.String() {
+		return fmt.Errorf("not your turn")
+	}
+	if r.clock != nil && r.clock.left(color, now) <= 0 {
+		r.timeOut(color)
+		return fmt.Errorf("time is up")
+	}
+	return nil
+}
+
+// playRemote applies a REST move made at now and relays it to everyone in
+// the room.
+func (r *Room) playRemote(rm RemoteMove, now time.Time) error {
+	if err := r.checkTurn(rm.Color, now); err != nil {
+		return err
+	}
+
+	before := r.position
+	move, next, err := r.gameService.PlayMove(r.ID, r.position, rm.User.ID, rm.Data, rm.Drop)
+	if err != nil {
+		return err
+	}
+	r.position = next
+
+	msgType := "move"
+	if rm.Drop {
+		msgType = "drop"
+	}
+	meta, _ := json.Marshal(rm.Data)
+	relay, _ := json.Marshal(Message{
+		Type:      msgType,
+		From:      rm.Data.From,
+		To:        rm.Data.To,
+		Promotion: rm.Data.Promotion,
+		Piece:     rm.Data.Piece,
+		Move:      meta,
+	})
+	for client := range r.Clients {
+		client.Send <- relay
+	}
+	r.afterMove(before, move, next, meta, now)
+	return nil
+}
+
+// afterMove publishes the new position and records the game state. The
+// mover's clock is pressed at now, when the move was received.
+func (r *Room) afterMove(before *Position, move Move, next *Position, meta json.RawMessage, now time.Time) {
+	status, winner := gameOutcome(next)
+	if r.clock != nil {
+		r.clock.press(before.Turn.String(), now)
+		if status != "active" {
+			r.clock.stop(now)
+		}
+		r.saveClock()
+		r.armFlag()
+	}
+
+	r.broadcastPosition()
+	r.gameService.UpdateGame(r.ID, status, winner, meta)
+	r.pushSimulBoard(before.MoveUCI(move), winner)
+
+	if r.match != nil {
+		if move.IsCapture() {
+			r.match.passCapture(r, NewPiece(pocketPiece(before, move), move.Captured.Color()))
+		}
+		if status == "completed" {
+			r.match.finish(r, winner)
+		}
+	}
+
+	if status != "active" {
+		r.premoves = make(map[string]premove)
+		return
+	}
+	if r.correspondence && r.playConditional(before.MoveUCI(move), now) {
+		return
+	}
+	r.playPremove(now)
+}
+
+// playConditional plays the reply the side to move queued for played, if any,
+// and reports whether it did.
+func (r *Room) playConditional(played string, now time.Time) bool {
+	color := r.position.Turn.String()
+	reply, playerID, ok := r.gameService.TakeConditionalMove(r.ID, color, played)
+	if !ok {
+		return false
+	}
+
+	data, drop := moveDataFromUCI(reply)
+	rm := RemoteMove{GameID: r.ID, User: &User{ID: playerID}, Color: color, Data: data, Drop: drop}
+	if err := r.playRemote(rm, now); err != nil {
+		log.Println("Failed to play conditional move:", err)
+		return false
+	}
+	return true
+}
+
+func (r *Room) broadcastPosition() {
+	pu := PositionUpdate{Type: "position", FEN: r.position.FEN(), Pockets: r.position.PocketCounts()}
+	if r.clock != nil {
+		state := r.clock.state(time.Now())
+		pu.Clock = &state
+	}
+	update, _ := json.Marshal(pu)
+	for client := range r.Clients {
+		client.Send <- update
+	}
+}
+
+// pushSimulBoard sends the board to the simul host's feed after a move or
+// when the game ends.
+func (r *Room) pushSimulBoard(lastMove, winner string) {
+	if r.simulHost == 0 {
+		return
+	}
+	update := SimulBoardUpdate{
+		SimulID:  r.simulID,
+		GameID:   r.ID,
+		FEN:      r.position.FEN(),
+		LastMove: lastMove,
+		Winner:   winner,
+	}
+	if r.clock != nil {
+		state := r.clock.state(time.Now())
+		update.Clock = &state
+	}
+	r.gameService.notifications.Push(r.simulHost, NotifySimulBoard, update)
+}
+
+// chatEvent is a chat message as relayed to the room. Sender keeps the
+// user ID form clients already read.
+type chatEvent struct {
+	Type   string `json:"type"`
+	Sender string `json:"sender"`
+	ChatMessage
+}
+
+// sendChatHistory replays the stored chat to a joining client, leaving out
+// users they have blocked.
+func (r *Room) sendChatHistory(client *Client) {
+	var history []ChatMessage
+	var err error
+	if r.clubID != 0 {
+		history, err = r.chat.ClubHistory(r.clubID)
+	} else {
+		history, err = r.chat.History(r.ID)
+	}
+	if err != nil {
+		log.Println("Failed to load chat history:", err)
+		return
+	}
+
+	messages := []ChatMessage{}
+	for _, m := range history {
+		if !client.Blocked[m.UserID] {
+			messages = append(messages, m)
+		}
+	}
+	client.sendJSON(map[string]interface{}{
+		"type":     "chat-history",
+		"messages": messages,
+	})
+}
+
+// endGame finishes the game without a move on this board.
+func (r *Room) endGame(winner string, reason string) {
+	if r.result != "" {
+		return
+	}
+	r.result = winner
+	if r.clock != nil {
+		r.clock.stop(time.Now())
+		r.saveClock()
+		r.armFlag()
+	}
+	if err := r.gameService.FinishGame(r.ID, winner); err != nil {
+		log.Println("Failed to finish game:", err)
+	}
+
+	r.pushSimulBoard("", winner)
+
+	gameOver, _ := json.Marshal(Message{Type: "game-over", Winner: winner, Message: reason})
+	for client := range r.Clients {
+		r.gameService.UpdateActiveGameState(client.User.ID, " ")
+		client.Send <- gameOver
+	}
+}
+
+// gameOutcome returns the games.status and games.winner values for pos.
+func gameOutcome(pos *Position) (status string, winner string) {
+	if over, winner := pos.Outcome(); over {
+		return "completed", winner
+	}
+	return "active", ""
+}
+
+func (c *Client) sendJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Failed to marshal message:", err)
+		return
+	}
+	c.Send <- data
+}
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -405,12 +939,35 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request, authService *Auth
 		conn.Close()
 		return
 	}
+	if strings.HasPrefix(roomID, clubRoomPrefix) {
+		conn.WriteJSON(map[string]string{
+			"type":    "error",
+			"message": "Invalid room ID",
+		})
+		conn.Close()
+		return
+	}
+
+	options, err := gameOptionsFromQuery(r)
+	if err != nil {
+		conn.WriteJSON(map[string]string{
+			"type":    "error",
+			"message": err.Error(),
+		})
+		conn.Close()
+		return
+	}
+	if options.MatchID != "" {
+		roomID = bughouseBoardID(roomID, options.Board)
+	}
 
 	client := &Client{
-		Conn:   conn,
-		RoomID: roomID,
-		User:   user,
-		Send:   make(chan []byte, 256),
+		Conn:    conn,
+		RoomID:  roomID,
+		User:    user,
+		Send:    make(chan []byte, 256),
+		Options: options,
+		Invite:  r.URL.Query().Get("invite"),
 	}
 
 	hub.Register <- client
@@ -419,6 +976,57 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request, authService *Auth
 	go client.readPump(hub)
 }
 
+// gameOptionsFromQuery reads ?variant= (see VariantNames) and, for Chess960,
+// ?position= (the start position index; random when omitted).
+func gameOptionsFromQuery(r *http.Request) (GameOptions, error) {
+	variant := r.URL.Query().Get("variant")
+	if variant == "" {
+		variant = VariantStandard
+	}
+
+	index := -1
+	if position := r.URL.Query().Get("position"); position != "" {
+		n, err := strconv.Atoi(position)
+		if err != nil {
+			return GameOptions{}, fmt.Errorf("invalid start position %q", position)
+		}
+		index = n
+	}
+
+	fen, err := startFEN(variant, index)
+	if err != nil {
+		return GameOptions{}, err
+	}
+	options := GameOptions{Variant: variant, StartFEN: fen}
+
+	// ?private=true makes a game that needs an invite to join.
+	options.Private = r.URL.Query().Get("private") == "true"
+
+	// ?days=N makes a correspondence game with N days per move.
+	if days := r.URL.Query().Get("days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 || n > MaxDaysPerMove {
+			return GameOptions{}, fmt.Errorf("days must be between 1 and %d", MaxDaysPerMove)
+		}
+		options.DaysPerMove = n
+	}
+
+	// Bughouse players join one of two boards: ?room=<match>&board=a|b.
+	if variant == VariantBughouse {
+		options.MatchID = r.URL.Query().Get("room")
+		switch r.URL.Query().Get("board") {
+		case "", "a":
+			options.Board = 0
+		case "b":
+			options.Board = 1
+		default:
+			return GameOptions{}, fmt.Errorf("board must be a or b")
+		}
+	}
+
+	return options, nil
+}
+
 func (c *Client) readPump(hub *Hub) {
 	defer func() {
 		hub.Unregister <- c
@@ -429,8 +1037,9 @@ func (c *Client) readPump(hub *Hub) {
 		if err != nil {
 			break
 		}
+		hub.presence.Touch(c.User.ID)
 		if room, ok := hub.Rooms[c.RoomID]; ok {
-			room.Broadcast <- message
+			room.Incoming <- ClientMessage{Client: c, Data: message}
 		}
 	}
 }
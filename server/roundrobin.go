@@ -0,0 +1,80 @@
+package main
+
+import "sort"
+
+// roundRobinRounds is the number of rounds for n players: everyone meets
+// everyone once per cycle, with one player sitting out each round when n is
+// odd.
+func roundRobinRounds(n, cycles int) int {
+	if n%2 == 1 {
+		n++
+	}
+	return (n - 1) * cycles
+}
+
+// bergerRound returns the pairings of round (1-based) for n players
+// numbered from 1, in the board order of the FIDE Berger tables. n must be
+// even; the caller adds a dummy player for an odd field. On the first board
+// player n meets the p for which round = (2p-2) mod (n-1) + 1, having black
+// against the first half and white against the second. Board k+1 pairs the
+// players k places either side of p, counting around 1..n-1, the lower
+// number having white when their sum is odd. Rounds of a second cycle repeat
+// the first with colours reversed.
+func bergerRound(n, round int) [][2]int {
+	cycle := n - 1
+	second := round > cycle
+	round = (round-1)%cycle + 1
+
+	p := 1
+	for (2*p-2)%cycle+1 != round {
+		p++
+	}
+	var pairs [][2]int
+	if p <= n/2 {
+		pairs = append(pairs, [2]int{p, n})
+	} else {
+		pairs = append(pairs, [2]int{n, p})
+	}
+
+	for k := 1; k < n/2; k++ {
+		i, j := (p-1-k+cycle)%cycle+1, (p-1+k)%cycle+1
+		if i > j {
+			i, j = j, i
+		}
+		if (i+j)%2 == 1 {
+			pairs = append(pairs, [2]int{i, j})
+		} else {
+			pairs = append(pairs, [2]int{j, i})
+		}
+	}
+
+	if second {
+		for k := range pairs {
+			pairs[k][0], pairs[k][1] = pairs[k][1], pairs[k][0]
+		}
+	}
+	return pairs
+}
+
+// roundRobinPairs maps the Berger table for round onto the players in seed
+// order. The player drawn against the dummy of an odd field sits out.
+func (ts *TournamentService) roundRobinPairs(t *Tournament, round int) ([]swissPairing, error) {
+	players, err := ts.players(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Seed < players[j].Seed })
+
+	n := len(players)
+	if n%2 == 1 {
+		n++
+	}
+	var pairs []swissPairing
+	for _, p := range bergerRound(n, round) {
+		if p[0] > len(players) || p[1] > len(players) {
+			continue
+		}
+		pairs = append(pairs, swissPairing{White: players[p[0]-1].UserID, Black: players[p[1]-1].UserID})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBergerRound(t *testing.T) {
+	// The FIDE Berger tables, white first, in board order.
+	tables := map[int][][][2]int{
+		4: {
+			{{1, 4}, {2, 3}},
+			{{4, 3}, {1, 2}},
+			{{2, 4}, {3, 1}},
+		},
+		6: {
+			{{1, 6}, {2, 5}, {3, 4}},
+			{{6, 4}, {5, 3}, {1, 2}},
+			{{2, 6}, {3, 1}, {4, 5}},
+			{{6, 5}, {1, 4}, {2, 3}},
+			{{3, 6}, {4, 2}, {5, 1}},
+		},
+	}
+	for n, rounds := range tables {
+		for i, want := range rounds {
+			if got := bergerRound(n, i+1); !reflect.DeepEqual(got, want) {
+				t.Errorf("bergerRound(%d, %d) = %v, want %v", n, i+1, got, want)
+			}
+
+			// The second cycle replays the round with colours reversed.
+			var reversed [][2]int
+			for _, p := range want {
+				reversed = append(reversed, [2]int{p[1], p[0]})
+			}
+			round := i + 1 + len(rounds)
+			if got := bergerRound(n, round); !reflect.DeepEqual(got, reversed) {
+				t.Errorf("bergerRound(%d, %d) = %v, want %v", n, round, got, reversed)
+			}
+		}
+	}
+}
+
+// TestBergerRoundEveryoneMeets checks a larger field: each pair meets once
+// per cycle and nobody plays the same colour three rounds running.
+func TestBergerRoundEveryoneMeets(t *testing.T) {
+	const n = 10
+	met := map[[2]int]int{}
+	colors := map[int]string{}
+	for round := 1; round < n; round++ {
+		for _, p := range bergerRound(n, round) {
+			met[[2]int{min(p[0], p[1]), max(p[0], p[1])}]++
+			colors[p[0]] += "w"
+			colors[p[1]] += "b"
+		}
+	}
+	if len(met) != n*(n-1)/2 {
+		t.Errorf("%d pairs met, want %d", len(met), n*(n-1)/2)
+	}
+	for pair, times := range met {
+		if times != 1 {
+			t.Errorf("%v met %d times", pair, times)
+		}
+	}
+	for player, seq := range colors {
+		for i := 2; i < len(seq); i++ {
+			if seq[i] == seq[i-1] && seq[i] == seq[i-2] {
+				t.Errorf("player %d has colours %s", player, seq)
+				break
+			}
+		}
+	}
+}
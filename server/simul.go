@@ -0,0 +1,552 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Simul statuses.
+const (
+	SimulOpen     = "open"
+	SimulRunning  = "running"
+	SimulFinished = "finished"
+)
+
+const (
+	defaultSimulPlayers = 20
+	maxSimulPlayers     = 50
+)
+
+// SimulService runs simultaneous exhibitions. Participants sign up while
+// the simul is open; starting it creates every board at once with the host
+// on the same colour. Rooms push each move to the host's /ws/user socket as
+// a simul-board event, and RunResults closes the simul when every board has
+// finished.
+type SimulService struct {
+	db            *sql.DB
+	gameService   *GameService
+	notifications *NotificationService
+}
+
+func NewSimulService(db *sql.DB, gameService *GameService, notifications *NotificationService) *SimulService {
+	return &SimulService{
+		db:            db,
+		gameService:   gameService,
+		notifications: notifications,
+	}
+}
+
+type CreateSimulRequest struct {
+	Name           string `json:"name"`
+	HostColor      string `json:"host_color"`
+	Variant        string `json:"variant"`
+	ClockLimit     int    `json:"clock_limit"`
+	ClockIncrement int    `json:"clock_increment"`
+	MaxPlayers     int    `json:"max_players"`
+}
+
+// SimulBoardUpdate is a board as pushed to the host's feed. Winner is set
+// once the game is over.
+type SimulBoardUpdate struct {
+	SimulID  int         `json:"simul_id"`
+	GameID   string      `json:"game_id"`
+	FEN      string      `json:"fen"`
+	LastMove string      `json:"last_move,omitempty"`
+	Winner   string      `json:"winner,omitempty"`
+	Clock    *ClockState `json:"clock,omitempty"`
+}
+
+const simulColumns = `
+	s.id, s.name, s.host_id, s.host_color, s.variant, s.clock_limit, s.clock_increment,
+	s.max_players, s.status,
+	(SELECT COUNT(*) FROM simul_participants sp WHERE sp.simul_id = s.id),
+	s.created_at, s.updated_at
+`
+
+func scanSimul(row rowScanner) (*Simul, error) {
+	s := &Simul{}
+	err := row.Scan(&s.ID, &s.Name, &s.HostID, &s.HostColor, &s.Variant, &s.ClockLimit, &s.ClockIncrement,
+		&s.MaxPlayers, &s.Status, &s.Participants, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (ss *SimulService) getSimul(id int) (*Simul, error) {
+	return scanSimul(ss.db.QueryRow(`SELECT `+simulColumns+` FROM simuls s WHERE s.id = $1`, id))
+}
+
+// SimulHost returns the host of simulID, or 0 if it cannot be found.
+func (gs *GameService) SimulHost(simulID int) int {
+	var hostID int
+	if err := gs.db.QueryRow(`SELECT host_id FROM simuls WHERE id = $1`, simulID).Scan(&hostID); err != nil {
+		log.Println("Error fetching simul host:", err)
+	}
+	return hostID
+}
+
+// simulFromPath loads the simul in the path, writing the error response if
+// it cannot.
+func (ss *SimulService) simulFromPath(w http.ResponseWriter, r *http.Request) (*Simul, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid simul ID", http.StatusBadRequest)
+		return nil, false
+	}
+	s, err := ss.getSimul(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Simul not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Error fetching simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return s, true
+}
+
+// boards returns every participant's board, in sign-up order.
+func (ss *SimulService) boards(simulID int) ([]SimulBoard, error) {
+	rows, err := ss.db.Query(`
+        SELECT u.id, u.name, u.avatar_url, sp.game_id, COALESCE(g.current_fen, ''), sp.result
+        FROM simul_participants sp
+        JOIN users u ON u.id = sp.user_id
+        LEFT JOIN games g ON g.id = sp.game_id
+        WHERE sp.simul_id = $1
+        ORDER BY sp.joined_at, u.id
+    `, simulID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boards := []SimulBoard{}
+	for rows.Next() {
+		var b SimulBoard
+		if err := rows.Scan(&b.User.ID, &b.User.Name, &b.User.AvatarURL, &b.GameID, &b.CurrentFEN, &b.Result); err != nil {
+			return nil, err
+		}
+		boards = append(boards, b)
+	}
+	return boards, rows.Err()
+}
+
+// summarizeSimul scores the finished boards for the host.
+func summarizeSimul(hostColor string, boards []SimulBoard) SimulSummary {
+	summary := SimulSummary{Boards: len(boards)}
+	for _, b := range boards {
+		if b.Result == nil {
+			continue
+		}
+		summary.Finished++
+		switch *b.Result {
+		case hostColor:
+			summary.Wins++
+		case "draw":
+			summary.Draws++
+		default:
+			summary.Losses++
+		}
+	}
+	summary.Score = float64(summary.Wins) + float64(summary.Draws)/2
+	return summary
+}
+
+func (ss *SimulService) CreateSimul(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var req CreateSimulRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.HostColor == "" {
+		req.HostColor = "white"
+	}
+	if req.Variant == "" {
+		req.Variant = VariantStandard
+	}
+	if req.MaxPlayers == 0 {
+		req.MaxPlayers = defaultSimulPlayers
+	}
+
+	switch {
+	case req.Name == "":
+		http.Error(w, "A name is required", http.StatusBadRequest)
+		return
+	case req.HostColor != "white" && req.HostColor != "black":
+		http.Error(w, "Host color must be white or black", http.StatusBadRequest)
+		return
+	case req.MaxPlayers < 1 || req.MaxPlayers > maxSimulPlayers:
+		http.Error(w, "Max players must be between 1 and "+strconv.Itoa(maxSimulPlayers), http.StatusBadRequest)
+		return
+	case req.ClockLimit < 0 || req.ClockIncrement < 0:
+		http.Error(w, "Invalid time control", http.StatusBadRequest)
+		return
+	case req.Variant == VariantBughouse:
+		http.Error(w, "Bughouse cannot be played in a simul", http.StatusBadRequest)
+		return
+	}
+	if _, err := LookupVariant(req.Variant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var id int
+	err := ss.db.QueryRow(`
+        INSERT INTO simuls (name, host_id, host_color, variant, clock_limit, clock_increment, max_players)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id
+    `, req.Name, user.ID, req.HostColor, req.Variant, req.ClockLimit, req.ClockIncrement, req.MaxPlayers).Scan(&id)
+	if err != nil {
+		log.Println("Error creating simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	s, err := ss.getSimul(id)
+	if err != nil {
+		log.Println("Error fetching simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// ListSimuls lists recent simuls, optionally filtered by ?status=.
+func (ss *SimulService) ListSimuls(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	rows, err := ss.db.Query(`
+        SELECT `+simulColumns+`
+        FROM simuls s
+        WHERE $1 = '' OR s.status = $1
+        ORDER BY s.created_at DESC
+        LIMIT 50
+    `, status)
+	if err != nil {
+		log.Println("Error fetching simuls:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	simuls := []Simul{}
+	for rows.Next() {
+		s, err := scanSimul(rows)
+		if err != nil {
+			log.Println("Error scanning simul:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		simuls = append(simuls, *s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(simuls)
+}
+
+// GetSimul returns the simul with every board and the host's score so far.
+// It is the host's overview; the simul-board events keep it current.
+func (ss *SimulService) GetSimul(w http.ResponseWriter, r *http.Request) {
+	s, ok := ss.simulFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	boards, err := ss.boards(s.ID)
+	if err != nil {
+		log.Println("Error fetching simul boards:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"simul":   s,
+		"boards":  boards,
+		"summary": summarizeSimul(s.HostColor, boards),
+	})
+}
+
+func (ss *SimulService) JoinSimul(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	s, ok := ss.simulFromPath(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case s.Status != SimulOpen:
+		http.Error(w, "The simul has already started", http.StatusConflict)
+		return
+	case s.HostID == user.ID:
+		http.Error(w, "The host cannot join their own simul", http.StatusBadRequest)
+		return
+	}
+
+	result, err := ss.db.Exec(`
+        INSERT INTO simul_participants (simul_id, user_id)
+        SELECT $1, $2
+        WHERE (SELECT COUNT(*) FROM simul_participants WHERE simul_id = $1) < $3
+        ON CONFLICT (simul_id, user_id) DO NOTHING
+    `, s.ID, user.ID, s.MaxPlayers)
+	if err != nil {
+		log.Println("Error joining simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var joined bool
+		ss.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM simul_participants WHERE simul_id = $1 AND user_id = $2)`, s.ID, user.ID).Scan(&joined)
+		if !joined {
+			http.Error(w, "The simul is full", http.StatusConflict)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ss *SimulService) LeaveSimul(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	s, ok := ss.simulFromPath(w, r)
+	if !ok {
+		return
+	}
+	if s.Status != SimulOpen {
+		http.Error(w, "The simul has already started", http.StatusConflict)
+		return
+	}
+
+	result, err := ss.db.Exec(`DELETE FROM simul_participants WHERE simul_id = $1 AND user_id = $2`, s.ID, user.ID)
+	if err != nil {
+		log.Println("Error leaving simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Not registered", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartSimul closes sign-up and creates every board, the host taking the
+// same colour on each.
+func (ss *SimulService) StartSimul(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	s, ok := ss.simulFromPath(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case s.HostID != user.ID:
+		http.Error(w, "Only the host can start the simul", http.StatusForbidden)
+		return
+	case s.Status != SimulOpen:
+		http.Error(w, "The simul has already started", http.StatusConflict)
+		return
+	case s.Participants == 0:
+		http.Error(w, "Nobody has joined yet", http.StatusConflict)
+		return
+	}
+
+	result, err := ss.db.Exec(`
+        UPDATE simuls SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status = $3
+    `, SimulRunning, s.ID, SimulOpen)
+	if err != nil {
+		log.Println("Error starting simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "The simul has already started", http.StatusConflict)
+		return
+	}
+
+	if created, err := ss.createBoards(s); err != nil {
+		log.Println("Error creating simul boards:", err)
+		ss.reopen(s.ID, created)
+		http.Error(w, "Failed to create games", http.StatusInternalServerError)
+		return
+	}
+
+	s, err = ss.getSimul(s.ID)
+	if err != nil {
+		log.Println("Error fetching simul:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	boards, err := ss.boards(s.ID)
+	if err != nil {
+		log.Println("Error fetching simul boards:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for _, b := range boards {
+		ss.notifications.Notify(b.User.ID, NotifySimulStarted, map[string]interface{}{
+			"simul":   s,
+			"game_id": b.GameID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"simul":   s,
+		"boards":  boards,
+		"summary": summarizeSimul(s.HostColor, boards),
+	})
+}
+
+// createBoards creates a game against the host for every participant still
+// without one, returning the games it created even when it fails partway.
+func (ss *SimulService) createBoards(s *Simul) (created []string, err error) {
+	participants, err := queryIDs(ss.db, `
+        SELECT user_id FROM simul_participants WHERE simul_id = $1 AND game_id IS NULL ORDER BY joined_at, user_id
+    `, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	fen, err := startFEN(s.Variant, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range participants {
+		white, black := s.HostID, userID
+		if s.HostColor == "black" {
+			white, black = black, white
+		}
+		game, err := ss.gameService.CreateGame(white, newGameID(), GameOptions{
+			Variant:        s.Variant,
+			StartFEN:       fen,
+			ClockLimit:     s.ClockLimit,
+			ClockIncrement: s.ClockIncrement,
+			SimulID:        &s.ID,
+		})
+		if err != nil {
+			return created, err
+		}
+		created = append(created, game.ID)
+		if err := ss.gameService.JoinGame(game.ID, black); err != nil {
+			return created, err
+		}
+		if _, err := ss.db.Exec(`
+            UPDATE simul_participants SET game_id = $1 WHERE simul_id = $2 AND user_id = $3
+        `, game.ID, s.ID, userID); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// reopen undoes a failed start: the boards created so far are deleted, which
+// unlinks their participants, and the simul is open to be started again.
+func (ss *SimulService) reopen(simulID int, created []string) {
+	for _, id := range created {
+		if err := ss.gameService.DeleteGame(id); err != nil {
+			log.Println("Error deleting simul board:", err)
+		}
+	}
+	if _, err := ss.db.Exec(`
+        UPDATE simuls SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND status = $3
+    `, SimulOpen, simulID, SimulRunning); err != nil {
+		log.Println("Error reopening simul:", err)
+	}
+}
+
+// RunResults records finished boards every interval and closes simuls
+// whose boards have all finished.
+func (ss *SimulService) RunResults(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := ss.collectResults()
+		if err != nil {
+			log.Println("Simul results:", err)
+		}
+		for id := range changed {
+			if err := ss.finishIfDone(id); err != nil {
+				log.Println("Simul finish:", err)
+			}
+		}
+	}
+}
+
+// collectResults copies the winner of finished games onto their boards and
+// returns the simuls that got new results.
+func (ss *SimulService) collectResults() (map[int]bool, error) {
+	changed := make(map[int]bool)
+	rows, err := ss.db.Query(`
+        UPDATE simul_participants sp
+        SET result = g.winner
+        FROM games g, simuls s
+        WHERE g.id = sp.game_id AND s.id = sp.simul_id
+          AND sp.result IS NULL AND g.status = 'completed' AND g.winner IS NOT NULL AND s.status = $1
+        RETURNING sp.simul_id
+    `, SimulRunning)
+	if err != nil {
+		return changed, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return changed, err
+		}
+		changed[id] = true
+	}
+	return changed, rows.Err()
+}
+
+// finishIfDone closes the simul once no board is still being played and
+// sends the summary to the host and every participant.
+func (ss *SimulService) finishIfDone(id int) error {
+	result, err := ss.db.Exec(`
+        UPDATE simuls SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status = $3
+          AND NOT EXISTS(SELECT 1 FROM simul_participants WHERE simul_id = $2 AND result IS NULL)
+    `, SimulFinished, id, SimulRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	s, err := ss.getSimul(id)
+	if err != nil {
+		return err
+	}
+	boards, err := ss.boards(id)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"simul":   s,
+		"boards":  boards,
+		"summary": summarizeSimul(s.HostColor, boards),
+	}
+	ss.notifications.Notify(s.HostID, NotifySimulFinished, data)
+	for _, b := range boards {
+		ss.notifications.Notify(b.User.ID, NotifySimulFinished, data)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import "sort"
+
+// Pairing results, from white's point of view.
+const (
+	ResultWhiteWins = "1-0"
+	ResultBlackWins = "0-1"
+	ResultDraw      = "1/2-1/2"
+	ResultBye       = "bye"
+)
+
+// swissEntrant is a player's history going into a Swiss round.
+type swissEntrant struct {
+	ID        int
+	Seed      int
+	Score     float64
+	Opponents map[int]bool
+	// Colors are the colours played so far, oldest first.
+	Colors []string
+	HadBye bool
+}
+
+type swissPairing struct {
+	White, Black int
+}
+
+// colorDiff is the number of whites minus the number of blacks played.
+func (e *swissEntrant) colorDiff() int {
+	diff := 0
+	for _, c := range e.Colors {
+		if c == "white" {
+			diff++
+		} else {
+			diff--
+		}
+	}
+	return diff
+}
+
+// colorPreference is positive for white and negative for black. A magnitude
+// of 2 is an absolute preference: the colour difference would pass 1 or the
+// same colour would be played three times running.
+func (e *swissEntrant) colorPreference() int {
+	diff := e.colorDiff()
+	n := len(e.Colors)
+	switch {
+	case diff < -1 || (n >= 2 && e.Colors[n-1] == "black" && e.Colors[n-2] == "black"):
+		return 2
+	case diff > 1 || (n >= 2 && e.Colors[n-1] == "white" && e.Colors[n-2] == "white"):
+		return -2
+	case diff < 0:
+		return 1
+	case diff > 0:
+		return -1
+	case n > 0 && e.Colors[n-1] == "black":
+		return 1
+	case n > 0:
+		return -1
+	}
+	return 0
+}
+
+// pairSwiss pairs a round following the Dutch system: players are ranked by
+// score then seed, each score group is split in half with the top half
+// meeting the bottom half in order, players who cannot be paired in their
+// group float down, and nobody meets the same opponent twice. With an odd
+// number of players the lowest ranked player without a bye gets one.
+// Pairings where both players need the same colour are avoided when
+// possible. It returns ok false when no pairing without a repeat exists.
+func pairSwiss(entrants []*swissEntrant) (pairings []swissPairing, bye int, ok bool) {
+	for _, strict := range []bool{true, false} {
+		if pairings, bye, ok = pairSwissPass(entrants, strict); ok {
+			return pairings, bye, ok
+		}
+	}
+	return nil, 0, false
+}
+
+func pairSwissPass(entrants []*swissEntrant, strict bool) (pairings []swissPairing, bye int, ok bool) {
+	ranked := append([]*swissEntrant(nil), entrants...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Seed < ranked[j].Seed
+	})
+
+	if len(ranked)%2 == 0 {
+		pairs, ok := pairRanked(ranked, strict)
+		return colorPairs(pairs), 0, ok
+	}
+
+	// Try bye candidates from the bottom, preferring those without a bye.
+	for _, allowRepeatBye := range []bool{false, true} {
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if ranked[i].HadBye && !allowRepeatBye {
+				continue
+			}
+			rest := append(append([]*swissEntrant(nil), ranked[:i]...), ranked[i+1:]...)
+			if pairs, ok := pairRanked(rest, strict); ok {
+				return colorPairs(pairs), ranked[i].ID, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// pairRanked pairs the top player of ranked with the best candidate and
+// recurses, backtracking when the rest cannot be paired. In strict mode two
+// players with the same absolute colour preference are not paired.
+func pairRanked(ranked []*swissEntrant, strict bool) ([][2]*swissEntrant, bool) {
+	if len(ranked) == 0 {
+		return nil, true
+	}
+
+	top := ranked[0]
+	for _, i := range candidateOrder(ranked) {
+		other := ranked[i]
+		if top.Opponents[other.ID] {
+			continue
+		}
+		if pref := top.colorPreference(); strict && abs(pref) == 2 && pref == other.colorPreference() {
+			continue
+		}
+		rest := make([]*swissEntrant, 0, len(ranked)-2)
+		rest = append(rest, ranked[1:i]...)
+		rest = append(rest, ranked[i+1:]...)
+		if pairs, ok := pairRanked(rest, strict); ok {
+			return append([][2]*swissEntrant{{top, other}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+// candidateOrder lists opponents for ranked[0] by preference. Within the
+// score group the ideal opponent is the first of the bottom half, then the
+// rest of the bottom half, then the top half from the bottom up. Players in
+// lower groups follow in rank order.
+func candidateOrder(ranked []*swissEntrant) []int {
+	group := 1
+	for group < len(ranked) && ranked[group].Score == ranked[0].Score {
+		group++
+	}
+
+	order := make([]int, 0, len(ranked)-1)
+	half := group / 2
+	if half == 0 {
+		half = 1
+	}
+	for i := half; i < group; i++ {
+		order = append(order, i)
+	}
+	for i := half - 1; i >= 1; i-- {
+		order = append(order, i)
+	}
+	for i := group; i < len(ranked); i++ {
+		order = append(order, i)
+	}
+	return order
+}
+
+// colorPairs allocates colours. The stronger preference wins; on a tie the
+// higher ranked player gets theirs, and without any preference (the first
+// round) colours alternate down the boards.
+func colorPairs(pairs [][2]*swissEntrant) []swissPairing {
+	result := make([]swissPairing, len(pairs))
+	for board, pair := range pairs {
+		a, b := pair[0], pair[1]
+		pa, pb := a.colorPreference(), b.colorPreference()
+
+		aWhite := board%2 == 0
+		switch {
+		case abs(pb) > abs(pa):
+			aWhite = pb < 0
+		case pa != 0:
+			aWhite = pa > 0
+		}
+
+		if aWhite {
+			result[board] = swissPairing{White: a.ID, Black: b.ID}
+		} else {
+			result[board] = swissPairing{White: b.ID, Black: a.ID}
+		}
+	}
+	return result
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// resultPoints returns the points white and black score for result.
+func resultPoints(result string) (white, black float64) {
+	switch result {
+	case ResultWhiteWins, ResultBye:
+		return 1, 0
+	case ResultBlackWins:
+		return 0, 1
+	case ResultDraw:
+		return 0.5, 0.5
+	}
+	return 0, 0
+}
+
+// resultFromWinner maps a games.winner value to a pairing result.
+func resultFromWinner(winner string) string {
+	switch winner {
+	case "white":
+		return ResultWhiteWins
+	case "black":
+		return ResultBlackWins
+	}
+	return ResultDraw
+}
+
+// computeStandings scores every player from the finished pairings and ranks
+// them by score, Buchholz, Sonneborn-Berger and seed. Buchholz is the sum of
+// the opponents' scores; Sonneborn-Berger adds the scores of beaten
+// opponents and half those of drawn ones. Byes count towards score only.
+func computeStandings(players []TournamentStanding, pairings []TournamentPairing) []TournamentStanding {
+	index := make(map[int]int, len(players))
+	for i := range players {
+		index[players[i].UserID] = i
+		players[i].Score = 0
+		players[i].Buchholz = 0
+		players[i].SonnebornBerger = 0
+		players[i].Played = 0
+	}
+
+	for _, p := range pairings {
+		if p.Result == nil {
+			continue
+		}
+		white, black := resultPoints(*p.Result)
+		if i, ok := index[p.WhiteID]; ok {
+			players[i].Score += white
+			if p.BlackID != nil {
+				players[i].Played++
+			}
+		}
+		if p.BlackID != nil {
+			if i, ok := index[*p.BlackID]; ok {
+				players[i].Score += black
+				players[i].Played++
+			}
+		}
+	}
+
+	for _, p := range pairings {
+		if p.Result == nil || p.BlackID == nil {
+			continue
+		}
+		wi, wok := index[p.WhiteID]
+		bi, bok := index[*p.BlackID]
+		if !wok || !bok {
+			continue
+		}
+		white, black := resultPoints(*p.Result)
+		players[wi].Buchholz += players[bi].Score
+		players[bi].Buchholz += players[wi].Score
+		players[wi].SonnebornBerger += white * players[bi].Score
+		players[bi].SonnebornBerger += black * players[wi].Score
+	}
+
+	sort.SliceStable(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Buchholz != b.Buchholz:
+			return a.Buchholz > b.Buchholz
+		case a.SonnebornBerger != b.SonnebornBerger:
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		return a.Seed < b.Seed
+	})
+	for i := range players {
+		players[i].Rank = i + 1
+	}
+	return players
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pairing is a finished board between white and black, or a bye when black
+// is 0.
+func pairing(round, white, black int, result string) TournamentPairing {
+	p := TournamentPairing{Round: round, WhiteID: white, Result: &result}
+	if black != 0 {
+		p.BlackID = &black
+	}
+	return p
+}
+
+func entrants(n int) []*swissEntrant {
+	var es []*swissEntrant
+	for i := 1; i <= n; i++ {
+		es = append(es, &swissEntrant{ID: i, Seed: i, Opponents: map[int]bool{}})
+	}
+	return es
+}
+
+func TestPairSwissFirstRound(t *testing.T) {
+	pairs, bye, ok := pairSwiss(entrants(8))
+	if !ok || bye != 0 {
+		t.Fatalf("pairSwiss = %v, %d, %v", pairs, bye, ok)
+	}
+	want := []swissPairing{{1, 5}, {6, 2}, {3, 7}, {8, 4}}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("pairings = %v, want %v", pairs, want)
+	}
+}
+
+// TestPairSwissTournament plays a whole event, checking every round for
+// repeat opponents, repeat byes and a colour played three times running.
+func TestPairSwissTournament(t *testing.T) {
+	const players, rounds = 9, 7
+	var standings []TournamentStanding
+	for i := 1; i <= players; i++ {
+		standings = append(standings, TournamentStanding{UserID: i, Seed: i})
+	}
+
+	var played []TournamentPairing
+	for round := 1; round <= rounds; round++ {
+		ranked := computeStandings(append([]TournamentStanding(nil), standings...), played)
+		history := swissHistory(ranked, played)
+		byID := map[int]*swissEntrant{}
+		for _, e := range history {
+			byID[e.ID] = e
+		}
+
+		pairs, bye, ok := pairSwiss(history)
+		if !ok {
+			t.Fatalf("round %d: no pairing", round)
+		}
+		seen := map[int]bool{bye: true}
+		if byID[bye].HadBye {
+			t.Errorf("round %d: player %d has a second bye", round, bye)
+		}
+		for _, p := range pairs {
+			if seen[p.White] || seen[p.Black] {
+				t.Errorf("round %d: %v pairs a player twice", round, p)
+			}
+			seen[p.White], seen[p.Black] = true, true
+			if byID[p.White].Opponents[p.Black] {
+				t.Errorf("round %d: %d and %d meet again", round, p.White, p.Black)
+			}
+
+			// The higher seed wins, except for a draw on every third board.
+			result := ResultWhiteWins
+			switch {
+			case (p.White+p.Black+round)%3 == 0:
+				result = ResultDraw
+			case p.Black < p.White:
+				result = ResultBlackWins
+			}
+			played = append(played, pairing(round, p.White, p.Black, result))
+		}
+		if len(seen) != players {
+			t.Errorf("round %d: %d of %d players paired", round, len(seen), players)
+		}
+		played = append(played, pairing(round, bye, 0, ResultBye))
+	}
+
+	for _, e := range swissHistory(standings, played) {
+		for i := 2; i < len(e.Colors); i++ {
+			if e.Colors[i] == e.Colors[i-1] && e.Colors[i] == e.Colors[i-2] {
+				t.Errorf("player %d has %s three times running: %v", e.ID, e.Colors[i], e.Colors)
+			}
+		}
+	}
+}
+
+func TestPairSwissBye(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float64
+		hadBye []int
+		want   int
+	}{
+		{"first round", []float64{0, 0, 0, 0, 0}, nil, 5},
+		{"lowest score", []float64{0, 1, 1, 1, 1}, nil, 1},
+		{"lowest already had one", []float64{2, 1.5, 1, 1, 0}, []int{5}, 4},
+		{"everyone had one", []float64{2, 1.5, 1, 1, 0}, []int{1, 2, 3, 4, 5}, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			es := entrants(len(tc.scores))
+			for i, score := range tc.scores {
+				es[i].Score = score
+			}
+			for _, id := range tc.hadBye {
+				es[id-1].HadBye = true
+			}
+			if _, bye, ok := pairSwiss(es); !ok || bye != tc.want {
+				t.Errorf("bye = %d, %v, want %d", bye, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestColorPreference(t *testing.T) {
+	tests := []struct {
+		colors []string
+		want   int
+	}{
+		{nil, 0},
+		{[]string{"white"}, -1},
+		{[]string{"black"}, 1},
+		{[]string{"white", "black"}, 1},
+		{[]string{"black", "white", "white"}, -2},
+		{[]string{"white", "black", "black"}, 2},
+		{[]string{"white", "white", "black"}, -1},
+		{[]string{"black", "white", "black", "black", "white"}, 1},
+	}
+	for _, tc := range tests {
+		e := &swissEntrant{Colors: tc.colors}
+		if got := e.colorPreference(); got != tc.want {
+			t.Errorf("colorPreference(%v) = %d, want %d", tc.colors, got, tc.want)
+		}
+	}
+}
+
+// TestPairSwissAbsoluteColors checks that two players who must both have
+// white are not paired when another pairing gives everyone their colour.
+func TestPairSwissAbsoluteColors(t *testing.T) {
+	es := entrants(4)
+	for i, colors := range [][]string{
+		{"black", "black"},
+		{"white", "white"},
+		{"black", "black"},
+		{"white", "white"},
+	} {
+		es[i].Score = 1
+		es[i].Colors = colors
+	}
+
+	pairs, _, ok := pairSwiss(es)
+	want := []swissPairing{{1, 4}, {3, 2}}
+	if !ok || !reflect.DeepEqual(pairs, want) {
+		t.Errorf("pairings = %v, %v, want %v", pairs, ok, want)
+	}
+}
+
+// TestComputeStandings checks tie-breaks worked out by hand. Players 2 and 3
+// share score and Buchholz, so Sonneborn-Berger puts 3 first; player 5 only
+// had a bye, which scores without adding to anyone's tie-breaks.
+func TestComputeStandings(t *testing.T) {
+	var players []TournamentStanding
+	for i := 1; i <= 5; i++ {
+		players = append(players, TournamentStanding{UserID: i, Seed: i})
+	}
+	pairings := []TournamentPairing{
+		pairing(1, 1, 2, ResultWhiteWins),
+		pairing(1, 3, 4, ResultDraw),
+		pairing(1, 5, 0, ResultBye),
+		pairing(2, 3, 1, ResultDraw),
+		pairing(2, 4, 2, ResultBlackWins),
+		pairing(3, 1, 4, ResultWhiteWins),
+		pairing(3, 2, 3, ResultDraw),
+	}
+
+	want := []TournamentStanding{
+		{Rank: 1, UserID: 1, Seed: 1, Score: 2.5, Buchholz: 3.5, SonnebornBerger: 2.75, Played: 3},
+		{Rank: 2, UserID: 3, Seed: 3, Score: 1.5, Buchholz: 4.5, SonnebornBerger: 2.25, Played: 3},
+		{Rank: 3, UserID: 2, Seed: 2, Score: 1.5, Buchholz: 4.5, SonnebornBerger: 1.25, Played: 3},
+		{Rank: 4, UserID: 5, Seed: 5, Score: 1, Buchholz: 0, SonnebornBerger: 0, Played: 0},
+		{Rank: 5, UserID: 4, Seed: 4, Score: 0.5, Buchholz: 5.5, SonnebornBerger: 0.75, Played: 3},
+	}
+	if got := computeStandings(players, pairings); !reflect.DeepEqual(got, want) {
+		t.Errorf("standings =\n%+v\nwant\n%+v", got, want)
+	}
+}
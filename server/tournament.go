@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Tournament formats.
+const (
+	FormatSwiss      = "swiss"
+	FormatArena      = "arena"
+	FormatRoundRobin = "round-robin"
+	FormatKnockout   = "knockout"
+)
+
+// Tournament statuses.
+const (
+	TournamentRegistering = "registering"
+	TournamentRunning     = "running"
+	TournamentFinished    = "finished"
+)
+
+const (
+	maxTournamentRounds = 20
+	maxMatchGames       = 10
+	// Arena durations, in minutes.
+	minArenaDuration = 10
+	maxArenaDuration = 720
+)
+
+// TournamentService runs tournaments. Each pairing is an ordinary game
+// created through GameService; RunResults picks up finished games, pairs
+// the next Swiss or round-robin round once every board of the current one
+// is done, plays out knockout matches game by game and re-pairs arena
+// players as they become free.
+type TournamentService struct {
+	db            *sql.DB
+	gameService   *GameService
+	notifications *NotificationService
+	presence      *PresenceTracker
+	userHub       *UserHub
+	clubs         *ClubService
+}
+
+func NewTournamentService(db *sql.DB, gameService *GameService, notifications *NotificationService, presence *PresenceTracker, userHub *UserHub, clubs *ClubService) *TournamentService {
+	return &TournamentService{
+		db:            db,
+		gameService:   gameService,
+		notifications: notifications,
+		presence:      presence,
+		userHub:       userHub,
+		clubs:         clubs,
+	}
+}
+
+type CreateTournamentRequest struct {
+	Name           string     `json:"name"`
+	Format         string     `json:"format"`
+	Variant        string     `json:"variant"`
+	Rounds         int        `json:"rounds"`
+	Duration       int        `json:"duration"`
+	Cycles         int        `json:"cycles"`
+	MatchGames     int        `json:"match_games"`
+	Armageddon     bool       `json:"armageddon"`
+	ClockLimit     int        `json:"clock_limit"`
+	ClockIncrement int        `json:"clock_increment"`
+	StartsAt       *time.Time `json:"starts_at"`
+	// ClubID makes it a club event, open to members only.
+	ClubID *int `json:"club_id"`
+}
+
+type SetResultRequest struct {
+	Result string `json:"result"`
+}
+
+const tournamentColumns = `
+	t.id, t.name, t.format, t.variant, t.rounds, t.current_round, t.clock_limit, t.clock_increment,
+	t.status, t.created_by, t.starts_at, COALESCE(t.duration, 0), t.ends_at,
+	COALESCE(t.cycles, 1), COALESCE(t.match_games, 1), COALESCE(t.armageddon, FALSE), t.club_id,
+	(SELECT COUNT(*) FROM tournament_players tp WHERE tp.tournament_id = t.id AND NOT tp.withdrawn),
+	t.created_at, t.updated_at
+`
+
+func scanTournament(row rowScanner) (*Tournament, error) {
+	t := &Tournament{}
+	err := row.Scan(&t.ID, &t.Name, &t.Format, &t.Variant, &t.Rounds, &t.CurrentRound,
+		&t.ClockLimit, &t.ClockIncrement, &t.Status, &t.CreatedBy, &t.StartsAt, &t.Duration, &t.EndsAt,
+		&t.Cycles, &t.MatchGames, &t.Armageddon, &t.ClubID, &t.Players,
+		&t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (ts *TournamentService) getTournament(id int) (*Tournament, error) {
+	return scanTournament(ts.db.QueryRow(`SELECT `+tournamentColumns+` FROM tournaments t WHERE t.id = $1`, id))
+}
+
+// tournamentFromPath loads the tournament in the path, writing the error
+// response if it cannot. Club events are hidden from non-members.
+func (ts *TournamentService) tournamentFromPath(w http.ResponseWriter, r *http.Request) (*Tournament, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid tournament ID", http.StatusBadRequest)
+		return nil, false
+	}
+	t, err := ts.getTournament(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Tournament not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Error fetching tournament:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if t.ClubID != nil && !ts.clubs.IsMember(*t.ClubID, currentUser(r).ID) {
+		http.Error(w, "Tournament not found", http.StatusNotFound)
+		return nil, false
+	}
+	return t, true
+}
+
+func (ts *TournamentService) CreateTournament(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var req CreateTournamentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Format == "" {
+		req.Format = FormatSwiss
+	}
+	if req.Variant == "" {
+		req.Variant = VariantStandard
+	}
+	if req.Cycles == 0 {
+		req.Cycles = 1
+	}
+	if req.MatchGames == 0 {
+		req.MatchGames = 1
+	}
+
+	switch {
+	case req.Name == "":
+		http.Error(w, "A name is required", http.StatusBadRequest)
+		return
+	case req.Format != FormatSwiss && req.Format != FormatArena && req.Format != FormatRoundRobin && req.Format != FormatKnockout:
+		http.Error(w, "Unknown tournament format", http.StatusBadRequest)
+		return
+	case req.Format == FormatSwiss && (req.Rounds < 1 || req.Rounds > maxTournamentRounds):
+		http.Error(w, fmt.Sprintf("Rounds must be between 1 and %d", maxTournamentRounds), http.StatusBadRequest)
+		return
+	case req.Format == FormatArena && (req.Duration < minArenaDuration || req.Duration > maxArenaDuration):
+		http.Error(w, fmt.Sprintf("Duration must be between %d and %d minutes", minArenaDuration, maxArenaDuration), http.StatusBadRequest)
+		return
+	case req.Format == FormatArena && req.ClockLimit <= 0:
+		http.Error(w, "Arenas need a time control", http.StatusBadRequest)
+		return
+	case req.Format == FormatRoundRobin && req.Cycles != 1 && req.Cycles != 2:
+		http.Error(w, "Cycles must be 1 or 2", http.StatusBadRequest)
+		return
+	case req.Format == FormatKnockout && (req.MatchGames < 1 || req.MatchGames > maxMatchGames):
+		http.Error(w, fmt.Sprintf("Match games must be between 1 and %d", maxMatchGames), http.StatusBadRequest)
+		return
+	case req.Armageddon && (req.Format != FormatKnockout || req.ClockLimit <= 0):
+		http.Error(w, "Armageddon needs a timed knockout", http.StatusBadRequest)
+		return
+	case req.ClockLimit < 0 || req.ClockIncrement < 0:
+		http.Error(w, "Invalid time control", http.StatusBadRequest)
+		return
+	case req.Variant == VariantBughouse:
+		http.Error(w, "Bughouse cannot be played in a tournament", http.StatusBadRequest)
+		return
+	case req.ClubID != nil && !ts.clubs.IsAdmin(*req.ClubID, user.ID):
+		http.Error(w, "Only club admins can create club events", http.StatusForbidden)
+		return
+	}
+	if _, err := LookupVariant(req.Variant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Round-robin and knockout rounds follow from the number of players.
+	if req.Format != FormatSwiss {
+		req.Rounds = 0
+	}
+	if req.Format != FormatArena {
+		req.Duration = 0
+	}
+	if req.Format != FormatRoundRobin {
+		req.Cycles = 1
+	}
+	if req.Format != FormatKnockout {
+		req.MatchGames = 1
+	}
+
+	var id int
+	err := ts.db.QueryRow(`
+        INSERT INTO tournaments (name, format, variant, rounds, duration, cycles, match_games, armageddon,
+                                 clock_limit, clock_increment, created_by, starts_at, club_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+        RETURNING id
+    `, req.Name, req.Format, req.Variant, req.Rounds, req.Duration, req.Cycles, req.MatchGames, req.Armageddon,
+		req.ClockLimit, req.ClockIncrement, user.ID, req.StartsAt, req.ClubID).Scan(&id)
+	if err != nil {
+		log.Println("Error creating tournament:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := ts.getTournament(id)
+	if err != nil {
+		log.Println("Error fetching tournament:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// ListTournaments lists recent tournaments, optionally filtered by ?status=
+// or to one club's events by ?club=. Club events are only listed to members.
+func (ts *TournamentService) ListTournaments(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	status := r.URL.Query().Get("status")
+	club, _ := strconv.Atoi(r.URL.Query().Get("club"))
+
+	rows, err := ts.db.Query(`
+        SELECT `+tournamentColumns+`
+        FROM tournaments t
+        WHERE ($1 = '' OR t.status = $1)
+          AND ($2 = 0 OR t.club_id = $2)
+          AND (t.club_id IS NULL OR t.club_id IN (SELECT club_id FROM club_members WHERE user_id = $3))
+        ORDER BY t.created_at DESC
+        LIMIT 50
+    `, status, club, user.ID)
+	if err != nil {
+		log.Println("Error fetching tournaments:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tournaments := []Tournament{}
+	for rows.Next() {
+		t, err := scanTournament(rows)
+		if err != nil {
+			log.Println("Error scanning tournament:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		tournaments = append(tournaments, *t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tournaments)
+}
+
+// GetTournament returns the tournament with its standings and the pairings
+// of the current round, or for an arena the games in progress.
+func (ts *TournamentService) GetTournament(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	standings, err := ts.standings(t)
+	if err != nil {
+		log.Println("Error computing standings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	round := t.CurrentRound
+	if t.Format == FormatArena {
+		round = 0
+	}
+	pairings, err := ts.pairings(t.ID, round)
+	if err != nil {
+		log.Println("Error fetching pairings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if t.Format == FormatArena {
+		playing := []TournamentPairing{}
+		for _, p := range pairings {
+			if p.Result == nil {
+				playing = append(playing, p)
+			}
+		}
+		pairings = playing
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tournament": t,
+		"standings":  standings,
+		"pairings":   pairings,
+	})
+}
+
+func (ts *TournamentService) GetStandings(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	standings, err := ts.standings(t)
+	if err != nil {
+		log.Println("Error computing standings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(standings)
+}
+
+func (ts *TournamentService) GetRound(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	round, err := strconv.Atoi(mux.Vars(r)["round"])
+	if err != nil || round < 1 || round > t.CurrentRound {
+		http.Error(w, "Round not found", http.StatusNotFound)
+		return
+	}
+
+	pairings, err := ts.pairings(t.ID, round)
+	if err != nil {
+		log.Println("Error fetching pairings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pairings)
+}
+
+func (ts *TournamentService) JoinTournament(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if t.Status != TournamentRegistering {
+		http.Error(w, "Registration is closed", http.StatusConflict)
+		return
+	}
+
+	if _, err := ts.db.Exec(`
+        INSERT INTO tournament_players (tournament_id, user_id)
+        VALUES ($1, $2)
+        ON CONFLICT (tournament_id, user_id) DO UPDATE SET withdrawn = FALSE
+    `, t.ID, user.ID); err != nil {
+		log.Println("Error joining tournament:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveTournament unregisters the user before the start, or withdraws them
+// from later rounds once it is running.
+func (ts *TournamentService) LeaveTournament(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	query := `UPDATE tournament_players SET withdrawn = TRUE WHERE tournament_id = $1 AND user_id = $2`
+	if t.Status == TournamentRegistering {
+		query = `DELETE FROM tournament_players WHERE tournament_id = $1 AND user_id = $2`
+	}
+	result, err := ts.db.Exec(query, t.ID, user.ID)
+	if err != nil {
+		log.Println("Error leaving tournament:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Not registered", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartTournament closes registration and pairs the first round. Only the
+// creator may start it; tournaments with starts_at also start on their own.
+func (ts *TournamentService) StartTournament(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case t.CreatedBy != user.ID:
+		http.Error(w, "Only the organiser can start the tournament", http.StatusForbidden)
+		return
+	case t.Status != TournamentRegistering:
+		http.Error(w, "Tournament has already started", http.StatusConflict)
+		return
+	case t.Players < 2:
+		http.Error(w, "At least two players are needed", http.StatusConflict)
+		return
+	}
+
+	if err := ts.start(t); err != nil {
+		log.Println("Error starting tournament:", err)
+		http.Error(w, "Failed to start tournament", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := ts.getTournament(t.ID)
+	if err != nil {
+		log.Println("Error fetching tournament:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// SetResult lets the organiser record a result by hand, for example when a
+// player did not show up.
+func (ts *TournamentService) SetResult(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if t.CreatedBy != user.ID {
+		http.Error(w, "Only the organiser can set results", http.StatusForbidden)
+		return
+	}
+	pairingID, err := strconv.Atoi(mux.Vars(r)["pairing"])
+	if err != nil {
+		http.Error(w, "Invalid pairing ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetResultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Result != ResultWhiteWins && req.Result != ResultBlackWins && req.Result != ResultDraw {
+		http.Error(w, "Result must be 1-0, 0-1 or 1/2-1/2", http.StatusBadRequest)
+		return
+	}
+
+	// Results of finished rounds are fixed once later rounds were paired
+	// from them; arena games still running from an earlier wave may be set.
+	result, err := ts.db.Exec(`
+        UPDATE tournament_pairings SET result = $1
+        WHERE id = $2 AND tournament_id = $3 AND black_id IS NOT NULL
+          AND (round = $4 OR result IS NULL)
+    `, req.Result, pairingID, t.ID, t.CurrentRound)
+	if err != nil {
+		log.Println("Error setting result:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Pairing not found in the current round", http.StatusNotFound)
+		return
+	}
+
+	if err := ts.advance(t.ID, true); err != nil {
+		log.Println("Error advancing tournament:", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// standings computes the current standings of a tournament.
+func (ts *TournamentService) standings(t *Tournament) ([]TournamentStanding, error) {
+	players, err := ts.players(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	pairings, err := ts.pairings(t.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Format {
+	case FormatArena:
+		return computeArenaStandings(players, pairings), nil
+	case FormatKnockout:
+		return computeKnockoutStandings(t, players, pairings), nil
+	}
+	return computeStandings(players, pairings), nil
+}
+
+// players lists everyone registered, in seed order.
+func (ts *TournamentService) players(tournamentID int) ([]TournamentStanding, error) {
+	rows, err := ts.db.Query(`
+        SELECT tp.user_id, u.name, tp.seed, tp.withdrawn
+        FROM tournament_players tp
+        JOIN users u ON u.id = tp.user_id
+        WHERE tp.tournament_id = $1
+        ORDER BY tp.seed
+    `, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := []TournamentStanding{}
+	for rows.Next() {
+		var s TournamentStanding
+		if err := rows.Scan(&s.UserID, &s.Name, &s.Seed, &s.Withdrawn); err != nil {
+			return nil, err
+		}
+		players = append(players, s)
+	}
+	return players, rows.Err()
+}
+
+// pairings returns the pairings of round, or of every round when round is 0.
+func (ts *TournamentService) pairings(tournamentID, round int) ([]TournamentPairing, error) {
+	rows, err := ts.db.Query(`
+        SELECT id, tournament_id, round, board, COALESCE(game_number, 1), COALESCE(armageddon, FALSE),
+               white_id, black_id, game_id, result, white_berserk, black_berserk
+        FROM tournament_pairings
+        WHERE tournament_id = $1 AND ($2 = 0 OR round = $2)
+        ORDER BY round, board, game_number
+    `, tournamentID, round)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairings := []TournamentPairing{}
+	for rows.Next() {
+		var p TournamentPairing
+		if err := rows.Scan(&p.ID, &p.TournamentID, &p.Round, &p.Board, &p.GameNumber, &p.Armageddon, &p.WhiteID, &p.BlackID, &p.GameID, &p.Result,
+			&p.WhiteBerserk, &p.BlackBerserk); err != nil {
+			return nil, err
+		}
+		pairings = append(pairings, p)
+	}
+	return pairings, rows.Err()
+}
+
+// start tells the players the tournament is starting and pairs round one,
+// or opens the arena.
+func (ts *TournamentService) start(t *Tournament) error {
+	standings, err := ts.standings(t)
+	if err != nil {
+		return err
+	}
+	for _, s := range standings {
+		if !s.Withdrawn {
+			ts.notifications.Notify(s.UserID, NotifyTournamentStarting, map[string]interface{}{"tournament": t})
+		}
+	}
+	switch t.Format {
+	case FormatArena:
+		return ts.startArena(t)
+	case FormatRoundRobin:
+		if err := ts.setRounds(t, roundRobinRounds(t.Players, t.Cycles)); err != nil {
+			return err
+		}
+	case FormatKnockout:
+		if err := ts.setRounds(t, knockoutRounds(t.Players)); err != nil {
+			return err
+		}
+		return ts.advanceKnockout(t)
+	}
+	return ts.nextRound(t)
+}
+
+// setRounds fixes the number of rounds of a format whose length depends on
+// the field.
+func (ts *TournamentService) setRounds(t *Tournament, rounds int) error {
+	if _, err := ts.db.Exec(`UPDATE tournaments SET rounds = $1 WHERE id = $2 AND status = $3`,
+		rounds, t.ID, TournamentRegistering); err != nil {
+		return err
+	}
+	t.Rounds = rounds
+	return nil
+}
+
+// withdrawn returns the players who have left t.
+func (ts *TournamentService) withdrawn(tournamentID int) (map[int]bool, error) {
+	ids, err := queryIDs(ts.db, `
+        SELECT user_id FROM tournament_players WHERE tournament_id = $1 AND withdrawn
+    `, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	withdrawn := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		withdrawn[id] = true
+	}
+	return withdrawn, nil
+}
+
+// nextRound pairs the round after t.CurrentRound, or finishes the
+// tournament when all rounds are played or no legal pairing is left.
+func (ts *TournamentService) nextRound(t *Tournament) error {
+	if t.CurrentRound >= t.Rounds {
+		return ts.finish(t)
+	}
+
+	round := t.CurrentRound + 1
+	var pairs []swissPairing
+	var bye int
+	if t.Format == FormatRoundRobin {
+		var err error
+		if pairs, err = ts.roundRobinPairs(t, round); err != nil {
+			return err
+		}
+	} else {
+		entrants, err := ts.swissEntrants(t)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		pairs, bye, ok = pairSwiss(entrants)
+		if !ok || len(pairs) == 0 {
+			return ts.finish(t)
+		}
+	}
+
+	withdrawn, err := ts.withdrawn(t.ID)
+	if err != nil {
+		return err
+	}
+
+	// Claim the round and record its pairings in one transaction, so that
+	// a concurrent caller cannot pair it twice and a failure part of the way
+	// leaves it unpaired. Games created for a rolled back round are deleted.
+	tx, err := ts.db.Begin()
+	if err != nil {
+		return err
+	}
+	var created []string
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+			ts.discardGames(created)
+		}
+	}()
+	if claimed, err := claimRound(tx, t, round); err != nil || !claimed {
+		return err
+	}
+
+	var paired []TournamentPairing
+	for i, p := range pairs {
+		// Round-robin games against a withdrawn player are forfeited.
+		if withdrawn[p.White] || withdrawn[p.Black] {
+			if withdrawn[p.White] && withdrawn[p.Black] {
+				continue
+			}
+			result := ResultWhiteWins
+			if withdrawn[p.White] {
+				result = ResultBlackWins
+			}
+			black := p.Black
+			pairing := TournamentPairing{TournamentID: t.ID, Round: round, Board: i + 1, WhiteID: p.White, BlackID: &black, Result: &result}
+			if err := insertPairing(tx, &pairing); err != nil {
+				return err
+			}
+			continue
+		}
+		gameID, err := ts.createPairingGame(t, p.White, p.Black)
+		if err != nil {
+			return err
+		}
+		created = append(created, gameID)
+		black := p.Black
+		pairing := TournamentPairing{TournamentID: t.ID, Round: round, Board: i + 1, WhiteID: p.White, BlackID: &black, GameID: &gameID}
+		if err := insertPairing(tx, &pairing); err != nil {
+			return err
+		}
+		paired = append(paired, pairing)
+	}
+
+	if bye != 0 {
+		result := ResultBye
+		pairing := TournamentPairing{TournamentID: t.ID, Round: round, Board: len(pairs) + 1, WhiteID: bye, Result: &result}
+		if err := insertPairing(tx, &pairing); err != nil {
+			return err
+		}
+		paired = append(paired, pairing)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+
+	for _, pairing := range paired {
+		players := []int{pairing.WhiteID}
+		if pairing.BlackID != nil {
+			players = append(players, *pairing.BlackID)
+		}
+		for _, userID := range players {
+			ts.notifications.Notify(userID, NotifyTournamentRound, map[string]interface{}{
+				"tournament": t,
+				"pairing":    pairing,
+			})
+		}
+	}
+	return nil
+}
+
+// discardGames deletes the games of pairings that were rolled back.
+func (ts *TournamentService) discardGames(gameIDs []string) {
+	for _, id := range gameIDs {
+		if _, err := ts.db.Exec(`DELETE FROM games WHERE id = $1`, id); err != nil {
+			log.Println("Error deleting unpaired game:", err)
+		}
+	}
+}
+
+// querier is the part of *sql.DB and *sql.Tx the pairing helpers need.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func insertPairing(q querier, p *TournamentPairing) error {
+	if p.GameNumber == 0 {
+		p.GameNumber = 1
+	}
+	return q.QueryRow(`
+        INSERT INTO tournament_pairings (tournament_id, round, board, game_number, armageddon, white_id, black_id, game_id, result)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id
+    `, p.TournamentID, p.Round, p.Board, p.GameNumber, p.Armageddon, p.WhiteID, p.BlackID, p.GameID, p.Result).Scan(&p.ID)
+}
+
+// claimRound moves t from round-1 to round and marks it running. It
+// reports false if a concurrent caller got there first.
+func claimRound(q querier, t *Tournament, round int) (bool, error) {
+	result, err := q.Exec(`
+        UPDATE tournaments
+        SET current_round = $1, status = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3 AND current_round = $4
+    `, round, TournamentRunning, t.ID, round-1)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	t.CurrentRound = round
+	t.Status = TournamentRunning
+	return true, nil
+}
+
+// createPairingGame creates the game for a pairing with both players seated.
+func (ts *TournamentService) createPairingGame(t *Tournament, white, black int) (string, error) {
+	fen, err := startFEN(t.Variant, -1)
+	if err != nil {
+		return "", err
+	}
+	game, err := ts.gameService.CreateGame(white, newGameID(), GameOptions{
+		Variant:        t.Variant,
+		StartFEN:       fen,
+		ClockLimit:     t.ClockLimit,
+		ClockIncrement: t.ClockIncrement,
+		Rated:          true,
+		TournamentID:   &t.ID,
+		Berserkable:    t.Format == FormatArena,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := ts.gameService.JoinGame(game.ID, black); err != nil {
+		return "", err
+	}
+	return game.ID, nil
+}
+
+// swissEntrants builds the pairing history of every player still in the
+// tournament.
+func (ts *TournamentService) swissEntrants(t *Tournament) ([]*swissEntrant, error) {
+	standings, err := ts.standings(t)
+	if err != nil {
+		return nil, err
+	}
+	pairings, err := ts.pairings(t.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return swissHistory(standings, pairings), nil
+}
+
+// swissHistory builds the entrants for the players in standings who have not
+// withdrawn from the pairings played so far.
+func swissHistory(standings []TournamentStanding, pairings []TournamentPairing) []*swissEntrant {
+	byID := make(map[int]*swissEntrant, len(standings))
+	var entrants []*swissEntrant
+	for _, s := range standings {
+		e := &swissEntrant{ID: s.UserID, Seed: s.Seed, Score: s.Score, Opponents: make(map[int]bool)}
+		byID[s.UserID] = e
+		if !s.Withdrawn {
+			entrants = append(entrants, e)
+		}
+	}
+
+	for _, p := range pairings {
+		white := byID[p.WhiteID]
+		if p.BlackID == nil {
+			if white != nil {
+				white.HadBye = true
+			}
+			continue
+		}
+		black := byID[*p.BlackID]
+		if white != nil {
+			white.Opponents[*p.BlackID] = true
+			white.Colors = append(white.Colors, "white")
+		}
+		if black != nil {
+			black.Opponents[p.WhiteID] = true
+			black.Colors = append(black.Colors, "black")
+		}
+	}
+	return entrants
+}
+
+func (ts *TournamentService) finish(t *Tournament) error {
+	result, err := ts.db.Exec(`
+        UPDATE tournaments SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status != $1
+    `, TournamentFinished, t.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+	t.Status = TournamentFinished
+
+	standings, err := ts.standings(t)
+	if err != nil {
+		return err
+	}
+	for _, s := range standings {
+		ts.notifications.Notify(s.UserID, NotifyTournamentFinished, map[string]interface{}{
+			"tournament": t,
+			"rank":       s.Rank,
+			"score":      s.Score,
+		})
+	}
+	return nil
+}
+
+// advance pairs the next round if every board of the current one has a
+// result. Arenas pair free players instead; changed says results came in
+// since the last call.
+func (ts *TournamentService) advance(tournamentID int, changed bool) error {
+	t, err := ts.getTournament(tournamentID)
+	if err != nil {
+		return err
+	}
+	if t.Status != TournamentRunning {
+		return nil
+	}
+	switch t.Format {
+	case FormatArena:
+		return ts.advanceArena(t, changed)
+	case FormatKnockout:
+		return ts.advanceKnockout(t)
+	}
+
+	var pending bool
+	err = ts.db.QueryRow(`
+        SELECT EXISTS(SELECT 1 FROM tournament_pairings WHERE tournament_id = $1 AND round = $2 AND result IS NULL)
+    `, t.ID, t.CurrentRound).Scan(&pending)
+	if err != nil || pending {
+		return err
+	}
+	return ts.nextRound(t)
+}
+
+// RunResults records finished tournament games, advances rounds and starts
+// scheduled tournaments every interval.
+func (ts *TournamentService) RunResults(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := ts.collectResults()
+		if err != nil {
+			log.Println("Tournament results:", err)
+		}
+		if err := ts.advanceAll(changed); err != nil {
+			log.Println("Tournament rounds:", err)
+		}
+		if err := ts.startScheduled(); err != nil {
+			log.Println("Tournament start:", err)
+		}
+	}
+}
+
+// collectResults copies the outcome and berserks of finished games onto
+// their pairings and returns the tournaments that got new results. Games
+// that end after their tournament has finished do not count.
+func (ts *TournamentService) collectResults() (map[int]bool, error) {
+	changed := make(map[int]bool)
+	rows, err := ts.db.Query(`
+        UPDATE tournament_pairings p
+        SET result = CASE g.winner WHEN 'white' THEN $1 WHEN 'black' THEN $2 ELSE $3 END,
+            white_berserk = COALESCE(g.white_berserk, FALSE),
+            black_berserk = COALESCE(g.black_berserk, FALSE)
+        FROM games g, tournaments t
+        WHERE g.id = p.game_id AND t.id = p.tournament_id
+          AND p.result IS NULL AND g.status = 'completed' AND t.status = $4
+        RETURNING p.tournament_id
+    `, ResultWhiteWins, ResultBlackWins, ResultDraw, TournamentRunning)
+	if err != nil {
+		return changed, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return changed, err
+		}
+		changed[id] = true
+	}
+	return changed, rows.Err()
+}
+
+func (ts *TournamentService) advanceAll(changed map[int]bool) error {
+	ids, err := queryIDs(ts.db, `SELECT id FROM tournaments WHERE status = $1`, TournamentRunning)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ts.advance(id, changed[id]); err != nil {
+			log.Printf("Failed to advance tournament %d: %v\n", id, err)
+		}
+	}
+	return nil
+}
+
+// startScheduled starts tournaments whose starts_at has passed.
+func (ts *TournamentService) startScheduled() error {
+	ids, err := queryIDs(ts.db, `
+        SELECT id FROM tournaments
+        WHERE status = $1 AND starts_at IS NOT NULL AND starts_at <= CURRENT_TIMESTAMP
+    `, TournamentRegistering)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		t, err := ts.getTournament(id)
+		if err != nil {
+			return err
+		}
+		if t.Players < 2 {
+			if err := ts.finish(t); err != nil {
+				log.Printf("Failed to cancel tournament %d: %v\n", id, err)
+			}
+			continue
+		}
+		if err := ts.start(t); err != nil {
+			log.Printf("Failed to start tournament %d: %v\n", id, err)
+		}
+	}
+	return nil
+}
+
+// queryIDs runs a query returning one integer column.
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// BuildTRF renders a Swiss or round-robin tournament as a FIDE TRF-16
+// report. Players are numbered by seed; each round takes ten columns with
+// the opponent's number, the colour and the result.
+func BuildTRF(t *Tournament, standings []TournamentStanding, pairings []TournamentPairing) string {
+	bySeed := append([]TournamentStanding(nil), standings...)
+	sort.Slice(bySeed, func(i, j int) bool { return bySeed[i].Seed < bySeed[j].Seed })
+	number := make(map[int]int, len(bySeed))
+	for i, s := range bySeed {
+		number[s.UserID] = i + 1
+	}
+
+	// games[userID][round] is the player's pairing in that round.
+	games := make(map[int]map[int]TournamentPairing)
+	for _, p := range pairings {
+		for _, id := range []*int{&p.WhiteID, p.BlackID} {
+			if id == nil {
+				continue
+			}
+			if games[*id] == nil {
+				games[*id] = make(map[int]TournamentPairing)
+			}
+			games[*id][p.Round] = p
+		}
+	}
+
+	var sb strings.Builder
+	line := func(code, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s %s\n", code, value)
+		}
+	}
+	start := t.CreatedAt
+	if t.StartsAt != nil {
+		start = *t.StartsAt
+	}
+	kind := "Swiss System"
+	if t.Format == FormatRoundRobin {
+		kind = "Round Robin"
+		if t.Cycles == 2 {
+			kind = "Double Round Robin"
+		}
+	}
+
+	line("012", t.Name)
+	line("042", start.Format("2006/01/02"))
+	if t.Status == TournamentFinished {
+		line("052", t.UpdatedAt.Format("2006/01/02"))
+	}
+	line("062", strconv.Itoa(len(bySeed)))
+	line("072", "0")
+	line("092", kind)
+	if t.ClockLimit > 0 {
+		line("122", fmt.Sprintf("%d+%d", t.ClockLimit, t.ClockIncrement))
+	}
+	line("XXR", strconv.Itoa(t.Rounds))
+
+	for _, s := range bySeed {
+		name := s.Name
+		if runes := []rune(name); len(runes) > 33 {
+			name = string(runes[:33])
+		}
+		fmt.Fprintf(&sb, "001 %4d %1s%3s %-33s %4s %3s %11s %10s %4.1f %4d",
+			number[s.UserID], "", "", name, "", "", "", "", s.Score, s.Rank)
+		for round := 1; round <= t.CurrentRound; round++ {
+			p, ok := games[s.UserID][round]
+			if !ok {
+				sb.WriteString("  0000 - Z")
+				continue
+			}
+			opponent, color, result := trfGame(p, s.UserID)
+			if opponent == 0 {
+				fmt.Fprintf(&sb, "  0000 - %s", result)
+			} else {
+				fmt.Fprintf(&sb, "  %4d %s %s", number[opponent], color, result)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// trfGame returns userID's opponent, colour and TRF result code in p. The
+// opponent is 0 for a bye. Games without a game record were forfeited.
+func trfGame(p TournamentPairing, userID int) (opponent int, color, result string) {
+	if p.BlackID == nil {
+		return 0, "-", "U"
+	}
+	opponent, color = *p.BlackID, "w"
+	if p.WhiteID != userID {
+		opponent, color = p.WhiteID, "b"
+	}
+	if p.Result == nil {
+		return opponent, color, " "
+	}
+
+	white, black := resultPoints(*p.Result)
+	points := white
+	if color == "b" {
+		points = black
+	}
+	switch {
+	case p.GameID == nil && points == 1:
+		return opponent, color, "+"
+	case p.GameID == nil && points == 0:
+		return opponent, color, "-"
+	case points == 1:
+		return opponent, color, "1"
+	case points == 0.5:
+		return opponent, color, "="
+	}
+	return opponent, color, "0"
+}
+
+// ExportTRF downloads a Swiss or round-robin tournament as a TRF-16 file.
+func (ts *TournamentService) ExportTRF(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if t.Format != FormatSwiss && t.Format != FormatRoundRobin {
+		http.Error(w, "TRF export is only available for Swiss and round-robin tournaments", http.StatusBadRequest)
+		return
+	}
+
+	standings, err := ts.standings(t)
+	if err != nil {
+		log.Println("Error computing standings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	pairings, err := ts.pairings(t.ID, 0)
+	if err != nil {
+		log.Println("Error fetching pairings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("tournament-%d.trf", t.ID)))
+	fmt.Fprint(w, BuildTRF(t, standings, pairings))
+}
+
+// crosstableColumns lays the results of each row out in columns: one per
+// round, or for an arena one per game. Several games in a round, as in a
+// knockout mini-match, share a cell.
+func crosstableColumns(t *Tournament, rows []CrosstableRow) (headers []string, cells [][]string) {
+	cells = make([][]string, len(rows))
+	if t.Format == FormatArena {
+		most := 0
+		for i, row := range rows {
+			for _, res := range row.Results {
+				cells[i] = append(cells[i], crosstableCell(res))
+			}
+			most = max(most, len(row.Results))
+		}
+		for i := range cells {
+			for len(cells[i]) < most {
+				cells[i] = append(cells[i], "")
+			}
+		}
+		for g := 1; g <= most; g++ {
+			headers = append(headers, strconv.Itoa(g))
+		}
+		return headers, cells
+	}
+
+	for round := 1; round <= t.CurrentRound; round++ {
+		headers = append(headers, "R"+strconv.Itoa(round))
+	}
+	for i, row := range rows {
+		cells[i] = make([]string, t.CurrentRound)
+		for _, res := range row.Results {
+			if res.Round < 1 || res.Round > t.CurrentRound {
+				continue
+			}
+			cell := &cells[i][res.Round-1]
+			if *cell != "" {
+				*cell += " "
+			}
+			*cell += crosstableCell(res)
+		}
+	}
+	return headers, cells
+}
+
+// crosstableCell writes a result as opponent rank, colour and points, e.g.
+// "12w½", or "+" for a bye.
+func crosstableCell(res CrosstableResult) string {
+	if res.OpponentID == nil {
+		return res.Points
+	}
+	return fmt.Sprintf("%d%s%s", res.OpponentRank, res.Color[:1], res.Points)
+}
+
+func writeCrosstableCSV(w http.ResponseWriter, t *Tournament, rows []CrosstableRow) {
+	headers, cells := crosstableColumns(t, rows)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("tournament-%d-crosstable.csv", t.ID)))
+	out := csv.NewWriter(w)
+	out.Write(append(append([]string{"Rank", "Name"}, headers...), "Score", "Buchholz", "Sonneborn-Berger"))
+	for i, row := range rows {
+		record := append([]string{strconv.Itoa(row.Rank), row.Name}, cells[i]...)
+		record = append(record,
+			strconv.FormatFloat(row.Score, 'f', -1, 64),
+			strconv.FormatFloat(row.Buchholz, 'f', -1, 64),
+			strconv.FormatFloat(row.SonnebornBerger, 'f', -1, 64))
+		out.Write(record)
+	}
+	out.Flush()
+}
+
+func writeCrosstableHTML(w http.ResponseWriter, t *Tournament, rows []CrosstableRow) {
+	headers, cells := crosstableColumns(t, rows)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(t.Name))
+	fmt.Fprintf(&sb, "<h1>%s</h1>\n<table>\n<thead>\n<tr><th>Rank</th><th>Name</th>", html.EscapeString(t.Name))
+	for _, h := range headers {
+		fmt.Fprintf(&sb, "<th>%s</th>", html.EscapeString(h))
+	}
+	sb.WriteString("<th>Score</th><th>Buchholz</th><th>Sonneborn-Berger</th></tr>\n</thead>\n<tbody>\n")
+	for i, row := range rows {
+		fmt.Fprintf(&sb, "<tr><td>%d</td><td>%s</td>", row.Rank, html.EscapeString(row.Name))
+		for _, cell := range cells[i] {
+			fmt.Fprintf(&sb, "<td>%s</td>", html.EscapeString(cell))
+		}
+		fmt.Fprintf(&sb, "<td>%g</td><td>%g</td><td>%g</td></tr>\n", row.Score, row.Buchholz, row.SonnebornBerger)
+	}
+	sb.WriteString("</tbody>\n</table>\n</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// ExportRoundPGN downloads every game of a round as one PGN file. Round
+// tags are "round.board", with the game number added in knockouts.
+func (ts *TournamentService) ExportRoundPGN(w http.ResponseWriter, r *http.Request) {
+	t, ok := ts.tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	round, err := strconv.Atoi(mux.Vars(r)["round"])
+	if err != nil || round < 1 || round > t.CurrentRound {
+		http.Error(w, "Round not found", http.StatusNotFound)
+		return
+	}
+
+	pairings, err := ts.pairings(t.ID, round)
+	if err != nil {
+		log.Println("Error fetching pairings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var sb strings.Builder
+	for _, p := range pairings {
+		if p.GameID == nil {
+			continue
+		}
+		game, err := ts.gameService.GetGame(*p.GameID)
+		if err != nil {
+			log.Println("Error fetching game:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		moves, err := ts.gameService.GetGameMoves(*p.GameID)
+		if err != nil {
+			log.Println("Error fetching moves:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		tag := fmt.Sprintf("%d.%d", p.Round, p.Board)
+		if t.Format == FormatKnockout {
+			tag += fmt.Sprintf(".%d", p.GameNumber)
+		}
+		pgn, err := BuildEventPGN(game, moves, t.Name, tag)
+		if err != nil {
+			log.Println("Error building PGN:", err)
+			http.Error(w, "Failed to build PGN", http.StatusInternalServerError)
+			return
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(pgn)
+	}
+
+	w.Header().Set("Content-Type", "application/x-chess-pgn")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("tournament-%d-round-%d.pgn", t.ID, round)))
+	fmt.Fprint(w, sb.String())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuildTRF pins the fixed-width TRF-16 layout: the start rank in columns
+// 5-8, the name from column 15, points and rank in 81-84 and 86-89, and ten
+// columns per round, the first round's opponent in columns 92-95.
+func TestBuildTRF(t *testing.T) {
+	starts := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	tournament := &Tournament{
+		Name:           "Spring Swiss",
+		Format:         FormatSwiss,
+		Status:         TournamentRunning,
+		Rounds:         3,
+		CurrentRound:   2,
+		ClockLimit:     300,
+		ClockIncrement: 3,
+		StartsAt:       &starts,
+	}
+	standings := []TournamentStanding{
+		{UserID: 30, Name: "Carol", Seed: 3, Score: 2, Rank: 1},
+		{UserID: 40, Name: "Dave", Seed: 4, Score: 1, Rank: 2},
+		{UserID: 20, Name: "Bob", Seed: 2, Score: 0.5, Rank: 3},
+		{UserID: 10, Name: "Alice", Seed: 1, Score: 0.5, Rank: 4},
+	}
+	gameID := "game"
+	played := func(round, white, black int, result string) TournamentPairing {
+		p := pairing(round, white, black, result)
+		p.GameID = &gameID
+		return p
+	}
+	pairings := []TournamentPairing{
+		played(1, 10, 20, ResultDraw),
+		pairing(1, 30, 0, ResultBye),
+		// Dave joined after round 1, and Alice forfeited round 2.
+		pairing(2, 30, 10, ResultWhiteWins),
+		played(2, 20, 40, ResultBlackWins),
+	}
+
+	want := strings.Join([]string{
+		"012 Spring Swiss",
+		"042 2026/03/01",
+		"062 4",
+		"072 0",
+		"092 Swiss System",
+		"122 300+3",
+		"XXR 3",
+		"001    1      Alice                                                              0.5    4     2 w =     3 b -",
+		"001    2      Bob                                                                0.5    3     1 b =     4 w 0",
+		"001    3      Carol                                                              2.0    1  0000 - U     1 w +",
+		"001    4      Dave                                                               1.0    2  0000 - Z     2 b 1",
+		"",
+	}, "\n")
+	if got := BuildTRF(tournament, standings, pairings); got != want {
+		t.Errorf("BuildTRF =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// UserConn is a user-level socket, open for as long as the user has the site
+// open, independent of any game room.
+type UserConn struct {
+	Conn *websocket.Conn
+	User *User
+	Send chan []byte
+}
+
+// userMessage is pushed to every socket of a user.
+type userMessage struct {
+	UserID int
+	Data   []byte
+}
+
+// UserHub tracks the user-level sockets of each user.
+type UserHub struct {
+	Users      map[int]map[*UserConn]bool
+	Register   chan *UserConn
+	Unregister chan *UserConn
+	Notify     chan userMessage
+	Disconnect chan int
+}
+
+func NewUserHub() *UserHub {
+	return &UserHub{
+		Users:      make(map[int]map[*UserConn]bool),
+		Register:   make(chan *UserConn),
+		Unregister: make(chan *UserConn),
+		Notify:     make(chan userMessage, 256),
+		Disconnect: make(chan int, 16),
+	}
+}
+
+func (h *UserHub) Run() {
+	for {
+		select {
+		case conn := <-h.Register:
+			if h.Users[conn.User.ID] == nil {
+				h.Users[conn.User.ID] = make(map[*UserConn]bool)
+			}
+			h.Users[conn.User.ID][conn] = true
+
+		case conn := <-h.Unregister:
+			if conns, ok := h.Users[conn.User.ID]; ok && conns[conn] {
+				delete(conns, conn)
+				close(conn.Send)
+				if len(conns) == 0 {
+					delete(h.Users, conn.User.ID)
+				}
+			}
+
+		case n := <-h.Notify:
+			for conn := range h.Users[n.UserID] {
+				select {
+				case conn.Send <- n.Data:
+				default:
+					log.Printf("Dropping notification for user %d: send buffer full\n", n.UserID)
+				}
+			}
+
+		case userID := <-h.Disconnect:
+			// readPump sees the closed socket and unregisters it.
+			for conn := range h.Users[userID] {
+				conn.Conn.Close()
+			}
+		}
+	}
+}
+
+// Send queues v as JSON for userID's sockets. Users without an open socket
+// miss it; NotificationService keeps the inbox for them.
+func (h *UserHub) Send(userID int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Failed to marshal notification:", err)
+		return
+	}
+	h.Notify <- userMessage{UserID: userID, Data: data}
+}
+
+func ServeUserWs(hub *UserHub, presence *PresenceTracker, w http.ResponseWriter, r *http.Request, authService *AuthService) {
+	user := authService.getUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("WebSocket upgrade error:", err)
+		return
+	}
+
+	uc := &UserConn{
+		Conn: conn,
+		User: user,
+		Send: make(chan []byte, 64),
+	}
+	hub.Register <- uc
+	presence.UserConnected(user.ID)
+
+	go uc.writePump()
+	go uc.readPump(hub, presence)
+}
+
+// readPump only watches for activity and the socket closing; clients act
+// over REST.
+func (c *UserConn) readPump(hub *UserHub, presence *PresenceTracker) {
+	defer func() {
+		hub.Unregister <- c
+		presence.UserDisconnected(c.User.ID)
+		c.Conn.Close()
+	}()
+	for {
+		if _, _, err := c.Conn.ReadMessage(); err != nil {
+			break
+		}
+		presence.Touch(c.User.ID)
+	}
+}
+
+func (c *UserConn) writePump() {
+	for msg := range c.Send {
+		err := c.Conn.WriteMessage(websocket.TextMessage, msg)
+		if err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	VariantStandard      = "standard"
+	VariantChess960      = "chess960"
+	VariantThreeCheck    = "threecheck"
+	VariantKingOfTheHill = "kingofthehill"
+	VariantCrazyhouse    = "crazyhouse"
+	VariantAtomic        = "atomic"
+	VariantAntichess     = "antichess"
+	VariantHorde         = "horde"
+	VariantBughouse      = "bughouse"
+)
+
+// Variant customises the rules layer for a chess variant. Implementations
+// embed standardRules and override only what differs.
+type Variant interface {
+	// Name is the identifier stored in games.variant.
+	Name() string
+	// PGNName is the value of the PGN Variant tag, empty for standard chess.
+	PGNName() string
+	// StartFEN returns the initial position. index picks a numbered start
+	// position for variants that have them; negative means random.
+	StartFEN(index int) (string, error)
+	// ReadFEN consumes variant-specific FEN state before the standard
+	// fields are parsed and returns the fields that are left.
+	ReadFEN(pos *Position, fields []string) ([]string, error)
+	// WriteFEN adds variant-specific state to the standard FEN fields.
+	WriteFEN(pos *Position, fields []string) []string
+	// CheckSetup rejects positions the variant cannot be played from, or
+	// drops state it does not use, once the whole FEN has been read.
+	CheckSetup(pos *Position) error
+	// AddMoves extends the pseudo-legal moves, e.g. with piece drops.
+	AddMoves(pos *Position, moves []Move) []Move
+	// AfterMove updates variant state once m has been played.
+	AfterMove(before, after *Position, m Move)
+	// KingInCheck reports whether colour c is in check in pos.
+	KingInCheck(pos *Position, c Color) bool
+	// SquareAttacked reports whether a king of the other colour would be
+	// attacked by colour by on sq, as checked along a castling path.
+	SquareAttacked(pos *Position, sq Square, by Color) bool
+	// Legal reports whether the pseudo-legal move m, which led from before
+	// to after, may be played.
+	Legal(before, after *Position, m Move) bool
+	// Outcome reports whether the game is over and the winner: "white",
+	// "black" or "draw".
+	Outcome(pos *Position) (over bool, winner string)
+}
+
+var variants = map[string]Variant{}
+
+func registerVariant(v Variant) {
+	variants[v.Name()] = v
+}
+
+func init() {
+	registerVariant(standardRules{})
+	registerVariant(chess960{})
+	registerVariant(threeCheck{})
+	registerVariant(kingOfTheHill{})
+	registerVariant(crazyhouse{})
+	registerVariant(atomic{})
+	registerVariant(antichess{})
+	registerVariant(horde{})
+	registerVariant(bughouse{})
+}
+
+func LookupVariant(name string) (Variant, error) {
+	if name == "" {
+		name = VariantStandard
+	}
+	v, ok := variants[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variant %q", name)
+	}
+	return v, nil
+}
+
+// VariantNames lists the registered variants in alphabetical order.
+func VariantNames() []string {
+	names := make([]string, 0, len(variants))
+	for name := range variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// startFEN resolves the starting position for a new game.
+func startFEN(variant string, index int) (string, error) {
+	v, err := LookupVariant(variant)
+	if err != nil {
+		return "", err
+	}
+	return v.StartFEN(index)
+}
+
+// NewGamePosition parses a game's FEN under its variant's rules.
+func NewGamePosition(variant, fen string) (*Position, error) {
+	v, err := LookupVariant(variant)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVariantFEN(v, fen)
+}
+
+type standardRules struct{}
+
+func (standardRules) Name() string {
+	return VariantStandard
+}
+
+func (standardRules) PGNName() string {
+	return ""
+}
+
+func (standardRules) StartFEN(index int) (string, error) {
+	return StartFEN, nil
+}
+
+func (standardRules) ReadFEN(pos *Position, fields []string) ([]string, error) {
+	return fields, nil
+}
+
+func (standardRules) WriteFEN(pos *Position, fields []string) []string {
+	return fields
+}
+
+func (standardRules) CheckSetup(pos *Position) error {
+	if pos.kingSquare(White) == NoSquare || pos.kingSquare(Black) == NoSquare {
+		return fmt.Errorf("both sides need a king")
+	}
+	return nil
+}
+
+func (standardRules) AddMoves(pos *Position, moves []Move) []Move {
+	return moves
+}
+
+func (standardRules) AfterMove(before, after *Position, m Move) {}
+
+func (standardRules) KingInCheck(pos *Position, c Color) bool {
+	king := pos.kingSquare(c)
+	return king != NoSquare && pos.Attacked(king, c.Other())
+}
+
+func (standardRules) SquareAttacked(pos *Position, sq Square, by Color) bool {
+	return pos.Attacked(sq, by)
+}
+
+func (v standardRules) Legal(before, after *Position, m Move) bool {
+	return !v.KingInCheck(after, before.Turn)
+}
+
+func (standardRules) Outcome(pos *Position) (bool, string) {
+	if pos.IsCheckmate() {
+		return true, pos.Turn.Other().String()
+	}
+	if pos.IsStalemate() {
+		return true, "draw"
+	}
+	return false, ""
+}
+
+// threeCheck is won by giving check three times. The FEN carries the checks
+// each side still needs as a "3+3" field after the en passant square.
+type threeCheck struct {
+	standardRules
+}
+
+const checksToWin = 3
+
+func (threeCheck) Name() string {
+	return VariantThreeCheck
+}
+
+func (threeCheck) PGNName() string {
+	return "Three-check"
+}
+
+func (threeCheck) ReadFEN(pos *Position, fields []string) ([]string, error) {
+	if len(fields) < 5 || !strings.Contains(fields[4], "+") {
+		return fields, nil
+	}
+
+	counters := strings.Split(fields[4], "+")
+	if len(counters) != 2 {
+		return nil, fmt.Errorf("invalid check counter %q", fields[4])
+	}
+	for c, counter := range counters {
+		remaining, err := strconv.Atoi(counter)
+		if err != nil || remaining < 0 || remaining > checksToWin {
+			return nil, fmt.Errorf("invalid check counter %q", fields[4])
+		}
+		pos.Checks[c] = checksToWin - remaining
+	}
+
+	return append(fields[:4:4], fields[5:]...), nil
+}
+
+func (threeCheck) WriteFEN(pos *Position, fields []string) []string {
+	counter := fmt.Sprintf("%d+%d", max(checksToWin-pos.Checks[White], 0), max(checksToWin-pos.Checks[Black], 0))
+	out := append([]string{}, fields[:4]...)
+	out = append(out, counter)
+	return append(out, fields[4:]...)
+}
+
+func (threeCheck) AfterMove(before, after *Position, m Move) {
+	if after.InCheck() {
+		after.Checks[before.Turn]++
+	}
+}
+
+func (v threeCheck) Outcome(pos *Position) (bool, string) {
+	for _, c := range []Color{White, Black} {
+		if pos.Checks[c] >= checksToWin {
+			return true, c.String()
+		}
+	}
+	return v.standardRules.Outcome(pos)
+}
+
+// kingOfTheHill is also won by bringing the king to one of the four centre
+// squares.
+type kingOfTheHill struct {
+	standardRules
+}
+
+func (kingOfTheHill) Name() string {
+	return VariantKingOfTheHill
+}
+
+func (kingOfTheHill) PGNName() string {
+	return "King of the Hill"
+}
+
+func (v kingOfTheHill) Outcome(pos *Position) (bool, string) {
+	for _, c := range []Color{pos.Turn.Other(), pos.Turn} {
+		king := pos.kingSquare(c)
+		if (king.File() == 3 || king.File() == 4) && (king.Rank() == 3 || king.Rank() == 4) {
+			return true, c.String()
+		}
+	}
+	return v.standardRules.Outcome(pos)
+}
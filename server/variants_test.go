@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestPerftVariants(t *testing.T) {
+	runPerft(t, []perftCase{
+		{"atomic startpos", VariantAtomic, StartFEN, []int{20, 400, 8902, 197326}},
+		{"atomic castle next to king", VariantAtomic, "8/8/8/8/8/8/2k5/rR4KR w KQ - 0 1", []int{18, 180, 4364}},
+		{"atomic castle with pawns", VariantAtomic, "Rr2k1rR/3K4/3p4/8/8/8/7P/8 w kq - 0 1", []int{21, 465, 10631}},
+		{"antichess startpos", VariantAntichess, antichessStartFEN, []int{20, 400, 8067, 153299}},
+		{"horde startpos", VariantHorde, "rnbqkbnr/pppppppp/8/1PP2PP1/PPPPPPPP/PPPPPPPP/PPPPPPPP/PPPPPPPP w kq - 0 1", []int{8, 128, 1274, 23310}},
+		{"crazyhouse startpos", VariantCrazyhouse, StartFEN, []int{20, 400, 8902, 197281}},
+		{"crazyhouse all drops", VariantCrazyhouse, "2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1", []int{301, 75353}},
+		{"three-check kiwipete", VariantThreeCheck, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 1+1 0 1", []int{48, 2039, 97848}},
+		{"king of the hill kiwipete", VariantKingOfTheHill, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+	})
+}
+
+func hasCastle(moves []Move) bool {
+	for _, m := range moves {
+		if m.Flags&FlagCastle != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAtomicCastlesNextToEnemyKing(t *testing.T) {
+	pos, err := NewGamePosition(VariantAtomic, "8/8/8/8/8/8/6k1/4K2R w K - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasCastle(pos.LegalMoves()) {
+		t.Error("O-O missing through squares next to the enemy king")
+	}
+}
+
+func TestAntichessDropsCastlingRights(t *testing.T) {
+	pos, err := NewGamePosition(VariantAntichess, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasCastle(pos.LegalMoves()) {
+		t.Error("antichess generated a castling move")
+	}
+	if got, want := pos.FEN(), "r3k2r/8/8/8/8/8/8/R3K2R w - - 0 1"; got != want {
+		t.Errorf("FEN() = %q, want %q", got, want)
+	}
+}
+
+func TestKingOfTheHillEndsOnCentre(t *testing.T) {
+	pos, err := NewGamePosition(VariantKingOfTheHill, "4k3/8/8/3K4/8/8/8/8 b - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if over, winner := pos.Outcome(); !over || winner != "white" {
+		t.Errorf("Outcome() = %v, %q, want true, white", over, winner)
+	}
+}